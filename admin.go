@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"telegram-group-mention-bot/bot"
+	"telegram-group-mention-bot/storage"
+)
+
+// adminCommand is a subcommand for operators which works on the database directly
+type adminCommand struct {
+	usage string
+	// dryRun adds the -dry-run flag for commands which can show their changes without applying them
+	dryRun bool
+	run    func(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error
+}
+
+// adminOutput prints results of admin commands as a table or as JSON
+type adminOutput struct {
+	w      io.Writer
+	json   bool
+	dryRun bool
+}
+
+var adminCommands = map[string]adminCommand{
+	"list-chats":    {usage: "list-chats", run: runListChats},
+	"show-group":    {usage: "show-group <chat_id> <name>", run: runShowGroup},
+	"export":        {usage: "export <chat_id> [file]", run: runExport},
+	"import":        {usage: "import <chat_id> <file>", dryRun: true, run: runImport},
+	"add-member":    {usage: "add-member <chat_id> <name> <user_id|@username>", run: runAddMember},
+	"remove-member": {usage: "remove-member <chat_id> <name> <user_id|@username>", run: runRemoveMember},
+	"vacuum":        {usage: "vacuum", run: runVacuum},
+	"stats":         {usage: "stats", run: runStats},
+}
+
+// adminUsage lists admin commands for the usage message of the binary
+func adminUsage() string {
+	return strings.Join(slices.Sorted(maps.Keys(adminCommands)), "|")
+}
+
+// runAdmin runs an admin subcommand. The schema has to be up to date, admin commands never migrate it.
+func runAdmin(ctx context.Context, dbPath string, name string, args []string) error {
+	command := adminCommands[name]
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	out := &adminOutput{w: os.Stdout}
+	flags.BoolVar(&out.json, "json", false, "Print JSON instead of a table")
+	if command.dryRun {
+		flags.BoolVar(&out.dryRun, "dry-run", false, "Show changes without applying them")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags]\n", os.Args[0], command.usage)
+		flags.PrintDefaults()
+	}
+	// Flags may follow the arguments, which start with a dash themselves for group chat IDs
+	var flagArgs, positional []string
+	for _, arg := range args {
+		if _, err := strconv.ParseInt(arg, 10, 64); strings.HasPrefix(arg, "-") && arg != "-" && err != nil {
+			flagArgs = append(flagArgs, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	if err := flags.Parse(flagArgs); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	s, err := storage.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return errors.New("database schema is not up to date, run 'migrate up' first")
+		}
+	}
+
+	if err := command.run(ctx, s, out, append(positional, flags.Args()...)); err != nil {
+		if errors.Is(err, errAdminUsage) {
+			return fmt.Errorf("usage: %s", command.usage)
+		}
+		return err
+	}
+	return nil
+}
+
+var errAdminUsage = errors.New("invalid arguments")
+
+func runListChats(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 0 {
+		return errAdminUsage
+	}
+	chats, err := s.ListChats(ctx)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.writeJSON(chats)
+	}
+
+	rows := make([][]string, 0, len(chats))
+	for _, chat := range chats {
+		rows = append(rows, []string{
+			strconv.FormatInt(chat.ChatID, 10),
+			strconv.FormatInt(chat.Groups, 10),
+			strconv.FormatInt(chat.ArchivedGroups, 10),
+			strconv.FormatInt(chat.Members, 10),
+			strconv.FormatInt(chat.SeenUsers, 10),
+			strconv.FormatInt(chat.Reminders, 10),
+		})
+	}
+	return out.writeTable([]string{"CHAT ID", "GROUPS", "ARCHIVED", "MEMBERS", "SEEN USERS", "REMINDERS"}, rows)
+}
+
+// groupDetails is the output of show-group
+type groupDetails struct {
+	ID         uint            `json:"id"`
+	ChatID     int64           `json:"chat_id"`
+	Name       string          `json:"name"`
+	ArchivedAt *time.Time      `json:"archived_at,omitempty"`
+	Aliases    []string        `json:"aliases"`
+	Includes   []string        `json:"includes"`
+	Members    []memberDetails `json:"members"`
+}
+
+type memberDetails struct {
+	Group    string `json:"group"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username,omitempty"`
+	Name     string `json:"name"`
+	Muted    bool   `json:"muted"`
+}
+
+func runShowGroup(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 2 {
+		return errAdminUsage
+	}
+	group, err := findGroup(ctx, s, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	chatID := group.ChatID
+
+	details := groupDetails{ID: group.ID, ChatID: group.ChatID, Name: group.Name, ArchivedAt: group.ArchivedAt, Aliases: []string{}, Includes: []string{}}
+	// GetGroup doesn't load aliases
+	chatGroups, err := s.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	for _, chatGroup := range chatGroups {
+		if chatGroup.ID != group.ID {
+			continue
+		}
+		for _, alias := range chatGroup.Aliases {
+			details.Aliases = append(details.Aliases, alias.Name)
+		}
+	}
+	included, err := s.GetIncludedGroups(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	for _, child := range included {
+		details.Includes = append(details.Includes, child.Name)
+	}
+	members, err := s.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	details.Members = make([]memberDetails, 0, len(members))
+	for _, member := range members {
+		details.Members = append(details.Members, newMemberDetails(group.Name, member.User, member.Muted))
+	}
+
+	if out.json {
+		return out.writeJSON(details)
+	}
+
+	archived := "no"
+	if group.ArchivedAt != nil {
+		archived = group.ArchivedAt.Format(time.RFC3339)
+	}
+	if err := out.writeTable(nil, [][]string{
+		{"ID", strconv.FormatUint(uint64(group.ID), 10)},
+		{"CHAT ID", strconv.FormatInt(group.ChatID, 10)},
+		{"NAME", group.Name},
+		{"ARCHIVED", archived},
+		{"ALIASES", strings.Join(details.Aliases, ", ")},
+		{"INCLUDES", strings.Join(details.Includes, ", ")},
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(out.w)
+	return out.writeMembers(details.Members)
+}
+
+func runExport(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errAdminUsage
+	}
+	chatID, err := parseChatID(args[0])
+	if err != nil {
+		return err
+	}
+	export, err := storage.ExportChat(ctx, s, chatID, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := export.Marshal()
+	if err != nil {
+		return err
+	}
+
+	// The export is a JSON document anyway, so -json changes nothing
+	if len(args) == 1 || args[1] == "-" {
+		_, err := out.w.Write(data)
+		return err
+	}
+	return os.WriteFile(args[1], data, 0o644)
+}
+
+func runImport(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 2 {
+		return errAdminUsage
+	}
+	chatID, err := parseChatID(args[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if args[1] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return err
+	}
+	export, err := storage.ParseChatExport(data)
+	if err != nil {
+		return err
+	}
+	for _, name := range export.Names() {
+		if !bot.IsValidGroupName(name) {
+			return fmt.Errorf("invalid group name %q in the file", name)
+		}
+	}
+
+	var plan *storage.ImportPlan
+	if out.dryRun {
+		plan, err = storage.PlanImport(ctx, s, chatID, export)
+	} else {
+		plan, err = storage.ImportChat(ctx, s, chatID, export)
+	}
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.writeJSON(plan)
+	}
+
+	rows := make([][]string, 0, len(plan.Groups))
+	for _, group := range plan.Groups {
+		action := "keep"
+		if group.Create {
+			action = "create"
+		} else if group.Changed() {
+			action = "update"
+		}
+		var skipped []string
+		for _, alias := range group.SkippedAliases {
+			skipped = append(skipped, "alias "+alias)
+		}
+		for _, child := range group.SkippedIncludes {
+			skipped = append(skipped, "includes "+child)
+		}
+		rows = append(rows, []string{
+			group.Name,
+			action,
+			strconv.Itoa(len(group.NewMembers)),
+			strconv.Itoa(group.KeptMembers),
+			strings.Join(group.NewAliases, ","),
+			strings.Join(group.NewIncludes, ","),
+			strings.Join(skipped, ","),
+		})
+	}
+	return out.writeTable([]string{"GROUP", "ACTION", "NEW MEMBERS", "KEPT MEMBERS", "NEW ALIASES", "NEW INCLUDES", "SKIPPED"}, rows)
+}
+
+func runAddMember(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 3 {
+		return errAdminUsage
+	}
+	group, user, err := resolveGroupAndUser(ctx, s, args, true)
+	if err != nil {
+		return err
+	}
+	if err := s.AddMember(ctx, group.ID, user); err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("user %d is already a member of group '%s'", user.ID, group.Name)
+		}
+		return err
+	}
+	return out.writeMembers([]memberDetails{newMemberDetails(group.Name, *user, false)})
+}
+
+func runRemoveMember(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 3 {
+		return errAdminUsage
+	}
+	group, user, err := resolveGroupAndUser(ctx, s, args, false)
+	if err != nil {
+		return err
+	}
+	isMember, err := s.IsMember(ctx, group.ID, user.ID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return fmt.Errorf("user %d is not a member of group '%s'", user.ID, group.Name)
+	}
+	if err := s.RemoveMember(ctx, group.ID, user.ID); err != nil {
+		return err
+	}
+	return out.writeMembers([]memberDetails{newMemberDetails(group.Name, *user, false)})
+}
+
+// resolveGroupAndUser finds the group and the user of add-member and remove-member arguments.
+// Users unknown to the bot are created by their ID if create is set, the bot fills their names
+// once they write to a chat.
+func resolveGroupAndUser(ctx context.Context, s *storage.SQLStorage, args []string, create bool) (*storage.MentionGroup, *storage.User, error) {
+	group, err := findGroup(ctx, s, args[0], args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if username, ok := strings.CutPrefix(args[2], "@"); ok {
+		user, err := s.GetUserByUsername(ctx, username)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, fmt.Errorf("user @%s not found", username)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return group, user, nil
+	}
+
+	userID, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || userID <= 0 {
+		return nil, nil, fmt.Errorf("invalid user %q, expected a user ID or @username", args[2])
+	}
+	user, err := s.GetUser(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) && create {
+		user, err = s.CreateOrUpdateUser(ctx, userID, "", "", "")
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, fmt.Errorf("user %d not found", userID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, user, nil
+}
+
+// findGroup finds a group by its name or alias in the chat given as a command argument
+func findGroup(ctx context.Context, s *storage.SQLStorage, chatArg, name string) (*storage.MentionGroup, error) {
+	chatID, err := parseChatID(chatArg)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.GetGroup(ctx, name, chatID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("group '%s' not found in chat %d", name, chatID)
+	}
+	return group, err
+}
+
+func runVacuum(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 0 {
+		return errAdminUsage
+	}
+	results, err := s.Vacuum(ctx)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.writeJSON(results)
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []string{result.Table, strconv.FormatInt(result.Deleted, 10)})
+	}
+	return out.writeTable([]string{"TABLE", "DELETED ORPHANS"}, rows)
+}
+
+func runStats(ctx context.Context, s *storage.SQLStorage, out *adminOutput, args []string) error {
+	if len(args) != 0 {
+		return errAdminUsage
+	}
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.writeJSON(stats)
+	}
+
+	return out.writeTable([]string{"KEY", "COUNT"}, [][]string{
+		{"chats", strconv.FormatInt(stats.Chats, 10)},
+		{"groups", strconv.FormatInt(stats.Groups, 10)},
+		{"archived_groups", strconv.FormatInt(stats.ArchivedGroups, 10)},
+		{"aliases", strconv.FormatInt(stats.Aliases, 10)},
+		{"inclusions", strconv.FormatInt(stats.Inclusions, 10)},
+		{"members", strconv.FormatInt(stats.Members, 10)},
+		{"muted_members", strconv.FormatInt(stats.MutedMembers, 10)},
+		{"users", strconv.FormatInt(stats.Users, 10)},
+		{"chat_users", strconv.FormatInt(stats.ChatUsers, 10)},
+		{"reminders", strconv.FormatInt(stats.Reminders, 10)},
+		{"chat_settings", strconv.FormatInt(stats.Settings, 10)},
+		{"command_permissions", strconv.FormatInt(stats.Permissions, 10)},
+	})
+}
+
+func parseChatID(value string) (int64, error) {
+	chatID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || chatID == 0 {
+		return 0, fmt.Errorf("invalid chat ID %q", value)
+	}
+	return chatID, nil
+}
+
+func newMemberDetails(group string, user storage.User, muted bool) memberDetails {
+	return memberDetails{
+		Group:    group,
+		UserID:   user.ID,
+		Username: user.Username,
+		Name:     strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Muted:    muted,
+	}
+}
+
+func (o *adminOutput) writeMembers(members []memberDetails) error {
+	if o.json {
+		return o.writeJSON(members)
+	}
+	rows := make([][]string, 0, len(members))
+	for _, member := range members {
+		username := ""
+		if member.Username != "" {
+			username = "@" + member.Username
+		}
+		rows = append(rows, []string{member.Group, strconv.FormatInt(member.UserID, 10), username, member.Name, strconv.FormatBool(member.Muted)})
+	}
+	return o.writeTable([]string{"GROUP", "USER ID", "USERNAME", "NAME", "MUTED"}, rows)
+}
+
+func (o *adminOutput) writeJSON(v any) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeTable prints rows aligned in columns, the header is optional
+func (o *adminOutput) writeTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
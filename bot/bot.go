@@ -2,50 +2,117 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 
 	"telegram-group-mention-bot/storage"
 
 	t "github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
 	th "github.com/mymmrac/telego/telegohandler"
 	tu "github.com/mymmrac/telego/telegoutil"
 )
 
 const (
 	groupNameAll = "all"
+
+	// Telegram limits for a single text message
+	maxMessageLength   = 4096
+	maxMessageEntities = 100
 )
 
 type Bot struct {
 	bot     *t.Bot
-	storage *storage.Storage
+	storage storage.Storage
+
+	// chatAdmins caches user statuses of chat administrators by chat ID
+	chatAdmins *ttlCache[int64, map[int64]string]
+	// consentRequests keeps membership changes waiting for confirmation by request ID
+	consentRequests *ttlCache[string, consentRequest]
+	// importRequests keeps imports waiting for confirmation by request ID
+	importRequests *ttlCache[string, importRequest]
+	// chatMembers caches whether a user is still in a chat, chatTitles caches chat titles by chat ID
+	chatMembers *ttlCache[chatUserKey, bool]
+	chatTitles  *ttlCache[int64, string]
+	// seenChatUsers throttles saving the time users were seen in chats
+	seenChatUsers *ttlCache[chatUserKey, bool]
+	// settingsCache keeps chat settings by chat ID, use getChatSettings and saveChatSettings to access them
+	settingsCache *ttlCache[int64, storage.ChatSettings]
+
+	// webhook is nil when updates are received via long polling
+	webhook       *WebhookConfig
+	webhookServer *http.Server
+
+	// Metrics and health endpoints are served on their addresses if they are not empty, by one
+	// server if the addresses are the same
+	metricsListen string
+	healthListen  string
+	opsServers    []*http.Server
+	health        *healthState
+
+	handler     *th.BotHandler
+	stopUpdates context.CancelFunc
+
+	// queue sends outgoing messages within Telegram rate limits, use sendMessage or queueMessage
+	queue *sendQueue
+
+	// schedulerDone is closed once the reminder scheduler has stopped
+	stopScheduler context.CancelFunc
+	schedulerDone chan struct{}
+
+	// stopCacheSweeper stops the periodic sweep of expired cache entries
+	stopCacheSweeper context.CancelFunc
 }
 
-func New(token string, storage *storage.Storage) (*Bot, error) {
+func New(token string, storage storage.Storage, options ...Option) (*Bot, error) {
 	slog.Debug("bot: Creating new bot instance", "token_length", len(token))
 
-	// Create bot with debug logging
-	bot, err := t.NewBot(token, t.WithDefaultLogger(false, true))
+	// Create bot with debug logging, all requests to the Bot API are counted in metrics
+	// and successful polls are recorded for the readiness check
+	health := &healthState{}
+	bot, err := t.NewBot(token,
+		t.WithDefaultLogger(false, true),
+		t.WithAPICaller(apiCaller{caller: ta.DefaultFastHTTPCaller, health: health}),
+	)
 	if err != nil {
 		slog.Error("bot: Failed to create bot", "error", err, "token_length", len(token))
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
 
-	slog.Debug("bot: Bot instance created successfully")
-	return &Bot{
-		bot:     bot,
-		storage: storage,
-	}, nil
+	b := &Bot{
+		bot:             bot,
+		storage:         storage,
+		chatAdmins:      newTTLCache[int64, map[int64]string](chatAdminsCacheTTL),
+		consentRequests: newTTLCache[string, consentRequest](consentRequestTTL),
+		importRequests:  newTTLCache[string, importRequest](importRequestTTL),
+		chatMembers:     newTTLCache[chatUserKey, bool](chatMembersCacheTTL),
+		chatTitles:      newTTLCache[int64, string](chatTitlesCacheTTL),
+		seenChatUsers:   newTTLCache[chatUserKey, bool](chatUserSeenInterval),
+		settingsCache:   newChatSettingsCache(),
+		queue:           newSendQueue(bot.SendMessage, bot.SendDocument),
+		health:          health,
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	slog.Debug("bot: Bot instance created successfully", "webhook", b.webhook != nil)
+	return b, nil
 }
 
-func (b *Bot) Start() error {
+// Start starts receiving and handling updates in the background. The context is passed to every
+// handler, so it should be canceled only after Stop has returned.
+func (b *Bot) Start(ctx context.Context) error {
 	// Get and log bot information
-	me, err := b.bot.GetMe(context.Background())
+	me, err := b.bot.GetMe(ctx)
 	if err != nil {
 		slog.Error("bot: Failed to get bot information", "error", err)
 		return fmt.Errorf("failed to get bot information: %w", err)
 	}
+	b.health.identified.Store(true)
 	slog.Info("bot: Bot started",
 		"id", me.ID,
 		"username", me.Username,
@@ -57,10 +124,19 @@ func (b *Bot) Start() error {
 		"supports_inline_queries", me.SupportsInlineQueries,
 	)
 
+	// The bot works without the command menu, so failing to set it is not fatal
+	slog.Debug("bot: Registering commands with Telegram")
+	if err := b.registerCommands(ctx); err != nil {
+		slog.Error("bot: Failed to register commands", "error", err)
+	}
+
 	// Get updates channel
 	slog.Debug("bot: Getting updates channel")
-	updates, err := b.bot.UpdatesViaLongPolling(context.Background(), nil)
+	var updatesCtx context.Context
+	updatesCtx, b.stopUpdates = context.WithCancel(ctx)
+	updates, err := b.getUpdates(updatesCtx)
 	if err != nil {
+		b.stopUpdates()
 		slog.Error("bot: Failed to get updates channel", "error", err)
 		return fmt.Errorf("failed to get updates channel: %w", err)
 	}
@@ -70,6 +146,7 @@ func (b *Bot) Start() error {
 	slog.Debug("bot: Creating bot handler")
 	h, err := th.NewBotHandler(b.bot, updates)
 	if err != nil {
+		b.stopUpdates()
 		slog.Error("bot: Failed to create handler", "error", err)
 		return fmt.Errorf("failed to create handler: %w", err)
 	}
@@ -77,63 +154,161 @@ func (b *Bot) Start() error {
 
 	// Register update middleware for logging
 	slog.Debug("bot: Registering middleware")
+	h.Use(func(hctx *th.Context, update t.Update) error {
+		// The handler context is canceled as soon as the bot handler stops, but in-flight
+		// updates should be able to finish during graceful shutdown
+		return hctx.WithContext(ctx).Next(update)
+	})
 	h.Use(b.logUpdate)
+	h.Use(b.measureUpdate(b.commands()))
 	h.Use(b.syncUserData)
+	h.Use(b.trackChatUsers)
 	h.Use(b.addToAllGroup)
 	h.Use(b.migrateChat)
 
 	// Register command handlers
 	slog.Debug("bot: Registering command handlers")
-	h.HandleMessage(b.handleHelp, th.CommandEqual("help"))
-	h.HandleMessage(b.handleList, th.CommandEqual("list"))
-	h.HandleMessage(b.handleMy, th.CommandEqual("my"))
-	h.HandleMessage(b.handleNewGroup, th.CommandEqual("new"))
-	h.HandleMessage(b.handleJoin, th.CommandEqual("join"))
-	h.HandleMessage(b.handleLeave, th.CommandEqual("leave"))
-	h.HandleMessage(b.handleMention, th.Or(
-		th.CommandEqual("mention"),
-		th.CommandEqual("m"),
-		th.CommandEqual("call"),
-	))
-	h.HandleMessage(b.handleDeleteGroup, th.CommandEqual("del"))
-	h.HandleMessage(b.handleShowGroup, th.CommandEqual("show"))
-
-	h.HandleMessage(b.handleFreeFormMessage, th.Not(th.AnyCommand()))
+	for _, command := range b.commands() {
+		h.HandleMessage(command.handler, command.predicate())
+	}
+	h.HandleMessage(b.handleGroupOnlyCommand, th.AnyCommand(), inChatScope(scopePrivate))
+
+	h.HandleMessage(b.handleLeftChatMember, leftChatMember())
+	h.HandleChatMemberUpdated(b.handleChatMemberUpdated)
+	h.HandleMyChatMemberUpdated(b.handleMyChatMemberUpdated)
+
+	h.HandleCallbackQuery(b.handleConsentCallback, th.CallbackDataPrefix(consentCallbackPrefix))
+	h.HandleCallbackQuery(b.handleChecklistCallback, th.CallbackDataPrefix(checklistCallbackPrefix))
+	h.HandleCallbackQuery(b.handleSettingsCallback, th.CallbackDataPrefix(settingsCallbackPrefix))
+	h.HandleCallbackQuery(b.handleConsoleCallback, th.CallbackDataPrefix(consoleCallbackPrefix))
+	h.HandleCallbackQuery(b.handleImportCallback, th.CallbackDataPrefix(importCallbackPrefix))
+
+	h.HandleMessage(b.handleFreeFormMessage, th.Not(th.AnyCommand()), inChatScope(scopeGroup))
+
+	h.HandleInlineQuery(b.handleInlineQuery)
+	h.HandleChosenInlineResult(b.handleChosenInlineResult)
+
+	b.handler = h
+
+	b.startOpsServers()
+
+	slog.Debug("bot: Starting outbound message queue")
+	go b.queue.run(ctx)
 
 	slog.Info("bot: Starting bot handlers")
-	return h.Start()
+	go func() {
+		if err := h.Start(); err != nil {
+			slog.Error("bot: Bot handler stopped with error", "error", err)
+		}
+	}()
+
+	slog.Debug("bot: Starting reminder scheduler")
+	var schedulerCtx context.Context
+	schedulerCtx, b.stopScheduler = context.WithCancel(ctx)
+	b.schedulerDone = make(chan struct{})
+	go b.runScheduler(schedulerCtx, b.schedulerDone)
+
+	var sweeperCtx context.Context
+	sweeperCtx, b.stopCacheSweeper = context.WithCancel(ctx)
+	go b.runCacheSweeper(sweeperCtx)
+
+	return nil
+}
+
+// Stop stops receiving new updates and waits for in-flight handlers until the context is done
+func (b *Bot) Stop(ctx context.Context) error {
+	slog.Info("bot: Stopping bot")
+
+	// Webhook server must be stopped before the updates channel is closed, otherwise it may write to it
+	if b.webhookServer != nil {
+		slog.Debug("bot: Shutting down webhook server")
+		if err := b.webhookServer.Shutdown(ctx); err != nil {
+			slog.Error("bot: Failed to shut down webhook server", "error", err)
+		}
+	}
+
+	if b.stopScheduler != nil {
+		slog.Debug("bot: Waiting for reminder scheduler to stop")
+		b.stopScheduler()
+		select {
+		case <-b.schedulerDone:
+		case <-ctx.Done():
+			slog.Warn("bot: Reminder scheduler did not stop in time")
+		}
+	}
+
+	if b.stopCacheSweeper != nil {
+		b.stopCacheSweeper()
+	}
+
+	if b.stopUpdates != nil {
+		slog.Debug("bot: Stopping updates")
+		b.stopUpdates()
+	}
+
+	if b.handler != nil {
+		slog.Debug("bot: Waiting for handlers to finish")
+		if err := b.handler.StopWithContext(ctx); err != nil {
+			slog.Error("bot: Failed to stop bot handler", "error", err)
+			return fmt.Errorf("failed to stop bot handler: %w", err)
+		}
+	}
+
+	// Handlers and the scheduler are done, so nothing is queued anymore. The queue runs only after Start.
+	b.queue.close()
+	if b.handler != nil {
+		slog.Debug("bot: Waiting for queued messages to be sent")
+		if err := b.queue.wait(ctx); err != nil {
+			slog.Warn("bot: Outbound message queue did not drain in time", "error", err)
+		}
+	}
+
+	// Metrics and health endpoints are served until the end, so the last scrape sees the shutdown
+	for _, server := range b.opsServers {
+		slog.Debug("bot: Shutting down ops server", "listen", server.Addr)
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("bot: Failed to shut down ops server", "error", err, "listen", server.Addr)
+		}
+	}
+
+	slog.Info("bot: Bot stopped")
+	return nil
 }
 
 func (b *Bot) handleNewGroup(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling new group command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
+	lang := b.language(ctx, message.Chat.ID, message.From)
 	args := strings.Fields(message.Text)
 	if len(args) != 2 {
 		slog.Debug("bot: Invalid new group command format", "args_count", len(args))
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2("Usage: /new <group_name>\nGroup name can only contain lowercase letters, numbers, and dashes."), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /new <group_name>\nGroup name can only contain lowercase letters, numbers, and dashes.")), &message)
 		return nil
 	}
 
 	groupName := strings.ToLower(args[1])
-	if !isValidGroupName(groupName) {
+	if !IsValidGroupName(groupName) {
 		slog.Debug("bot: Invalid group name", "group_name", groupName)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2("Invalid group name. Group name can only contain lowercase letters, numbers, and dashes."), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid group name. Group name can only contain lowercase letters, numbers, and dashes.")), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+	if !b.checkPermission(ctx, &message, "new", nil) {
 		return nil
 	}
 
-	b.sendTyping(tu.ID(message.Chat.ID))
 	slog.Debug("bot: Creating new group", "group_name", groupName, "chat_id", message.Chat.ID)
-	err := b.storage.CreateGroup(groupName, message.Chat.ID)
+	err := b.storage.CreateGroup(ctx, groupName, message.Chat.ID)
 	if err != nil {
 		slog.Error("bot: Failed to create group", "error", err,
 			"group_name", groupName, "chat_id", message.Chat.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create group: %v", err)), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create group: %v", err)), &message)
 		return nil
 	}
 
 	slog.Info("bot: Group created", "group_name", groupName, "chat_id", message.Chat.ID)
-	b.sendMessage(message.Chat.ID, fmt.Sprintf("Group '%s' created successfully\\!\nTo join this group, use: /join %s",
-		escapeMarkdownV2(groupName), escapeMarkdownV2(groupName)), &message)
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Group '%s' created successfully!\nTo join this group, use: /join %s", groupName, groupName)), &message)
 	return nil
 }
 
@@ -142,37 +317,20 @@ func (b *Bot) handleJoin(ctx *th.Context, message t.Message) error {
 
 	args := strings.Fields(message.Text)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	if len(args) < 2 {
-		slog.Debug("bot: No group name provided for join command, showing available groups")
-		groups, err := b.storage.GetGroupsToJoinByChatAndUser(message.Chat.ID, message.From.ID)
-		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
-			return nil
-		}
-
-		keyboard, err := b.createGroupSelectionReplyKeyboard("join", groups)
-		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create keyboard: %v", err)), &message)
-			return nil
-		}
-		if keyboard == nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups available to join."), &message)
-			return nil
-		}
-
-		msg := tu.Message(tu.ID(message.Chat.ID), escapeMarkdownV2("Select a group to join."))
-		msg.ReplyMarkup = keyboard
-		msg.ParseMode = "MarkdownV2"
-		_, err = b.bot.SendMessage(context.Background(), b.reply(message, msg))
-		return err
+		slog.Debug("bot: No group name provided for join command, showing group checklist")
+		return b.sendGroupChecklist(ctx, &message)
 	}
 
 	groupName := args[1]
 	slog.Debug("bot: Joining group", "group_name", groupName, "chat_id", message.Chat.ID, "user_id", message.From.ID)
-	err := b.executeOnGroup(message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
-		return b.joinGroupOperation(group, message.From, message.Chat.ID, originalMessage)
+	err := b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "join", group) {
+			return nil
+		}
+		return b.joinGroupOperation(ctx, group, message.From, message.Chat.ID, originalMessage)
 	})
 	return err
 }
@@ -182,37 +340,20 @@ func (b *Bot) handleLeave(ctx *th.Context, message t.Message) error {
 
 	args := strings.Fields(message.Text)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	if len(args) < 2 {
-		slog.Debug("bot: No group name provided for leave command, showing available groups")
-		groups, err := b.storage.GetUserGroupsByChat(message.Chat.ID, message.From.ID)
-		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
-			return nil
-		}
-
-		keyboard, err := b.createGroupSelectionReplyKeyboard("leave", groups)
-		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create keyboard: %v", err)), &message)
-			return nil
-		}
-		if keyboard == nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups available to leave."), &message)
-			return nil
-		}
-
-		msg := tu.Message(tu.ID(message.Chat.ID), escapeMarkdownV2("Select a group to leave."))
-		msg.ReplyMarkup = keyboard
-		msg.ParseMode = "MarkdownV2"
-		_, err = b.bot.SendMessage(context.Background(), b.reply(message, msg))
-		return err
+		slog.Debug("bot: No group name provided for leave command, showing group checklist")
+		return b.sendGroupChecklist(ctx, &message)
 	}
 
 	groupName := args[1]
 	slog.Debug("bot: Leaving group", "group_name", groupName, "chat_id", message.Chat.ID, "user_id", message.From.ID)
-	err := b.executeOnGroup(message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
-		return b.leaveGroupOperation(group, message.From.ID, message.Chat.ID, originalMessage)
+	err := b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "leave", group) {
+			return nil
+		}
+		return b.leaveGroupOperation(ctx, group, message.From.ID, message.Chat.ID, originalMessage)
 	})
 	return err
 }
@@ -220,90 +361,94 @@ func (b *Bot) handleLeave(ctx *th.Context, message t.Message) error {
 func (b *Bot) handleMention(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling mention command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
+	lang := b.language(ctx, message.Chat.ID, message.From)
 	args := strings.Fields(message.Text)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	if len(args) < 2 {
 		slog.Debug("bot: No group name provided for mention command, showing available groups")
-		groups, err := b.storage.GetGroupsByChat(message.Chat.ID)
+		groups, err := b.storage.GetGroupsByChat(ctx, message.Chat.ID)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), &message)
 			return nil
 		}
 
-		keyboard, err := b.createGroupSelectionReplyKeyboard("m", groups)
+		keyboard, err := b.createGroupSelectionReplyKeyboard(lang, "m", groups)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create keyboard: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create keyboard: %v", err)), &message)
 			return nil
 		}
 		if keyboard == nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups available to mention."), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No groups available to mention.")), &message)
 			return nil
 		}
 
-		msg := tu.Message(tu.ID(message.Chat.ID), escapeMarkdownV2("Select a group to mention."))
-		msg.ReplyMarkup = keyboard
-		msg.ParseMode = "MarkdownV2"
-		_, err = b.bot.SendMessage(context.Background(), b.reply(message, msg))
-		return err
+		return b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Select a group to mention.")), &message, keyboard)
 	}
 
 	groupName := args[1]
 	slog.Debug("bot: Mentioning group", "group_name", groupName, "chat_id", message.Chat.ID)
-	groups, err := b.storage.FindGroupsByChatAndNamesWithMembers(message.Chat.ID, []string{groupName})
+	groups, err := b.storage.FindGroupsByChatAndNamesWithMembers(ctx, message.Chat.ID, []string{groupName})
 	if err != nil {
 		slog.Error("bot: Failed to find group", "error", err, "chat_id", message.Chat.ID, "group_name", groupName)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to find group: %v", err)), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to find group: %v", err)), &message)
 		return nil
 	}
 
 	if len(groups) == 0 {
 		slog.Debug("bot: Group not found", "group_name", groupName, "chat_id", message.Chat.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Group '%s' not found.", groupName)), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Group '%s' not found.", groupName)), &message)
+		return nil
+	}
+
+	if !b.checkPermission(ctx, &message, "mention", &groups[0]) {
+		return nil
+	}
+	if !b.checkCooldown(ctx, &message, groups) {
 		return nil
 	}
 
 	slog.Debug("bot: Found group for mention", "group_name", groupName, "group_id", groups[0].ID, "member_count", len(groups[0].Members))
-	return b.mentionGroups(groups, message.Chat.ID, &message)
+	return b.mentionGroups(ctx, groups, message.Chat.ID, &message, nil)
 }
 
 func (b *Bot) handleDeleteGroup(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling delete group command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
+	lang := b.language(ctx, message.Chat.ID, message.From)
 	args := strings.Fields(message.Text)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	if len(args) < 2 {
 		slog.Debug("bot: No group name provided for delete command, showing available groups")
-		groups, err := b.storage.GetGroupsByChat(message.Chat.ID)
+		groups, err := b.storage.GetGroupsByChat(ctx, message.Chat.ID)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), &message)
 			return nil
 		}
 
-		keyboard, err := b.createGroupSelectionReplyKeyboard("del", groups)
+		keyboard, err := b.createGroupSelectionReplyKeyboard(lang, "del", groups)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create keyboard: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create keyboard: %v", err)), &message)
 			return nil
 		}
 		if keyboard == nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups available to delete."), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No groups available to delete.")), &message)
 			return nil
 		}
 
-		msg := tu.Message(tu.ID(message.Chat.ID), escapeMarkdownV2("Select a group to delete."))
-		msg.ReplyMarkup = keyboard
-		msg.ParseMode = "MarkdownV2"
-		_, err = b.bot.SendMessage(context.Background(), b.reply(message, msg))
-		return err
+		return b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Select a group to delete.")), &message, keyboard)
 	}
 
 	groupName := args[1]
 	slog.Debug("bot: Deleting group", "group_name", groupName, "chat_id", message.Chat.ID)
-	err := b.executeOnGroup(message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
-		return b.deleteGroupOperation(group, message.Chat.ID, originalMessage)
+	err := b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "del", group) {
+			return nil
+		}
+		return b.deleteGroupOperation(ctx, group, message.Chat.ID, originalMessage)
 	})
 	return err
 }
@@ -311,39 +456,178 @@ func (b *Bot) handleDeleteGroup(ctx *th.Context, message t.Message) error {
 func (b *Bot) handleShowGroup(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling show group command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
+	lang := b.language(ctx, message.Chat.ID, message.From)
 	args := strings.Fields(message.Text)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	if len(args) < 2 {
 		slog.Debug("bot: No group name provided for show command, showing available groups")
-		groups, err := b.storage.GetGroupsByChat(message.Chat.ID)
+		groups, err := b.storage.GetGroupsByChat(ctx, message.Chat.ID)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), &message)
 			return nil
 		}
 
-		keyboard, err := b.createGroupSelectionReplyKeyboard("show", groups)
+		keyboard, err := b.createGroupSelectionReplyKeyboard(lang, "show", groups)
 		if err != nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to create keyboard: %v", err)), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create keyboard: %v", err)), &message)
 			return nil
 		}
 		if keyboard == nil {
-			b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups available to show."), &message)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No groups available to show.")), &message)
 			return nil
 		}
 
-		msg := tu.Message(tu.ID(message.Chat.ID), escapeMarkdownV2("Select a group to show."))
-		msg.ReplyMarkup = keyboard
-		msg.ParseMode = "MarkdownV2"
-		_, err = b.bot.SendMessage(context.Background(), b.reply(message, msg))
-		return err
+		return b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Select a group to show.")), &message, keyboard)
 	}
 
 	groupName := args[1]
 	slog.Debug("bot: Showing group", "group_name", groupName, "chat_id", message.Chat.ID)
-	err := b.executeOnGroup(message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
-		return b.showGroupMembersOperation(group, message.Chat.ID, originalMessage)
+	err := b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "show", group) {
+			return nil
+		}
+		return b.showGroupMembersOperation(ctx, group, message.Chat.ID, originalMessage)
+	})
+	return err
+}
+
+func (b *Bot) handleAlias(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling alias command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) != 3 {
+		slog.Debug("bot: Invalid alias command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /alias <group_name> <alias>\nAlias can only contain lowercase letters, numbers, and dashes.")), &message)
+		return nil
+	}
+
+	groupName := args[1]
+	aliasName := strings.ToLower(args[2])
+	if !IsValidGroupName(aliasName) {
+		slog.Debug("bot: Invalid alias name", "alias", aliasName)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid alias. Alias can only contain lowercase letters, numbers, and dashes.")), &message)
+		return nil
+	}
+	// The 'all' group is looked up by name to add every chat member to it
+	if aliasName == groupNameAll {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "'%s' is reserved and can't be used as an alias.", groupNameAll)), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	slog.Debug("bot: Creating alias", "group_name", groupName, "alias", aliasName, "chat_id", message.Chat.ID)
+	err := b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "alias", group) {
+			return nil
+		}
+
+		if err := b.storage.CreateAlias(ctx, group.ID, message.Chat.ID, aliasName); err != nil {
+			if errors.Is(err, storage.ErrAlreadyExists) {
+				b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Name '%s' is already used by a group or an alias in this chat.", aliasName)), originalMessage)
+				return nil
+			}
+			slog.Error("bot: Failed to create alias", "error", err, "group_id", group.ID, "alias", aliasName, "chat_id", message.Chat.ID)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create alias: %v", err)), originalMessage)
+			return nil
+		}
+
+		slog.Info("bot: Alias created", "group_id", group.ID, "group_name", group.Name, "alias", aliasName, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "'%s' is now an alias of group '%s'.", aliasName, group.Name)), originalMessage)
+		return nil
+	})
+	return err
+}
+
+func (b *Bot) handleUnalias(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling unalias command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) != 2 {
+		slog.Debug("bot: Invalid unalias command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /unalias <alias>")), &message)
+		return nil
+	}
+
+	aliasName := strings.ToLower(args[1])
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	slog.Debug("bot: Deleting alias", "alias", aliasName, "chat_id", message.Chat.ID)
+	err := b.executeOnGroup(ctx, message.Chat.ID, aliasName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if group.Name == aliasName {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "'%s' is a group name, not an alias. Use /del to delete groups.", aliasName)), originalMessage)
+			return nil
+		}
+		if !b.checkPermission(ctx, originalMessage, "unalias", group) {
+			return nil
+		}
+
+		if err := b.storage.DeleteAlias(ctx, message.Chat.ID, aliasName); err != nil {
+			slog.Error("bot: Failed to delete alias", "error", err, "alias", aliasName, "chat_id", message.Chat.ID)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to delete alias: %v", err)), originalMessage)
+			return nil
+		}
+
+		slog.Info("bot: Alias deleted", "group_id", group.ID, "group_name", group.Name, "alias", aliasName, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Alias '%s' of group '%s' deleted.", aliasName, group.Name)), originalMessage)
+		return nil
+	})
+	return err
+}
+
+func (b *Bot) handleInclude(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling include command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) != 3 {
+		slog.Debug("bot: Invalid include command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /include <group_name> <included_group_name>")), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	parentName, childName := args[1], args[2]
+	slog.Debug("bot: Including group", "parent_name", parentName, "child_name", childName, "chat_id", message.Chat.ID)
+	err := b.executeOnGroup(ctx, message.Chat.ID, parentName, &message, func(parent *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "include", parent) {
+			return nil
+		}
+		return b.executeOnGroup(ctx, message.Chat.ID, childName, originalMessage, func(child *storage.MentionGroup, originalMessage *t.Message) error {
+			return b.includeGroupOperation(ctx, parent, child, message.Chat.ID, originalMessage)
+		})
+	})
+	return err
+}
+
+func (b *Bot) handleExclude(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling exclude command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) != 3 {
+		slog.Debug("bot: Invalid exclude command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /exclude <group_name> <included_group_name>")), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	parentName, childName := args[1], args[2]
+	slog.Debug("bot: Excluding group", "parent_name", parentName, "child_name", childName, "chat_id", message.Chat.ID)
+	err := b.executeOnGroup(ctx, message.Chat.ID, parentName, &message, func(parent *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "exclude", parent) {
+			return nil
+		}
+		return b.executeOnGroup(ctx, message.Chat.ID, childName, originalMessage, func(child *storage.MentionGroup, originalMessage *t.Message) error {
+			return b.excludeGroupOperation(ctx, parent, child, message.Chat.ID, originalMessage)
+		})
 	})
 	return err
 }
@@ -351,72 +635,79 @@ func (b *Bot) handleShowGroup(ctx *th.Context, message t.Message) error {
 func (b *Bot) handleHelp(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling help command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
-	helpText := escapeMarkdownV2(`Available commands:
-/new <name> - Create a new mention group
-/join <name> - Join an existing mention group
-/leave <name> - Leave a mention group
-/mention <name> or /m <name> or /call <name> - Mention all members of a group
-/show <name> - Show all members of a group without mentioning them
-/del <name> - Delete a group (only if it has no members)
-/list - Show all groups in this chat
-/my - Show groups you've joined in this chat
-/help - Show this help message`)
-
-	b.sendMessage(message.Chat.ID, helpText, &message)
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	var lines []string
+	for _, command := range b.commandsInScope(chatScope(message.Chat)) {
+		for _, line := range command.help {
+			lines = append(lines, tr(lang, line.usage)+" - "+tr(lang, line.description))
+		}
+	}
+	helpText := escapeMarkdownV2(tr(lang, "Available commands:") + "\n" + strings.Join(lines, "\n"))
+
+	b.sendMessage(ctx, message.Chat.ID, helpText, &message)
 	return nil
 }
 
 func (b *Bot) handleList(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling list command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
-	groups, err := b.storage.GetGroupsByChat(message.Chat.ID)
+	groups, err := b.storage.GetGroupsByChat(ctx, message.Chat.ID)
 	if err != nil {
 		slog.Error("bot: Failed to get groups", "error", err, "chat_id", message.Chat.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get groups: %v", err)), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), &message)
 		return nil
 	}
 
 	if len(groups) == 0 {
 		slog.Debug("bot: No groups found for chat", "chat_id", message.Chat.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2("No groups found in this chat."), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No groups found in this chat.")), &message)
 		return nil
 	}
 
 	slog.Debug("bot: Listing groups", "chat_id", message.Chat.ID, "group_count", len(groups))
-	header := "Groups in this chat:\n"
+	header := escapeMarkdownV2(tr(lang, "Groups in this chat:")) + "\n"
 	var groupNames []string
 	for _, group := range groups {
 		groupText := fmt.Sprintf("• %s\n", escapeMarkdownV2(group.Name))
+		if len(group.Aliases) > 0 {
+			aliases := make([]string, 0, len(group.Aliases))
+			for _, alias := range group.Aliases {
+				aliases = append(aliases, alias.Name)
+			}
+			groupText = fmt.Sprintf("• %s %s\n", escapeMarkdownV2(group.Name), escapeMarkdownV2("("+strings.Join(aliases, ", ")+")"))
+		}
 		groupNames = append(groupNames, groupText)
 	}
 
 	listText := header + strings.Join(groupNames, "")
-	b.sendMessage(message.Chat.ID, listText, &message)
+	b.sendMessage(ctx, message.Chat.ID, listText, &message)
 	return nil
 }
 
 func (b *Bot) handleMy(ctx *th.Context, message t.Message) error {
 	slog.Debug("bot: Handling my command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
-	groups, err := b.storage.GetUserGroupsByChat(message.Chat.ID, message.From.ID)
+	groups, err := b.storage.GetUserGroupsByChat(ctx, message.Chat.ID, message.From.ID)
 	if err != nil {
 		slog.Error("bot: Failed to get user's groups", "error", err, "chat_id", message.Chat.ID, "user_id", message.From.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2(fmt.Sprintf("Failed to get your groups: %v", err)), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get your groups: %v", err)), &message)
 		return nil
 	}
 
 	if len(groups) == 0 {
 		slog.Debug("bot: No groups found for user", "chat_id", message.Chat.ID, "user_id", message.From.ID)
-		b.sendMessage(message.Chat.ID, escapeMarkdownV2("You haven't joined any groups in this chat."), &message)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "You haven't joined any groups in this chat.")), &message)
 		return nil
 	}
 
 	slog.Debug("bot: Listing user's groups", "chat_id", message.Chat.ID, "user_id", message.From.ID, "group_count", len(groups))
-	header := "Groups you've joined in this chat:\n"
+	header := escapeMarkdownV2(tr(lang, "Groups you've joined in this chat:")) + "\n"
 	var groupNames []string
 	for _, group := range groups {
 		groupText := fmt.Sprintf("• %s\n", escapeMarkdownV2(group.Name))
@@ -424,7 +715,7 @@ func (b *Bot) handleMy(ctx *th.Context, message t.Message) error {
 	}
 
 	listText := header + strings.Join(groupNames, "")
-	b.sendMessage(message.Chat.ID, listText, &message)
+	b.sendMessage(ctx, message.Chat.ID, listText, &message)
 	return nil
 }
 
@@ -445,7 +736,7 @@ func (b *Bot) handleFreeFormMessage(ctx *th.Context, message t.Message) error {
 		if strings.HasPrefix(word, "@") {
 			// Remove @ and any trailing punctuation (period or comma)
 			name := strings.TrimRight(strings.TrimPrefix(word, "@"), ".,")
-			if isValidGroupName(name) {
+			if IsValidGroupName(name) {
 				groupNames = append(groupNames, name)
 			}
 		}
@@ -456,23 +747,46 @@ func (b *Bot) handleFreeFormMessage(ctx *th.Context, message t.Message) error {
 		return nil
 	}
 
+	if !b.settingEnabled(ctx, message.Chat.ID, settingFreeForm) {
+		slog.Debug("bot: Free-form mentions are disabled in chat, skipping", "chat_id", message.Chat.ID)
+		return nil
+	}
+
 	slog.Debug("bot: Found group mentions in message", "chat_id", message.Chat.ID, "group_names", groupNames)
 
-	groups, err := b.storage.FindGroupsByChatAndNamesWithMembers(message.Chat.ID, groupNames)
+	groups, err := b.storage.FindGroupsByChatAndNamesWithMembers(ctx, message.Chat.ID, groupNames)
 	if err != nil {
 		slog.Error("bot: Failed to find groups", "error", err, "chat_id", message.Chat.ID)
 		return nil
 	}
 
+	// Free-form mentions are silently ignored when the sender is not allowed to use them
+	allowedGroups := groups[:0]
+	for _, group := range groups {
+		allowed, _, err := b.hasPermission(ctx, &message, "mention", &group)
+		if err != nil {
+			slog.Error("bot: Failed to check mention permission", "error", err, "chat_id", message.Chat.ID, "group_name", group.Name)
+			continue
+		}
+		if allowed {
+			allowedGroups = append(allowedGroups, group)
+		}
+	}
+	groups = allowedGroups
+
 	if len(groups) == 0 {
 		slog.Debug("bot: No groups found for mentions", "chat_id", message.Chat.ID, "group_names", groupNames)
 		return nil
 	}
 
-	b.sendTyping(tu.ID(message.Chat.ID))
+	if !b.checkCooldown(ctx, &message, groups) {
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
 
 	slog.Debug("bot: Found groups for mentions", "chat_id", message.Chat.ID, "group_count", len(groups))
-	err = b.mentionGroups(groups, message.Chat.ID, &message)
+	err = b.mentionGroups(ctx, groups, message.Chat.ID, &message, nil)
 	if err != nil {
 		slog.Error("bot: Failed to mention group members", "error", err, "chat_id", message.Chat.ID)
 	}
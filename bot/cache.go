@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// cacheSweepInterval is how often expired entries are dropped from all caches. Most entries are never read
+// again after they expire, e.g. users seen in chats, so without a sweep they would stay in memory forever.
+const cacheSweepInterval = 10 * time.Minute
+
+// sweepable is a cache whose expired entries can be dropped
+type sweepable interface {
+	sweep(now time.Time) int
+}
+
+// ttlCache is a small concurrency-safe cache which forgets values after a fixed time
+type ttlCache[K comparable, V any] struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[K]ttlCacheEntry[V]
+}
+
+type ttlCacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:     ttl,
+		entries: make(map[K]ttlCacheEntry[V]),
+	}
+}
+
+// Get returns a cached value if it exists and has not expired yet
+func (c *ttlCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set stores a value for the cache TTL
+func (c *ttlCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = ttlCacheEntry[V]{
+		value:     value,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+// Delete removes a value from the cache
+func (c *ttlCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// sweep drops expired entries and returns how many were dropped
+func (c *ttlCache[K, V]) sweep(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// runCacheSweeper drops expired entries of all caches periodically until the context is canceled
+func (b *Bot) runCacheSweeper(ctx context.Context) {
+	caches := []sweepable{
+		b.chatAdmins,
+		b.consentRequests,
+		b.importRequests,
+		b.chatMembers,
+		b.chatTitles,
+		b.seenChatUsers,
+		b.settingsCache,
+	}
+
+	ticker := time.NewTicker(cacheSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			dropped := 0
+			for _, cache := range caches {
+				dropped += cache.sweep(now)
+			}
+			slog.Debug("bot: Expired cache entries dropped", "count", dropped)
+		}
+	}
+}
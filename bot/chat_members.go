@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+)
+
+// handleLeftChatMember removes users who left or were removed from the chat from its groups. Service messages
+// are not always sent, so chat_member updates are handled too, but Telegram sends them only to chat admins.
+func (b *Bot) handleLeftChatMember(ctx *th.Context, message t.Message) error {
+	user := message.LeftChatMember
+	slog.Debug("bot: Handling left chat member", "chat_id", message.Chat.ID, "user_id", user.ID)
+
+	// Removal of the bot itself comes as a my_chat_member update
+	if user.ID == b.bot.ID() {
+		return nil
+	}
+
+	b.removeDepartedMember(ctx, message.Chat.ID, user.ID)
+	return nil
+}
+
+// handleChatMemberUpdated removes users from groups of the chat once they are not its members anymore
+func (b *Bot) handleChatMemberUpdated(ctx *th.Context, update t.ChatMemberUpdated) error {
+	user := update.NewChatMember.MemberUser()
+	slog.Debug("bot: Handling chat member update", "chat_id", update.Chat.ID, "user_id", user.ID,
+		"old_status", update.OldChatMember.MemberStatus(), "new_status", update.NewChatMember.MemberStatus())
+
+	if update.NewChatMember.MemberIsMember() || !update.OldChatMember.MemberIsMember() {
+		return nil
+	}
+
+	b.removeDepartedMember(ctx, update.Chat.ID, user.ID)
+	return nil
+}
+
+// removeDepartedMember removes the user from all groups of the chat and forgets about them being in the chat
+func (b *Bot) removeDepartedMember(ctx context.Context, chatID int64, userID int64) {
+	// Cached answers would keep offering the chat to the user until they expire
+	key := chatUserKey{chatID: chatID, userID: userID}
+	b.chatMembers.Delete(key)
+	b.seenChatUsers.Delete(key)
+	// The user might have been an admin
+	b.chatAdmins.Delete(chatID)
+
+	if err := b.storage.RemoveChatMember(ctx, chatID, userID); err != nil {
+		slog.Error("bot: Failed to remove departed member", "error", err, "chat_id", chatID, "user_id", userID)
+		return
+	}
+	slog.Info("bot: Departed member removed from groups", "chat_id", chatID, "user_id", userID)
+}
+
+// handleMyChatMemberUpdated archives groups of the chat when the bot is removed from it and restores them
+// when the bot is added back
+func (b *Bot) handleMyChatMemberUpdated(ctx *th.Context, update t.ChatMemberUpdated) error {
+	chatID := update.Chat.ID
+	slog.Debug("bot: Handling own chat member update", "chat_id", chatID, "chat_type", update.Chat.Type,
+		"old_status", update.OldChatMember.MemberStatus(), "new_status", update.NewChatMember.MemberStatus())
+
+	// In private chats the updates tell that the user blocked or unblocked the bot
+	if update.Chat.Type != t.ChatTypeGroup && update.Chat.Type != t.ChatTypeSupergroup {
+		return nil
+	}
+
+	wasMember, isMember := update.OldChatMember.MemberIsMember(), update.NewChatMember.MemberIsMember()
+	switch {
+	case wasMember && !isMember:
+		if err := b.storage.ArchiveChatGroups(ctx, chatID, time.Now()); err != nil {
+			slog.Error("bot: Failed to archive groups of the chat", "error", err, "chat_id", chatID)
+			return nil
+		}
+		slog.Info("bot: Bot was removed from the chat, groups archived", "chat_id", chatID, "removed_by", update.From.ID)
+	case !wasMember && isMember:
+		if err := b.storage.RestoreChatGroups(ctx, chatID); err != nil {
+			slog.Error("bot: Failed to restore groups of the chat", "error", err, "chat_id", chatID)
+			return nil
+		}
+		slog.Info("bot: Bot was added to the chat, groups restored", "chat_id", chatID, "added_by", update.From.ID)
+	}
+	return nil
+}
+
+// leftChatMember matches service messages about users leaving the chat
+func leftChatMember() th.Predicate {
+	return func(_ context.Context, update t.Update) bool {
+		return update.Message != nil && update.Message.LeftChatMember != nil
+	}
+}
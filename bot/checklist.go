@@ -0,0 +1,214 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Callback data of the checklist is "groups:<owner_id>:<page>" for paging and
+// "groups:<owner_id>:<page>:<group_id>" for toggling membership, so no state is kept in the bot
+const checklistCallbackPrefix = "groups:"
+
+const checklistPageSize = 8
+
+// sendGroupChecklist sends an inline checklist of all groups in the chat where the sender can join and leave them
+func (b *Bot) sendGroupChecklist(ctx context.Context, message *t.Message) error {
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	keyboard, err := b.groupChecklistKeyboard(ctx, message.Chat.ID, message.From.ID, 0)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), message)
+		return nil
+	}
+	if keyboard == nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No groups in this chat yet. Create one with /new <name>.")), message)
+		return nil
+	}
+
+	return b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Tap a group to join or leave it.")), message, keyboard)
+}
+
+// groupChecklistKeyboard builds a page of the checklist for the user. It returns nil if the chat has no groups.
+func (b *Bot) groupChecklistKeyboard(ctx context.Context, chatID int64, userID int64, page int) (*t.InlineKeyboardMarkup, error) {
+	groups, err := b.storage.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	userGroups, err := b.storage.GetUserGroupsByChat(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	joined := make(map[uint]bool, len(userGroups))
+	for _, group := range userGroups {
+		joined[group.ID] = true
+	}
+
+	pages := (len(groups) + checklistPageSize - 1) / checklistPageSize
+	page = max(0, min(page, pages-1))
+	pageGroups := groups[page*checklistPageSize : min((page+1)*checklistPageSize, len(groups))]
+
+	rows := make([][]t.InlineKeyboardButton, 0, len(pageGroups)+1)
+	for _, group := range pageGroups {
+		mark := "⬜"
+		if joined[group.ID] {
+			mark = "✅"
+		}
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(mark+" "+group.Name).WithCallbackData(checklistCallbackData(userID, page, group.ID)),
+		))
+	}
+
+	if pages > 1 {
+		var navigation []t.InlineKeyboardButton
+		if page > 0 {
+			navigation = append(navigation, tu.InlineKeyboardButton("◀").WithCallbackData(checklistCallbackData(userID, page-1, 0)))
+		}
+		navigation = append(navigation, tu.InlineKeyboardButton(fmt.Sprintf("%d/%d", page+1, pages)).WithCallbackData(checklistCallbackData(userID, page, 0)))
+		if page < pages-1 {
+			navigation = append(navigation, tu.InlineKeyboardButton("▶").WithCallbackData(checklistCallbackData(userID, page+1, 0)))
+		}
+		rows = append(rows, navigation)
+	}
+
+	slog.Debug("bot: Group checklist built", "chat_id", chatID, "user_id", userID, "page", page, "page_count", pages)
+	return tu.InlineKeyboard(rows...), nil
+}
+
+// checklistCallbackData encodes a checklist button, zero group ID means just showing the page
+func checklistCallbackData(ownerID int64, page int, groupID uint) string {
+	data := fmt.Sprintf("%s%d:%d", checklistCallbackPrefix, ownerID, page)
+	if groupID != 0 {
+		data += fmt.Sprintf(":%d", groupID)
+	}
+	return data
+}
+
+func (b *Bot) handleChecklistCallback(ctx *th.Context, query t.CallbackQuery) error {
+	slog.Debug("bot: Handling checklist callback", "from_user_id", query.From.ID, "data", query.Data)
+
+	if query.Message == nil || !query.Message.IsAccessible() {
+		b.answerCallback(ctx, query.ID, tr(userLanguage(&query.From), "This checklist is too old, use /join again."))
+		return nil
+	}
+	chat := query.Message.GetChat()
+	lang := b.language(ctx, chat.ID, &query.From)
+
+	var ownerID int64
+	var page int
+	var groupID uint64
+	var err error
+	parts := strings.Split(strings.TrimPrefix(query.Data, checklistCallbackPrefix), ":")
+	if len(parts) >= 2 {
+		ownerID, err = strconv.ParseInt(parts[0], 10, 64)
+		if err == nil {
+			page, err = strconv.Atoi(parts[1])
+		}
+		if err == nil && len(parts) == 3 {
+			groupID, err = strconv.ParseUint(parts[2], 10, 0)
+		}
+	}
+	if len(parts) < 2 || len(parts) > 3 || err != nil {
+		slog.Warn("bot: Malformed checklist callback data", "error", err, "data", query.Data)
+		b.answerCallback(ctx, query.ID, "")
+		return nil
+	}
+
+	if query.From.ID != ownerID {
+		b.answerCallback(ctx, query.ID, tr(lang, "This checklist belongs to someone else. Use /join to get your own."))
+		return nil
+	}
+
+	notice := ""
+	if groupID != 0 {
+		notice = b.toggleMembership(ctx, lang, chat, &query.From, uint(groupID))
+	}
+
+	keyboard, err := b.groupChecklistKeyboard(ctx, chat.ID, ownerID, page)
+	if err != nil {
+		b.answerCallback(ctx, query.ID, tr(lang, "Failed to get groups: %v", err))
+		return nil
+	}
+	b.answerCallback(ctx, query.ID, notice)
+
+	if keyboard == nil {
+		keyboard = &t.InlineKeyboardMarkup{InlineKeyboard: [][]t.InlineKeyboardButton{}}
+	}
+	_, err = b.bot.EditMessageReplyMarkup(ctx, &t.EditMessageReplyMarkupParams{
+		ChatID:      tu.ID(chat.ID),
+		MessageID:   query.Message.GetMessageID(),
+		ReplyMarkup: keyboard,
+	})
+	if err != nil {
+		// Telegram refuses edits which don't change anything, e.g. when tapping the current page
+		slog.Debug("bot: Checklist was not edited", "error", err, "chat_id", chat.ID)
+	}
+	return nil
+}
+
+// toggleMembership joins or leaves the group for the user and returns the text to notify them with
+func (b *Bot) toggleMembership(ctx context.Context, lang string, chat t.Chat, user *t.User, groupID uint) string {
+	groups, err := b.storage.GetGroupsByChat(ctx, chat.ID)
+	if err != nil {
+		return tr(lang, "Failed to get groups: %v", err)
+	}
+	var group *storage.MentionGroup
+	for i := range groups {
+		if groups[i].ID == groupID {
+			group = &groups[i]
+			break
+		}
+	}
+	if group == nil {
+		return tr(lang, "This group doesn't exist anymore.")
+	}
+
+	isMember, err := b.storage.IsMember(ctx, group.ID, user.ID)
+	if err != nil {
+		return tr(lang, "Failed to check membership: %v", err)
+	}
+
+	command := "join"
+	if isMember {
+		command = "leave"
+	}
+
+	// Permission checks work with messages, so the tap is treated like a command sent by the user
+	message := &t.Message{Chat: chat, From: user}
+	allowed, _, err := b.hasPermission(ctx, message, command, group)
+	if err != nil {
+		return tr(lang, "Failed to check permissions: %v", err)
+	}
+	if !allowed {
+		slog.Debug("bot: Checklist toggle denied", "chat_id", chat.ID, "user_id", user.ID, "command", command, "group_name", group.Name)
+		if isMember {
+			return tr(lang, "You are not allowed to leave group '%s' here.", group.Name)
+		}
+		return tr(lang, "You are not allowed to join group '%s' here.", group.Name)
+	}
+
+	if isMember {
+		if err := b.storage.RemoveMember(ctx, group.ID, user.ID); err != nil {
+			return tr(lang, "Failed to leave group: %v", err)
+		}
+		slog.Info("bot: User left group", "group_name", group.Name, "chat_id", chat.ID, "user_id", user.ID)
+		return tr(lang, "You have left group '%s'.", group.Name)
+	}
+
+	if err := b.storage.AddMember(ctx, group.ID, &storage.User{ID: user.ID}); err != nil {
+		return tr(lang, "Failed to join group: %v", err)
+	}
+	slog.Info("bot: User joined group", "group_name", group.Name, "chat_id", chat.ID, "user_id", user.ID)
+	return tr(lang, "You have joined group '%s'.", group.Name)
+}
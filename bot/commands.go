@@ -0,0 +1,192 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// commandScope tells where a command is handled and offered in the command menu of Telegram clients and /help
+type commandScope int
+
+const (
+	// scopeGroup offers the command to everyone in group chats
+	scopeGroup commandScope = 1 << iota
+	// scopeGroupAdmin offers the command only to chat admins in group chats
+	scopeGroupAdmin
+	// scopePrivate offers the command in the private chat with the bot
+	scopePrivate
+)
+
+// helpLine is a single line of /help, descriptions are translated
+type helpLine struct {
+	usage       string
+	description string
+}
+
+// botCommand is a command handled by the bot. The registry of commands is the single source of handlers,
+// the /help text and the command menu, so they can't drift apart.
+type botCommand struct {
+	name    string
+	aliases []string
+	handler th.MessageHandler
+	// help is shown by /help in this order, the first description is also shown in the command menu
+	help   []helpLine
+	scopes commandScope
+}
+
+// commands returns the registry of commands in the order of /help. Groups are managed in group chats,
+// the private chat has the console to manage them remotely instead.
+func (b *Bot) commands() []botCommand {
+	return []botCommand{
+		{name: "start", handler: b.handleStart, scopes: scopePrivate, help: []helpLine{
+			{"/start", "Show your chats and manage your groups in them"},
+		}},
+		{name: "new", handler: b.handleNewGroup, scopes: scopeGroup, help: []helpLine{
+			{"/new <name>", "Create a new mention group"},
+		}},
+		{name: "join", handler: b.handleJoin, scopes: scopeGroup, help: []helpLine{
+			{"/join <name>", "Join an existing mention group"},
+		}},
+		{name: "leave", handler: b.handleLeave, scopes: scopeGroup, help: []helpLine{
+			{"/leave <name>", "Leave a mention group"},
+			{"/join or /leave", "Pick groups to join or leave from a checklist"},
+		}},
+		{name: "add", handler: b.handleAdd, scopes: scopeGroup, help: []helpLine{
+			{"/add <name> @username", "Add other users to a group, also works as a reply to their message"},
+		}},
+		{name: "remove", handler: b.handleRemove, scopes: scopeGroup, help: []helpLine{
+			{"/remove <name> @username", "Remove other users from a group, also works as a reply to their message"},
+		}},
+		{name: "mention", aliases: []string{"m", "call"}, handler: b.handleMention, scopes: scopeGroup, help: []helpLine{
+			{"/mention <name> or /m <name> or /call <name>", "Mention all members of a group"},
+		}},
+		{name: "show", handler: b.handleShowGroup, scopes: scopeGroup, help: []helpLine{
+			{"/show <name>", "Show all members of a group without mentioning them"},
+		}},
+		{name: "del", handler: b.handleDeleteGroup, scopes: scopeGroup, help: []helpLine{
+			{"/del <name>", "Delete a group (only if it has no members)"},
+		}},
+		{name: "alias", handler: b.handleAlias, scopes: scopeGroup, help: []helpLine{
+			{"/alias <name> <alias>", "Add an alternative name for a group"},
+		}},
+		{name: "unalias", handler: b.handleUnalias, scopes: scopeGroup, help: []helpLine{
+			{"/unalias <alias>", "Remove a group alias"},
+		}},
+		{name: "include", handler: b.handleInclude, scopes: scopeGroup, help: []helpLine{
+			{"/include <name> <other>", "Mention members of another group together with this one"},
+		}},
+		{name: "exclude", handler: b.handleExclude, scopes: scopeGroup, help: []helpLine{
+			{"/exclude <name> <other>", "Stop including another group"},
+		}},
+		{name: "list", handler: b.handleList, scopes: scopeGroup, help: []helpLine{
+			{"/list", "Show all groups in this chat"},
+		}},
+		{name: "my", handler: b.handleMy, scopes: scopeGroup, help: []helpLine{
+			{"/my", "Show groups you've joined in this chat"},
+		}},
+		{name: "policy", handler: b.handlePolicy, scopes: scopeGroupAdmin, help: []helpLine{
+			{"/policy", "Show roles required for commands in this chat"},
+			{"/policy <command> <role>", "Change the role required for a command (admins only)"},
+		}},
+		{name: "settings", handler: b.handleSettings, scopes: scopeGroupAdmin | scopePrivate, help: []helpLine{
+			{"/settings", "Show chat settings, admins can change them from the menu"},
+			{"/settings <key> <value>", "Change a chat setting (admins only)"},
+		}},
+		{name: "export", handler: b.handleExport, scopes: scopeGroupAdmin, help: []helpLine{
+			{"/export", "Export groups of this chat and their members to a file (admins only)"},
+		}},
+		{name: "import", handler: b.handleImport, scopes: scopeGroupAdmin, help: []helpLine{
+			{"/import", "Import groups from a file made by /export, send it as a reply to the file (admins only)"},
+		}},
+		{name: "remind", handler: b.handleRemind, scopes: scopeGroup, help: []helpLine{
+			{"/remind <name> <when> [text]", "Mention a group later, e.g. \"in 2h\", \"fri 10:00\" or \"every weekday 09:55\""},
+		}},
+		{name: "reminders", handler: b.handleReminders, scopes: scopeGroup, help: []helpLine{
+			{"/reminders", "Show reminders in this chat"},
+		}},
+		{name: "unremind", handler: b.handleUnremind, scopes: scopeGroup, help: []helpLine{
+			{"/unremind <id>", "Cancel a reminder"},
+		}},
+		{name: "help", handler: b.handleHelp, scopes: scopeGroup | scopeGroupAdmin | scopePrivate, help: []helpLine{
+			{"/help", "Show this help message"},
+		}},
+	}
+}
+
+// predicate matches messages with the command or any of its aliases sent to chats of the command scopes
+func (c botCommand) predicate() th.Predicate {
+	predicates := []th.Predicate{th.CommandEqual(c.name)}
+	for _, alias := range c.aliases {
+		predicates = append(predicates, th.CommandEqual(alias))
+	}
+	return th.And(th.Or(predicates...), inChatScope(c.scopes))
+}
+
+// inChatScope matches messages sent to chats of any of the scopes
+func inChatScope(scopes commandScope) th.Predicate {
+	return func(_ context.Context, update t.Update) bool {
+		return update.Message != nil && chatScope(update.Message.Chat)&scopes != 0
+	}
+}
+
+// commandsInScope returns commands offered in any of the scopes
+func (b *Bot) commandsInScope(scopes commandScope) []botCommand {
+	var commands []botCommand
+	for _, command := range b.commands() {
+		if command.scopes&scopes != 0 {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// chatScope returns the scopes of commands handled and listed by /help in the chat
+func chatScope(chat t.Chat) commandScope {
+	if chat.Type == t.ChatTypePrivate {
+		return scopePrivate
+	}
+	return scopeGroup | scopeGroupAdmin
+}
+
+// registerCommands sets the command menu shown by Telegram clients. Chat admins get their own list,
+// because Telegram shows the most specific scope only.
+func (b *Bot) registerCommands(ctx context.Context) error {
+	menus := []struct {
+		scope  t.BotCommandScope
+		scopes commandScope
+	}{
+		{tu.ScopeAllGroupChats(), scopeGroup},
+		{tu.ScopeAllChatAdministrators(), scopeGroup | scopeGroupAdmin},
+		{tu.ScopeAllPrivateChats(), scopePrivate},
+	}
+
+	for _, menu := range menus {
+		commands := b.commandsInScope(menu.scopes)
+		for _, lang := range supportedLanguages {
+			params := &t.SetMyCommandsParams{
+				Commands: make([]t.BotCommand, 0, len(commands)),
+				Scope:    menu.scope,
+			}
+			// Commands without a language code are shown to users of all other languages
+			if lang != defaultLanguage {
+				params.LanguageCode = lang
+			}
+			for _, command := range commands {
+				params.Commands = append(params.Commands, t.BotCommand{
+					Command:     command.name,
+					Description: tr(lang, command.help[0].description),
+				})
+			}
+
+			if err := b.bot.SetMyCommands(ctx, params); err != nil {
+				return err
+			}
+			slog.Debug("bot: Commands registered", "scope", menu.scope.ScopeType(), "language", lang, "command_count", len(params.Commands))
+		}
+	}
+	return nil
+}
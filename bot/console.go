@@ -0,0 +1,397 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Callback data of the console is "console:chats" for the list of chats, "console:<chat_id>:<page>" for a page
+// of groups of a chat and "console:<chat_id>:<page>:<action>:<group_id>" for joining, leaving and muting a group
+const (
+	consoleCallbackPrefix = "console:"
+	consoleChatsData      = "chats"
+	consoleToggleAction   = "join"
+	consoleMuteAction     = "mute"
+)
+
+const (
+	// chatUserSeenInterval is how often the time a user was seen in a chat is saved
+	chatUserSeenInterval = time.Hour
+	// maxConsoleChats limits chat membership checks and buttons of the list of chats, its text is also
+	// cut to the message length limit
+	maxConsoleChats = 20
+	// consolePageSize is the number of groups on a page of the chat menu
+	consolePageSize = 8
+)
+
+// handleStart shows the console in the private chat, it lists chats shared with the user and their groups there
+func (b *Bot) handleStart(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling start command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	text, keyboard, err := b.consoleChatsView(ctx, lang, message.From.ID)
+	if err != nil {
+		slog.Error("bot: Failed to build chat list", "error", err, "user_id", message.From.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get your chats: %v", err)), &message)
+		return nil
+	}
+	if keyboard == nil {
+		return b.sendMessage(ctx, message.Chat.ID, text, &message)
+	}
+	return b.sendMessage(ctx, message.Chat.ID, text, &message, keyboard)
+}
+
+// handleGroupOnlyCommand answers commands sent to the private chat which only work in group chats
+func (b *Bot) handleGroupOnlyCommand(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling group-only command in private chat", "chat_id", message.Chat.ID, "text", message.Text)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "This command works in group chats. Use /start to manage your groups from here or /help to see what else is available.")), &message)
+	return nil
+}
+
+// consoleChats returns IDs of group chats shared by the bot and the user. Chats are found among the chats
+// where the user was seen or has groups, their membership is then verified with Telegram.
+func (b *Bot) consoleChats(ctx context.Context, userID int64) ([]int64, error) {
+	seenChatIDs, err := b.storage.GetUserSeenChatIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Users who haven't written anything since chats started to be tracked are still known by their groups
+	memberChatIDs, err := b.storage.GetUserChatIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatIDs []int64
+	for _, chatID := range append(seenChatIDs, memberChatIDs...) {
+		if len(chatIDs) >= maxConsoleChats {
+			break
+		}
+		// Groups created in the private chat before the console existed are not shown
+		if chatID > 0 || slices.Contains(chatIDs, chatID) {
+			continue
+		}
+
+		isMember, err := b.isChatMember(ctx, chatID, userID)
+		if err != nil {
+			// The bot may have been removed from the chat
+			slog.Debug("bot: Failed to check chat membership, skipping", "error", err, "chat_id", chatID, "user_id", userID)
+			continue
+		}
+		if isMember {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	return chatIDs, nil
+}
+
+// chatGroupsWithMembers returns all groups of the chat with their members loaded
+func (b *Bot) chatGroupsWithMembers(ctx context.Context, chatID int64) ([]storage.MentionGroup, error) {
+	groups, err := b.storage.GetGroupsByChat(ctx, chatID)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	return b.storage.FindGroupsByChatAndNamesWithMembers(ctx, chatID, names)
+}
+
+// findMember returns the membership of the user in the group
+func findMember(group storage.MentionGroup, userID int64) (storage.GroupMember, bool) {
+	for _, member := range group.Members {
+		if member.UserID == userID {
+			return member, true
+		}
+	}
+	return storage.GroupMember{}, false
+}
+
+// consoleChatsView builds the list of chats with the groups the user is in. The keyboard is nil if there are no chats.
+func (b *Bot) consoleChatsView(ctx context.Context, lang string, userID int64) (string, *t.InlineKeyboardMarkup, error) {
+	chatIDs, err := b.consoleChats(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(chatIDs) == 0 {
+		text := escapeMarkdownV2(tr(lang, "I haven't seen you in any chat yet. Write something in a group chat with me and use /start again."))
+		return text, nil, nil
+	}
+
+	type section struct {
+		title string
+		items []string
+	}
+	sections := make([]section, 0, len(chatIDs))
+	rows := make([][]t.InlineKeyboardButton, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		groups, err := b.chatGroupsWithMembers(ctx, chatID)
+		if err != nil {
+			return "", nil, err
+		}
+
+		title := b.chatTitle(ctx, chatID)
+		var items []string
+		for _, group := range groups {
+			if member, ok := findMember(group, userID); ok {
+				items = append(items, "• "+group.Name+mutedMark(member))
+			}
+		}
+		if len(items) == 0 {
+			items = append(items, tr(lang, "You haven't joined any groups here."))
+		}
+		sections = append(sections, section{title: "*" + escapeMarkdownV2(title) + "*", items: items})
+
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(title).WithCallbackData(consoleChatData(chatID, 0)),
+		))
+	}
+
+	header := escapeMarkdownV2(tr(lang, "Your chats and groups:"))
+	footer := escapeMarkdownV2(tr(lang, "Tap a chat to join, leave or mute its groups."))
+	more := func(n int) string { return tr(lang, "…and %d more", n) }
+
+	// Every chat shown needs at least its title and the count of its groups, the rest of the space
+	// is filled with groups in order. Chats which don't fit are only counted, their buttons stay.
+	minLength := func(s section) int {
+		return len("\n\n") + len(s.title) + len("\n") + len(escapeMarkdownV2(more(len(s.items))))
+	}
+	budget := maxMessageLength - len(header) - len("\n\n") - len(footer) - len("\n\n") - len(escapeMarkdownV2(more(len(sections))))
+	shown := 0
+	for used := 0; shown < len(sections) && used+minLength(sections[shown]) <= budget; shown++ {
+		used += minLength(sections[shown])
+	}
+	reserved := 0
+	for _, s := range sections[:shown] {
+		reserved += minLength(s)
+	}
+
+	text := header
+	for i, s := range sections[:shown] {
+		reserved -= minLength(s)
+		limit := budget - (len(text) - len(header)) - reserved - len("\n\n") - len(s.title) - len("\n")
+		text += "\n\n" + s.title + "\n" + joinWithinLength(s.items, "\n", limit, more)
+		if i == shown-1 && shown < len(sections) {
+			text += "\n\n" + escapeMarkdownV2(more(len(sections)-shown))
+		}
+	}
+	slog.Debug("bot: Chat list built", "user_id", userID, "chat_count", len(chatIDs), "shown_chat_count", shown)
+	return text + "\n\n" + footer, tu.InlineKeyboard(rows...), nil
+}
+
+// consoleChatView builds a page of the menu of groups of the chat, joined groups can also be muted
+func (b *Bot) consoleChatView(ctx context.Context, lang string, chatID int64, userID int64, page int) (string, *t.InlineKeyboardMarkup, error) {
+	groups, err := b.chatGroupsWithMembers(ctx, chatID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	back := tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("« " + tr(lang, "Back")).WithCallbackData(consoleCallbackPrefix + consoleChatsData),
+	)
+	title := b.chatTitle(ctx, chatID)
+	if len(groups) == 0 {
+		return escapeMarkdownV2(tr(lang, "No groups in %s yet.", title)), tu.InlineKeyboard(back), nil
+	}
+
+	pages := (len(groups) + consolePageSize - 1) / consolePageSize
+	page = max(0, min(page, pages-1))
+	pageGroups := groups[page*consolePageSize : min((page+1)*consolePageSize, len(groups))]
+
+	rows := make([][]t.InlineKeyboardButton, 0, len(pageGroups)+2)
+	for _, group := range pageGroups {
+		member, joined := findMember(group, userID)
+		mark := "⬜"
+		if joined {
+			mark = "✅"
+		}
+		row := tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(mark + " " + group.Name).WithCallbackData(consoleCallbackData(chatID, page, consoleToggleAction, group.ID)),
+		)
+		if joined {
+			bell := "🔔"
+			if member.Muted {
+				bell = "🔕"
+			}
+			row = append(row, tu.InlineKeyboardButton(bell).WithCallbackData(consoleCallbackData(chatID, page, consoleMuteAction, group.ID)))
+		}
+		rows = append(rows, row)
+	}
+
+	if pages > 1 {
+		var navigation []t.InlineKeyboardButton
+		if page > 0 {
+			navigation = append(navigation, tu.InlineKeyboardButton("◀").WithCallbackData(consoleChatData(chatID, page-1)))
+		}
+		navigation = append(navigation, tu.InlineKeyboardButton(fmt.Sprintf("%d/%d", page+1, pages)).WithCallbackData(consoleChatData(chatID, page)))
+		if page < pages-1 {
+			navigation = append(navigation, tu.InlineKeyboardButton("▶").WithCallbackData(consoleChatData(chatID, page+1)))
+		}
+		rows = append(rows, navigation)
+	}
+	rows = append(rows, back)
+
+	text := escapeMarkdownV2(tr(lang, "Groups in %s:", title)) + "\n" +
+		escapeMarkdownV2(tr(lang, "Tap a group to join or leave it, tap 🔔 to mute its mentions for you."))
+	slog.Debug("bot: Chat menu built", "chat_id", chatID, "user_id", userID, "group_count", len(groups), "page", page, "page_count", pages)
+	return text, tu.InlineKeyboard(rows...), nil
+}
+
+// consoleChatData encodes a button showing the page of groups of the chat
+func consoleChatData(chatID int64, page int) string {
+	return fmt.Sprintf("%s%d:%d", consoleCallbackPrefix, chatID, page)
+}
+
+func consoleCallbackData(chatID int64, page int, action string, groupID uint) string {
+	return fmt.Sprintf("%s%d:%d:%s:%d", consoleCallbackPrefix, chatID, page, action, groupID)
+}
+
+func (b *Bot) handleConsoleCallback(ctx *th.Context, query t.CallbackQuery) error {
+	slog.Debug("bot: Handling console callback", "from_user_id", query.From.ID, "data", query.Data)
+
+	if query.Message == nil || !query.Message.IsAccessible() {
+		b.answerCallback(ctx, query.ID, tr(userLanguage(&query.From), "This menu is too old, use /start again."))
+		return nil
+	}
+	privateChat := query.Message.GetChat()
+	lang := b.language(ctx, privateChat.ID, &query.From)
+
+	data := strings.TrimPrefix(query.Data, consoleCallbackPrefix)
+	if data == consoleChatsData {
+		b.answerCallback(ctx, query.ID, "")
+		text, keyboard, err := b.consoleChatsView(ctx, lang, query.From.ID)
+		if err != nil {
+			text, keyboard = escapeMarkdownV2(tr(lang, "Failed to get your chats: %v", err)), nil
+		}
+		b.editConsole(ctx, privateChat.ID, query.Message.GetMessageID(), text, keyboard)
+		return nil
+	}
+
+	parts := strings.Split(data, ":")
+	if len(parts) == 1 || len(parts) == 3 {
+		// Menus sent before groups were paged have no page in their buttons
+		b.answerCallback(ctx, query.ID, tr(lang, "This menu is too old, use /start again."))
+		return nil
+	}
+
+	var chatID int64
+	var page int
+	var groupID uint64
+	var err error
+	chatID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err == nil && len(parts) >= 2 {
+		page, err = strconv.Atoi(parts[1])
+	}
+	if err == nil && len(parts) == 4 {
+		groupID, err = strconv.ParseUint(parts[3], 10, 0)
+	}
+	if (len(parts) != 2 && len(parts) != 4) || err != nil {
+		slog.Warn("bot: Malformed console callback data", "error", err, "data", query.Data)
+		b.answerCallback(ctx, query.ID, "")
+		return nil
+	}
+
+	isMember, err := b.isChatMember(ctx, chatID, query.From.ID)
+	if err != nil || !isMember {
+		slog.Debug("bot: User can't manage groups of the chat", "error", err, "chat_id", chatID, "user_id", query.From.ID)
+		b.answerCallback(ctx, query.ID, tr(lang, "You are not in this chat anymore."))
+		return nil
+	}
+
+	notice := ""
+	if len(parts) == 4 {
+		switch parts[2] {
+		case consoleToggleAction:
+			notice = b.toggleMembership(ctx, lang, chatWithID(chatID), &query.From, uint(groupID))
+		case consoleMuteAction:
+			notice = b.toggleMute(ctx, lang, chatID, query.From.ID, uint(groupID))
+		default:
+			slog.Warn("bot: Unknown console action", "data", query.Data)
+		}
+	}
+	b.answerCallback(ctx, query.ID, notice)
+
+	text, keyboard, err := b.consoleChatView(ctx, lang, chatID, query.From.ID, page)
+	if err != nil {
+		text, keyboard = escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), nil
+	}
+	b.editConsole(ctx, privateChat.ID, query.Message.GetMessageID(), text, keyboard)
+	return nil
+}
+
+// toggleMute mutes or unmutes mentions of the group for the member and returns the text to notify them with
+func (b *Bot) toggleMute(ctx context.Context, lang string, chatID int64, userID int64, groupID uint) string {
+	groups, err := b.chatGroupsWithMembers(ctx, chatID)
+	if err != nil {
+		return tr(lang, "Failed to get groups: %v", err)
+	}
+	index := slices.IndexFunc(groups, func(group storage.MentionGroup) bool { return group.ID == groupID })
+	if index < 0 {
+		return tr(lang, "This group doesn't exist anymore.")
+	}
+	group := groups[index]
+
+	member, ok := findMember(group, userID)
+	if !ok {
+		return tr(lang, "You are not a member of group '%s'.", group.Name)
+	}
+
+	if err := b.storage.SetMemberMuted(ctx, group.ID, userID, !member.Muted); err != nil {
+		return tr(lang, "Failed to update membership: %v", err)
+	}
+	slog.Info("bot: Member mute changed", "group_name", group.Name, "chat_id", chatID, "user_id", userID, "muted", !member.Muted)
+	if member.Muted {
+		return tr(lang, "You will be mentioned with group '%s' again.", group.Name)
+	}
+	return tr(lang, "You won't be mentioned with group '%s' anymore.", group.Name)
+}
+
+// editConsole replaces the console message, a nil keyboard removes the buttons
+func (b *Bot) editConsole(ctx context.Context, chatID int64, messageID int, text string, keyboard *t.InlineKeyboardMarkup) {
+	if keyboard == nil {
+		keyboard = &t.InlineKeyboardMarkup{InlineKeyboard: [][]t.InlineKeyboardButton{}}
+	}
+	edit := tu.EditMessageText(tu.ID(chatID), messageID, text)
+	edit.ParseMode = "MarkdownV2"
+	edit.ReplyMarkup = keyboard
+	if _, err := b.bot.EditMessageText(ctx, edit); err != nil {
+		// Telegram refuses edits which don't change anything, e.g. when a toggle was denied
+		if isNotModified(err) {
+			slog.Debug("bot: Console was not edited", "error", err, "chat_id", chatID)
+			return
+		}
+		slog.Error("bot: Failed to edit console", "error", err, "chat_id", chatID, "text_length", len(text))
+	}
+}
+
+// mutedMark marks members who muted the group
+func mutedMark(member storage.GroupMember) string {
+	if member.Muted {
+		return " 🔕"
+	}
+	return ""
+}
+
+// isNotModified tells if Telegram refused an edit because it wouldn't change the message
+func isNotModified(err error) bool {
+	var apiErr *ta.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message is not modified")
+}
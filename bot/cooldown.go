@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+)
+
+const (
+	cooldownOff = "off"
+	maxCooldown = 24 * time.Hour
+)
+
+var errInvalidCooldown = errors.New("expected 'off' or a duration up to 24h like 30s, 5m or 1h")
+
+// parseCooldown normalizes a cooldown setting value, zero durations turn the cooldown off
+func parseCooldown(value string) (string, error) {
+	if strings.EqualFold(value, cooldownOff) || value == "0" {
+		return cooldownOff, nil
+	}
+	duration, err := time.ParseDuration(strings.ToLower(value))
+	if err != nil || duration < 0 || duration > maxCooldown {
+		return "", errInvalidCooldown
+	}
+	if duration < time.Second {
+		return cooldownOff, nil
+	}
+	return formatDuration(duration.Truncate(time.Second)), nil
+}
+
+// cooldownDuration reads a value saved by parseCooldown
+func cooldownDuration(value string) time.Duration {
+	if value == "" || value == cooldownOff {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("bot: Invalid cooldown in chat settings", "error", err, "value", value)
+		return 0
+	}
+	return duration
+}
+
+// formatDuration is time.Duration.String without zero minutes and seconds, e.g. "2m" instead of "2m0s"
+func formatDuration(duration time.Duration) string {
+	text := duration.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// checkCooldown tells if the sender of the message may mention the groups now and records the mention if so.
+// Otherwise it replies with the remaining time unless the chat wants cooldowns to be silent.
+func (b *Bot) checkCooldown(ctx context.Context, message *t.Message, groups []storage.MentionGroup) bool {
+	groupCooldown, userCooldown, exempt := b.chatCooldowns(ctx, message)
+	if groupCooldown == 0 && userCooldown == 0 {
+		return true
+	}
+
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+
+	now := time.Now()
+	if !exempt {
+		remaining, err := b.cooldownRemaining(ctx, message.Chat.ID, userID, groups, groupCooldown, userCooldown, now)
+		if err != nil {
+			return true
+		}
+		if remaining > 0 {
+			slog.Debug("bot: Mention is on cooldown", "chat_id", message.Chat.ID, "user_id", userID, "remaining", remaining)
+			if b.settingEnabled(ctx, message.Chat.ID, settingCooldownReply) {
+				lang := b.language(ctx, message.Chat.ID, message.From)
+				b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Too many mentions, try again in %s.", formatDuration(remaining.Round(time.Second)))), message)
+			}
+			return false
+		}
+	}
+
+	b.recordMention(ctx, message.Chat.ID, userID, groups, exempt, now)
+	return true
+}
+
+// chatCooldowns returns the cooldowns of the chat and if the sender of the message is exempt from them.
+// Both cooldowns are zero if they are off or the settings can't be read.
+func (b *Bot) chatCooldowns(ctx context.Context, message *t.Message) (groupCooldown, userCooldown time.Duration, exempt bool) {
+	settings, err := b.getChatSettings(ctx, message.Chat.ID)
+	if err != nil {
+		// Failing open is better than blocking all mentions
+		return 0, 0, false
+	}
+	groupCooldown = cooldownDuration(settings.GroupCooldown)
+	userCooldown = cooldownDuration(settings.UserCooldown)
+	if groupCooldown == 0 && userCooldown == 0 {
+		return 0, 0, false
+	}
+
+	actual, err := b.chatRole(ctx, message)
+	if err != nil {
+		var userID int64
+		if message.From != nil {
+			userID = message.From.ID
+		}
+		slog.Error("bot: Failed to check role for cooldown", "error", err, "chat_id", message.Chat.ID, "user_id", userID)
+	}
+	return groupCooldown, userCooldown, err == nil && actual >= roleAdmin
+}
+
+// recordMention saves the time of the mention for the group and user cooldowns
+func (b *Bot) recordMention(ctx context.Context, chatID int64, userID int64, groups []storage.MentionGroup, exempt bool, now time.Time) {
+	// Mentions by admins still start the cooldown of the groups for everyone else
+	for _, group := range groups {
+		if err := b.storage.SaveLastMention(ctx, chatID, group.ID, 0, now); err != nil {
+			slog.Error("bot: Failed to save last group mention", "error", err, "chat_id", chatID, "group_id", group.ID)
+		}
+	}
+	if userID != 0 && !exempt {
+		if err := b.storage.SaveLastMention(ctx, chatID, 0, userID, now); err != nil {
+			slog.Error("bot: Failed to save last user mention", "error", err, "chat_id", chatID, "user_id", userID)
+		}
+	}
+}
+
+// cooldownRemaining returns the longest time left until the user and all the groups are off cooldown
+func (b *Bot) cooldownRemaining(ctx context.Context, chatID int64, userID int64, groups []storage.MentionGroup, groupCooldown, userCooldown time.Duration, now time.Time) (time.Duration, error) {
+	var remaining time.Duration
+	if userCooldown > 0 && userID != 0 {
+		last, err := b.storage.GetLastMention(ctx, chatID, 0, userID)
+		if err != nil {
+			return 0, err
+		}
+		remaining = max(remaining, last.Add(userCooldown).Sub(now))
+	}
+	if groupCooldown > 0 {
+		for _, group := range groups {
+			last, err := b.storage.GetLastMention(ctx, chatID, group.ID, 0)
+			if err != nil {
+				return 0, err
+			}
+			remaining = max(remaining, last.Add(groupCooldown).Sub(now))
+		}
+	}
+	return remaining, nil
+}
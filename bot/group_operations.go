@@ -1,133 +1,306 @@
 package bot
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log/slog"
 	"strings"
+	"sync/atomic"
 
+	"telegram-group-mention-bot/metrics"
 	"telegram-group-mention-bot/storage"
 
 	t "github.com/mymmrac/telego"
 )
 
-func (b *Bot) joinGroupOperation(group *storage.MentionGroup, user *t.User, chatID int64, originalMessage *t.Message) error {
+func (b *Bot) joinGroupOperation(ctx context.Context, group *storage.MentionGroup, user *t.User, chatID int64, originalMessage *t.Message) error {
 	slog.Debug("bot: Joining group", "group_name", group.Name, "chat_id", chatID, "user_id", user.ID)
 
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
 	// Check if user is already a member using storage method
-	isMember, err := b.storage.IsMember(group.ID, user.ID)
+	isMember, err := b.storage.IsMember(ctx, group.ID, user.ID)
 	if err != nil {
 		slog.Error("bot: Failed to check membership", "error", err, "group_name", group.Name, "chat_id", chatID, "user_id", user.ID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Failed to join group: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to join group: %v", err)), originalMessage)
 		return nil
 	}
 
 	if isMember {
 		slog.Debug("bot: User is already a member of the group", "group_name", group.Name, "chat_id", chatID, "user_id", user.ID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("You are already a member of group '%s'.", group.Name)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "You are already a member of group '%s'.", group.Name)), originalMessage)
 		return nil
 	}
 
 	// Add user to group - user data is already synced by middleware
-	err = b.storage.AddMember(group.ID, &storage.User{ID: user.ID})
+	err = b.storage.AddMember(ctx, group.ID, &storage.User{ID: user.ID})
 	if err != nil {
 		slog.Error("bot: Failed to add user to group", "error", err, "group_name", group.Name, "chat_id", chatID, "user_id", user.ID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Failed to join group: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to join group: %v", err)), originalMessage)
 		return nil
 	}
 
 	slog.Info("bot: User joined group", "group_name", group.Name, "chat_id", chatID, "user_id", user.ID)
-	b.sendMessage(chatID, fmt.Sprintf("You have joined group '%s'!", escapeMarkdownV2(group.Name)), originalMessage)
+	b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "You have joined group '%s'!", group.Name)), originalMessage)
 	return nil
 }
 
-func (b *Bot) leaveGroupOperation(group *storage.MentionGroup, userID int64, chatID int64, originalMessage *t.Message) error {
+func (b *Bot) leaveGroupOperation(ctx context.Context, group *storage.MentionGroup, userID int64, chatID int64, originalMessage *t.Message) error {
 	slog.Debug("bot: Leaving group", "group_name", group.Name, "chat_id", chatID, "user_id", userID)
 
-	isMember, err := b.storage.IsMember(group.ID, userID)
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	isMember, err := b.storage.IsMember(ctx, group.ID, userID)
 	if err != nil {
 		slog.Error("bot: Failed to check membership", "error", err, "group_name", group.Name, "chat_id", chatID, "user_id", userID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Failed to leave group: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to leave group: %v", err)), originalMessage)
 		return nil
 	}
 
 	if !isMember {
 		slog.Debug("bot: User is not a member of the group", "group_name", group.Name, "chat_id", chatID, "user_id", userID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("You are not a member of group '%s'.", group.Name)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "You are not a member of group '%s'.", group.Name)), originalMessage)
 		return nil
 	}
 
-	err = b.storage.RemoveMember(group.ID, userID)
+	err = b.storage.RemoveMember(ctx, group.ID, userID)
 	if err != nil {
 		slog.Error("bot: Failed to remove user from group", "error", err, "group_name", group.Name, "chat_id", chatID, "user_id", userID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Failed to leave group: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to leave group: %v", err)), originalMessage)
 		return nil
 	}
 
 	slog.Info("bot: User left group", "group_name", group.Name, "chat_id", chatID, "user_id", userID)
-	b.sendMessage(chatID, fmt.Sprintf("You have left group '%s'!", escapeMarkdownV2(group.Name)), originalMessage)
+	b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "You have left group '%s'!", group.Name)), originalMessage)
 	return nil
 }
 
-func (b *Bot) mentionGroups(groups []storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
-	slog.Debug("bot: Mentioning groups", "chat_id", chatID, "group_count", len(groups))
+// mentionGroups mentions members of the groups in reply to the original message. If lead is not nil, it's
+// queued first and the mentions reply to it once it's sent, like the text of a reminder. Members are resolved
+// before, so the queue doesn't wait for the database.
+func (b *Bot) mentionGroups(ctx context.Context, groups []storage.MentionGroup, chatID int64, originalMessage *t.Message, lead *t.SendMessageParams) error {
+	slog.Debug("bot: Mentioning groups", "chat_id", chatID, "group_count", len(groups), "has_lead", lead != nil)
 
-	var allMentions []string
-	for _, group := range groups {
-		if len(group.Members) == 0 {
-			slog.Debug("bot: Group has no members", "group_name", group.Name, "chat_id", chatID)
-			continue
-		}
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	members, err := b.groupMembers(ctx, groups)
+	if err != nil {
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to get included groups: %v", err)), originalMessage)
+		return nil
+	}
 
-		mentions := b.formatMentions(group.Members)
-		allMentions = append(allMentions, mentions...)
+	// The author already knows about their own message
+	senderExcluded := false
+	if originalMessage != nil && originalMessage.From != nil && !b.settingEnabled(ctx, chatID, settingMentionSender) {
+		members, senderExcluded = withoutUser(members, originalMessage.From.ID)
 	}
 
-	if len(allMentions) == 0 {
-		slog.Debug("bot: No members to mention", "chat_id", chatID)
-		b.sendMessage(chatID, escapeMarkdownV2("No members to mention."), originalMessage)
-		return nil
+	mentions := b.formatMentions(members)
+	send := func(replyTo *t.Message) {
+		if len(mentions) == 0 {
+			slog.Debug("bot: No members to mention", "chat_id", chatID, "sender_excluded", senderExcluded)
+			text := tr(lang, "No members to mention.")
+			if senderExcluded {
+				text = tr(lang, "No members to mention: you are the only one. Authors are not mentioned in their own mentions, chat admins can change it with /settings mention_sender yes.")
+			}
+			b.sendMessage(ctx, chatID, escapeMarkdownV2(text), replyTo)
+			return
+		}
+		b.sendMentions(ctx, lang, chatID, mentions, replyTo)
 	}
 
-	mentionText := strings.Join(allMentions, " ")
-	slog.Debug("bot: Sending mentions", "chat_id", chatID, "mention_count", len(allMentions))
-	b.sendMessage(chatID, mentionText, originalMessage)
+	if lead == nil {
+		send(originalMessage)
+		return nil
+	}
+	b.queueMessage(lead, func(sent *t.Message, err error) {
+		if err != nil {
+			slog.Error("bot: Failed to send message before mentions", "error", err, "chat_id", chatID)
+			return
+		}
+		send(sent)
+	})
 	return nil
 }
 
-func (b *Bot) deleteGroupOperation(group *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
+// sendMentions queues the mentions in as many messages as needed and reports parts which failed to send
+func (b *Bot) sendMentions(ctx context.Context, lang string, chatID int64, mentions []string, originalMessage *t.Message) {
+	// Telegram rejects messages over the text or entity limits, so big groups are sent in parts
+	parts := splitMentions(mentions)
+	slog.Debug("bot: Sending mentions", "chat_id", chatID, "mention_count", len(mentions), "part_count", len(parts))
+	metrics.Mentions.Inc()
+	metrics.MentionedUsers.Add(float64(len(mentions)))
+
+	// Parts are sent in the background, the last one to finish reports the failures
+	var pending, failed atomic.Int32
+	pending.Store(int32(len(parts)))
+	for i, part := range parts {
+		b.queueMessage(b.messageParams(chatID, part, originalMessage), func(_ *t.Message, err error) {
+			if err != nil {
+				slog.Error("bot: Failed to send mention part", "error", err, "chat_id", chatID, "part", i+1, "part_count", len(parts))
+				failed.Add(1)
+			}
+			if pending.Add(-1) == 0 && failed.Load() > 0 {
+				b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to send %d of %d mention messages. Some members were not mentioned.", failed.Load(), len(parts))), originalMessage)
+			}
+		})
+	}
+}
+
+// groupMembers returns members to mention of all the groups including the nested ones
+func (b *Bot) groupMembers(ctx context.Context, groups []storage.MentionGroup) ([]storage.GroupMember, error) {
+	expanded, err := b.expandGroups(ctx, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users who are in several of the mentioned or included groups are mentioned once. Members who
+	// muted a group are still mentioned through the other groups.
+	return uniqueMembers(withoutMuted(expanded)), nil
+}
+
+func (b *Bot) deleteGroupOperation(ctx context.Context, group *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
 	slog.Debug("bot: Deleting group", "group_name", group.Name, "chat_id", chatID)
 
-	if len(group.Members) > 0 {
-		slog.Debug("bot: Cannot delete group with members", "group_name", group.Name, "chat_id", chatID, "member_count", len(group.Members))
-		b.sendMessage(chatID, escapeMarkdownV2("Cannot delete group: it has members. Ask members to /leave first."), originalMessage)
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	members, err := b.storage.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		slog.Error("bot: Failed to get group members", "error", err, "group_name", group.Name, "chat_id", chatID)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to delete group: %v", err)), originalMessage)
+		return nil
+	}
+
+	if len(members) > 0 {
+		slog.Debug("bot: Cannot delete group with members", "group_name", group.Name, "chat_id", chatID, "member_count", len(members))
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Cannot delete group: it has members. Ask members to /leave first.")), originalMessage)
 		return nil
 	}
 
-	err := b.storage.DeleteGroup(group.ID)
+	err = b.storage.DeleteGroup(ctx, group.ID)
 	if err != nil {
 		slog.Error("bot: Failed to delete group", "error", err, "group_name", group.Name, "chat_id", chatID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Failed to delete group: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to delete group: %v", err)), originalMessage)
 		return nil
 	}
 
 	slog.Info("bot: Group deleted", "group_name", group.Name, "chat_id", chatID)
-	b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Group '%s' has been deleted!", group.Name)), originalMessage)
+	b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group '%s' has been deleted!", group.Name)), originalMessage)
 	return nil
 }
 
-func (b *Bot) showGroupMembersOperation(group *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
+func (b *Bot) showGroupMembersOperation(ctx context.Context, group *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
 	slog.Debug("bot: Showing group members", "group_name", group.Name, "chat_id", chatID)
 
-	if len(group.Members) == 0 {
-		slog.Debug("bot: Group has no members", "group_name", group.Name, "chat_id", chatID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Group '%s' has no members.", group.Name)), originalMessage)
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	members, err := b.storage.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		slog.Error("bot: Failed to get group members", "error", err, "group_name", group.Name, "chat_id", chatID)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to get group members: %v", err)), originalMessage)
+		return nil
+	}
+	group.Members = members
+
+	expanded, err := b.expandGroups(ctx, []storage.MentionGroup{*group})
+	if err != nil {
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to get included groups: %v", err)), originalMessage)
+		return nil
+	}
+	included := expanded[1:]
+
+	// Members of included groups who are also direct members are listed only once
+	direct := make(map[int64]bool, len(members))
+	for _, member := range members {
+		direct[member.UserID] = true
+	}
+	var nestedMembers []storage.GroupMember
+	for _, member := range uniqueMembers(included) {
+		if !direct[member.UserID] {
+			nestedMembers = append(nestedMembers, member)
+		}
+	}
+
+	if len(members) == 0 && len(nestedMembers) == 0 {
+		slog.Debug("bot: Group has no members", "group_name", group.Name, "chat_id", chatID, "included_count", len(included))
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group '%s' has no members.", group.Name)), originalMessage)
+		return nil
+	}
+
+	var messageText string
+	if len(members) > 0 {
+		header := escapeMarkdownV2(tr(lang, "Members of group '%s':", group.Name)) + "\n"
+		messageText = header + strings.Join(b.formatMemberList(members), "\n")
+	} else {
+		messageText = escapeMarkdownV2(tr(lang, "Group '%s' has no direct members.", group.Name))
+	}
+
+	if len(included) > 0 {
+		includedNames := make([]string, 0, len(included))
+		for _, includedGroup := range included {
+			includedNames = append(includedNames, includedGroup.Name)
+		}
+		messageText += "\n\n" + escapeMarkdownV2(tr(lang, "Included groups: %s", strings.Join(includedNames, ", ")))
+		if len(nestedMembers) > 0 {
+			messageText += "\n" + escapeMarkdownV2(tr(lang, "Members via included groups:")) + "\n" + strings.Join(b.formatMemberList(nestedMembers), "\n")
+		}
+	}
+
+	slog.Debug("bot: Sending member list", "chat_id", chatID, "member_count", len(members), "nested_member_count", len(nestedMembers))
+	b.sendMessage(ctx, chatID, messageText, originalMessage, &t.ReplyKeyboardRemove{RemoveKeyboard: true})
+	return nil
+}
+
+func (b *Bot) includeGroupOperation(ctx context.Context, parent, child *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
+	slog.Debug("bot: Including group", "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	if parent.ID == child.ID {
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "A group can't include itself.")), originalMessage)
+		return nil
+	}
+
+	// Including a group which already includes the parent, even indirectly, would create a cycle
+	descendants, err := b.expandGroups(ctx, []storage.MentionGroup{*child})
+	if err != nil {
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to include group: %v", err)), originalMessage)
+		return nil
+	}
+	for _, descendant := range descendants {
+		if descendant.ID == parent.ID {
+			slog.Debug("bot: Inclusion would create a cycle", "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+			b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Can't include group '%s' into '%s': it already includes '%s'.", child.Name, parent.Name, parent.Name)), originalMessage)
+			return nil
+		}
+	}
+
+	err = b.storage.IncludeGroup(ctx, parent.ID, child.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group '%s' already includes '%s'.", parent.Name, child.Name)), originalMessage)
+			return nil
+		}
+		slog.Error("bot: Failed to include group", "error", err, "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to include group: %v", err)), originalMessage)
+		return nil
+	}
+
+	slog.Info("bot: Group included", "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+	b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Members of group '%s' will now be mentioned with '%s'.", child.Name, parent.Name)), originalMessage)
+	return nil
+}
+
+func (b *Bot) excludeGroupOperation(ctx context.Context, parent, child *storage.MentionGroup, chatID int64, originalMessage *t.Message) error {
+	slog.Debug("bot: Excluding group", "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	err := b.storage.ExcludeGroup(ctx, parent.ID, child.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group '%s' doesn't include '%s'.", parent.Name, child.Name)), originalMessage)
+			return nil
+		}
+		slog.Error("bot: Failed to exclude group", "error", err, "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Failed to exclude group: %v", err)), originalMessage)
 		return nil
 	}
 
-	memberList := b.formatMemberList(group.Members)
-	header := fmt.Sprintf("Members of group '%s':\n", escapeMarkdownV2(group.Name))
-	messageText := header + strings.Join(memberList, "\n")
-	slog.Debug("bot: Sending member list", "chat_id", chatID, "member_count", len(memberList))
-	b.sendMessage(chatID, messageText, originalMessage, &t.ReplyKeyboardRemove{RemoveKeyboard: true})
+	slog.Info("bot: Group excluded", "parent_name", parent.Name, "child_name", child.Name, "chat_id", chatID)
+	b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group '%s' no longer includes '%s'.", parent.Name, child.Name)), originalMessage)
 	return nil
 }
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"telegram-group-mention-bot/metrics"
+)
+
+const (
+	// Long polling requests return at least every longPollingTimeout seconds and failed ones are retried
+	// after 8 seconds, so no successful poll for two minutes means polling is stuck or keeps failing
+	maxPollAge = 4 * longPollingTimeout * time.Second
+	// readinessTimeout bounds the database ping, so a locked database fails the check instead of hanging it
+	readinessTimeout = 3 * time.Second
+)
+
+// healthState is what the readiness check knows about the connection to Telegram
+type healthState struct {
+	// identified is set once GetMe succeeded on start
+	identified atomic.Bool
+	// polledAt is the Unix time in nanoseconds of the last successful getUpdates request
+	polledAt atomic.Int64
+}
+
+func (h *healthState) polled(at time.Time) {
+	h.polledAt.Store(at.UnixNano())
+}
+
+// WithHealth serves the /healthz and /readyz endpoints on the local address, e.g. ":8081"
+func WithHealth(listen string) Option {
+	return func(b *Bot) {
+		b.healthListen = listen
+	}
+}
+
+// startOpsServers serves metrics and health endpoints in the background if they are enabled
+func (b *Bot) startOpsServers() {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(listen string) *http.ServeMux {
+		if muxes[listen] == nil {
+			muxes[listen] = http.NewServeMux()
+		}
+		return muxes[listen]
+	}
+	if b.metricsListen != "" {
+		mux(b.metricsListen).Handle("GET /metrics", metrics.Handler())
+	}
+	if b.healthListen != "" {
+		mux(b.healthListen).HandleFunc("GET /healthz", b.handleHealthz)
+		mux(b.healthListen).HandleFunc("GET /readyz", b.handleReadyz)
+	}
+
+	for listen, mux := range muxes {
+		server := &http.Server{
+			Addr:    listen,
+			Handler: mux,
+		}
+		b.opsServers = append(b.opsServers, server)
+		go func() {
+			slog.Info("bot: Ops server listening", "listen", listen)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("bot: Ops server failed", "error", err, "listen", listen)
+			}
+		}()
+	}
+}
+
+// handleHealthz tells that the process is alive and serving requests
+func (b *Bot) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz tells if the bot can handle updates, failed checks are listed in the response
+func (b *Bot) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	failures := b.checkReadiness(ctx, time.Now())
+	if len(failures) > 0 {
+		slog.Warn("bot: Readiness check failed", "failures", failures)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, strings.Join(failures, "\n"))
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// checkReadiness returns descriptions of failed readiness checks
+func (b *Bot) checkReadiness(ctx context.Context, now time.Time) []string {
+	var failures []string
+	if err := b.storage.Ping(ctx); err != nil {
+		failures = append(failures, fmt.Sprintf("database: %v", err))
+	}
+	if !b.health.identified.Load() {
+		failures = append(failures, "telegram: bot information was not received")
+	}
+
+	// Updates come via webhook whenever Telegram has them, so there is nothing to poll
+	if b.webhook == nil {
+		polledAt := b.health.polledAt.Load()
+		switch {
+		case polledAt == 0:
+			failures = append(failures, "updates: no successful getUpdates yet")
+		case now.Sub(time.Unix(0, polledAt)) > maxPollAge:
+			failures = append(failures, fmt.Sprintf("updates: last successful getUpdates was %s ago", now.Sub(time.Unix(0, polledAt)).Round(time.Second)))
+		}
+	}
+	return failures
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
-	"time"
 
 	"telegram-group-mention-bot/storage"
 
@@ -14,14 +13,15 @@ import (
 )
 
 // executeOnGroup executes a function on a group if it exists
-func (b *Bot) executeOnGroup(chatID int64, groupName string, originalMessage *t.Message, operation func(*storage.MentionGroup, *t.Message) error) error {
+func (b *Bot) executeOnGroup(ctx context.Context, chatID int64, groupName string, originalMessage *t.Message, operation func(*storage.MentionGroup, *t.Message) error) error {
 	slog.Debug("bot:helpers: Requested operation execution on group", "chat_id", chatID, "group_name", groupName)
 
-	group, err := b.storage.GetGroup(groupName, chatID)
+	lang := b.replyLanguage(ctx, chatID, originalMessage)
+	group, err := b.storage.GetGroup(ctx, groupName, chatID)
 	if err != nil {
 		slog.Error("bot:helpers: Failed to get group", "error", err,
 			"group_name", groupName, "chat_id", chatID)
-		b.sendMessage(chatID, escapeMarkdownV2(fmt.Sprintf("Group not found: %v", err)), originalMessage)
+		b.sendMessage(ctx, chatID, escapeMarkdownV2(tr(lang, "Group not found: %v", err)), originalMessage)
 		return err
 	}
 
@@ -29,6 +29,88 @@ func (b *Bot) executeOnGroup(chatID int64, groupName string, originalMessage *t.
 	return operation(group, originalMessage)
 }
 
+// expandGroups returns the given groups followed by all groups they include recursively, each of them
+// only once, so inclusion cycles can't cause an endless loop. Members of the included groups are loaded.
+func (b *Bot) expandGroups(ctx context.Context, groups []storage.MentionGroup) ([]storage.MentionGroup, error) {
+	visited := make(map[uint]bool, len(groups))
+	var expanded []storage.MentionGroup
+	for _, group := range groups {
+		if !visited[group.ID] {
+			visited[group.ID] = true
+			expanded = append(expanded, group)
+		}
+	}
+
+	for i := 0; i < len(expanded); i++ {
+		children, err := b.storage.GetIncludedGroups(ctx, expanded[i].ID)
+		if err != nil {
+			slog.Error("bot:helpers: Failed to get included groups", "error", err, "group_id", expanded[i].ID)
+			return nil, err
+		}
+
+		for _, child := range children {
+			if visited[child.ID] {
+				slog.Debug("bot:helpers: Group is already expanded, skipping", "group_id", child.ID, "parent_id", expanded[i].ID)
+				continue
+			}
+			visited[child.ID] = true
+
+			child.Members, err = b.storage.GetGroupMembers(ctx, child.ID)
+			if err != nil {
+				slog.Error("bot:helpers: Failed to get included group members", "error", err, "group_id", child.ID)
+				return nil, err
+			}
+			expanded = append(expanded, child)
+		}
+	}
+
+	slog.Debug("bot:helpers: Groups expanded", "group_count", len(groups), "expanded_count", len(expanded))
+	return expanded, nil
+}
+
+// uniqueMembers merges members of the groups keeping only the first membership of every user
+func uniqueMembers(groups []storage.MentionGroup) []storage.GroupMember {
+	seen := make(map[int64]bool)
+	var members []storage.GroupMember
+	for _, group := range groups {
+		for _, member := range group.Members {
+			if seen[member.UserID] {
+				continue
+			}
+			seen[member.UserID] = true
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// withoutMuted returns the groups without members who muted them
+func withoutMuted(groups []storage.MentionGroup) []storage.MentionGroup {
+	filtered := make([]storage.MentionGroup, 0, len(groups))
+	for _, group := range groups {
+		members := make([]storage.GroupMember, 0, len(group.Members))
+		for _, member := range group.Members {
+			if !member.Muted {
+				members = append(members, member)
+			}
+		}
+		group.Members = members
+		filtered = append(filtered, group)
+	}
+	return filtered
+}
+
+// withoutUser returns the members except the user and whether the user was one of them
+func withoutUser(members []storage.GroupMember, userID int64) ([]storage.GroupMember, bool) {
+	filtered := make([]storage.GroupMember, 0, len(members))
+	for _, member := range members {
+		if member.UserID != userID {
+			filtered = append(filtered, member)
+		}
+	}
+	return filtered, len(filtered) != len(members)
+}
+
 // formatMemberList formats a list of members for display
 func (b *Bot) formatMemberList(members []storage.GroupMember) []string {
 	slog.Debug("bot:helpers: Formatting member list", "member_count", len(members))
@@ -39,11 +121,11 @@ func (b *Bot) formatMemberList(members []storage.GroupMember) []string {
 			memberList = append(memberList, escapeMarkdownV2(fmt.Sprintf("%s %s (%s)",
 				member.User.FirstName,
 				member.User.LastName,
-				member.User.Username)))
+				member.User.Username)+mutedMark(member)))
 		} else {
 			memberList = append(memberList, escapeMarkdownV2(fmt.Sprintf("%s %s",
 				member.User.FirstName,
-				member.User.LastName)))
+				member.User.LastName)+mutedMark(member)))
 		}
 	}
 
@@ -75,7 +157,36 @@ func (b *Bot) formatMentions(members []storage.GroupMember) []string {
 	return mentions
 }
 
-func (b *Bot) createGroupSelectionReplyKeyboard(commandPrefix string, groups []storage.MentionGroup) (*t.ReplyKeyboardMarkup, error) {
+// splitMentions joins mentions into as few message texts as possible while keeping each of them
+// under Telegram's message length and entity count limits
+func splitMentions(mentions []string) []string {
+	slog.Debug("bot:helpers: Splitting mentions", "mention_count", len(mentions))
+
+	var parts []string
+	var current strings.Builder
+	entities := 0
+	for _, mention := range mentions {
+		// Raw MarkdownV2 text is never shorter than the parsed one, so measuring it is safe
+		if current.Len() > 0 && (current.Len()+1+len(mention) > maxMessageLength || entities >= maxMessageEntities) {
+			parts = append(parts, current.String())
+			current.Reset()
+			entities = 0
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(mention)
+		entities++
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	slog.Debug("bot:helpers: Mentions split", "part_count", len(parts))
+	return parts
+}
+
+func (b *Bot) createGroupSelectionReplyKeyboard(lang string, commandPrefix string, groups []storage.MentionGroup) (*t.ReplyKeyboardMarkup, error) {
 	slog.Debug("bot:helpers: Creating group selection keyboard", "command_prefix", commandPrefix, "group_count", len(groups))
 
 	if len(groups) == 0 {
@@ -104,7 +215,7 @@ func (b *Bot) createGroupSelectionReplyKeyboard(commandPrefix string, groups []s
 		ResizeKeyboard:        true,
 		OneTimeKeyboard:       true,
 		Selective:             true,
-		InputFieldPlaceholder: "Select group",
+		InputFieldPlaceholder: tr(lang, "Select group"),
 	}, nil
 }
 
@@ -124,7 +235,8 @@ func escapeMarkdownV2(text string) string {
 	return result.String()
 }
 
-func isValidGroupName(name string) bool {
+// IsValidGroupName tells if the name can be used for a group: lowercase latin letters, digits and dashes
+func IsValidGroupName(name string) bool {
 	slog.Debug("bot:helpers: Validating group name", "name", name)
 
 	if len(name) == 0 {
@@ -142,9 +254,14 @@ func isValidGroupName(name string) bool {
 	return true
 }
 
-func (b *Bot) sendMessage(chatID int64, text string, originalMessage *t.Message, replyMarkup ...t.ReplyMarkup) {
-	slog.Debug("bot:helpers: Going to send message", "chat_id", chatID, "text", text, "has_reply_markup", len(replyMarkup) > 0, "has_original_message", originalMessage != nil)
+// sendMessage queues a MarkdownV2 message without waiting for it to be sent. Failures are only logged,
+// use queueMessage to handle them. The context is not used: sends are tied to the lifetime of the queue,
+// which is drained on Stop, and not to the handler which queued them, so replies outlive their updates.
+func (b *Bot) sendMessage(_ context.Context, chatID int64, text string, originalMessage *t.Message, replyMarkup ...t.ReplyMarkup) error {
+	return b.queueMessage(b.messageParams(chatID, text, originalMessage, replyMarkup...), nil)
+}
 
+func (b *Bot) messageParams(chatID int64, text string, originalMessage *t.Message, replyMarkup ...t.ReplyMarkup) *t.SendMessageParams {
 	message := tu.Message(tu.ID(chatID), text)
 	message.ParseMode = "MarkdownV2"
 	if len(replyMarkup) > 0 {
@@ -154,50 +271,57 @@ func (b *Bot) sendMessage(chatID int64, text string, originalMessage *t.Message,
 	if originalMessage != nil {
 		message = b.reply(*originalMessage, message)
 	}
+	return message
+}
 
-	_, err := b.bot.SendMessage(context.Background(), message)
-	if err != nil {
-		// Check if it's a rate limit error
-		if strings.Contains(err.Error(), "Too Many Requests") {
-			// Extract retry_after value from error message
-			// Format: "telego: sendMessage: api: 429 \"Too Many Requests: retry after 5\", migrate to chat ID: 0, retry after: 5"
-			parts := strings.Split(err.Error(), "retry after: ")
-			if len(parts) == 2 {
-				// Parse the retry_after value
-				var retryAfter int
-				if _, _ = fmt.Sscanf(parts[1], "%d", &retryAfter); retryAfter > 0 {
-					slog.Info("bot:helpers: Rate limit hit, waiting", "seconds", retryAfter)
-					time.Sleep(time.Duration(retryAfter) * time.Second)
-					_, retryErr := b.bot.SendMessage(context.Background(), message)
-					if retryErr != nil {
-						err = retryErr
-					} else {
-						slog.Info("bot:helpers: Message sent successfully after rate limit wait")
-					}
-				}
-			}
+// queueMessage adds the message to the outbound queue. The optional done function is called once the message
+// is sent or given up on, also when it can't be queued at all. It runs in the queue goroutine and must not block.
+func (b *Bot) queueMessage(message *t.SendMessageParams, done func(sent *t.Message, err error)) error {
+	chatID := message.ChatID.ID
+	slog.Debug("bot:helpers: Going to send message", "chat_id", chatID, "text", message.Text, "has_reply_markup", message.ReplyMarkup != nil, "is_reply", message.ReplyParameters != nil)
+
+	return b.queue.enqueue(message, func(sent *t.Message, err error) {
+		if err != nil {
+			slog.Error("bot:helpers: Failed to send message", "error", err, "chat_id", chatID, "text_length", len(message.Text))
+		} else {
+			slog.Debug("bot:helpers: Message sent successfully", "chat_id", chatID, "message_id", sent.MessageID)
+		}
+		if done != nil {
+			done(sent, err)
 		}
+	})
+}
+
+// queueDocument adds the document to the outbound queue like queueMessage. The request is built for every
+// attempt, as its file can be read only once.
+func (b *Bot) queueDocument(chatID int64, document func() *t.SendDocumentParams, done func(sent *t.Message, err error)) error {
+	slog.Debug("bot:helpers: Going to send document", "chat_id", chatID)
+
+	return b.queue.enqueueDocument(chatID, document, func(sent *t.Message, err error) {
 		if err != nil {
-			slog.Error("bot:helpers: Failed to send message", "error", err, "chat_id", chatID, "text_length", len(text))
+			slog.Error("bot:helpers: Failed to send document", "error", err, "chat_id", chatID)
+		} else {
+			slog.Debug("bot:helpers: Document sent successfully", "chat_id", chatID, "message_id", sent.MessageID)
 		}
-	} else {
-		slog.Debug("bot:helpers: Message sent successfully")
-	}
+		if done != nil {
+			done(sent, err)
+		}
+	})
 }
 
 // AddMember adds a user to a mention group
-func (b *Bot) AddMember(groupID uint, userID int64, username, firstName, lastName string) error {
+func (b *Bot) AddMember(ctx context.Context, groupID uint, userID int64, username, firstName, lastName string) error {
 	slog.Debug("bot:helpers: Adding member to group", "group_id", groupID, "user_id", userID, "username", username)
 
 	// First ensure the user exists in the database
-	user, err := b.storage.CreateOrUpdateUser(userID, username, firstName, lastName)
+	user, err := b.storage.CreateOrUpdateUser(ctx, userID, username, firstName, lastName)
 	if err != nil {
 		slog.Error("bot:helpers: Failed to create/update user", "error", err, "user_id", userID, "username", username)
 		return fmt.Errorf("failed to create/update user: %w", err)
 	}
 
 	// Then add them to the group
-	if err := b.storage.AddMember(groupID, user); err != nil {
+	if err := b.storage.AddMember(ctx, groupID, user); err != nil {
 		slog.Error("bot:helpers: Failed to add member", "error", err, "group_id", groupID, "user_id", userID, "username", username)
 		return fmt.Errorf("failed to add member: %w", err)
 	}
@@ -206,6 +330,44 @@ func (b *Bot) AddMember(groupID uint, userID int64, username, firstName, lastNam
 	return nil
 }
 
+// answerCallback answers a callback query, the text is shown to the user as a notification if it's not empty
+func (b *Bot) answerCallback(ctx context.Context, queryID string, text string) {
+	slog.Debug("bot:helpers: Answering callback query", "query_id", queryID, "text", text)
+	err := b.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(queryID).WithText(text))
+	if err != nil {
+		slog.Error("bot:helpers: Failed to answer callback query", "error", err, "query_id", queryID)
+	}
+}
+
+// displayName returns a human readable name of the user without mentioning them
+func displayName(user storage.User) string {
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+	if user.Username != "" {
+		return user.Username
+	}
+	return fmt.Sprintf("User %d", user.ID)
+}
+
+// chatWithID returns a chat for permission checks of chats known only by their ID. Group chats are treated
+// as supergroups, which behave the same for the checks.
+func chatWithID(chatID int64) t.Chat {
+	if chatID > 0 {
+		return t.Chat{ID: chatID, Type: t.ChatTypePrivate}
+	}
+	return t.Chat{ID: chatID, Type: t.ChatTypeSupergroup}
+}
+
+func userFromTelegram(user *t.User) storage.User {
+	return storage.User{
+		ID:        user.ID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+}
+
 func (b *Bot) reply(originalMessage t.Message, newMessage *t.SendMessageParams) *t.SendMessageParams {
 	slog.Debug("bot:helpers: Creating reply message", "original_message_id", originalMessage.MessageID)
 	return newMessage.WithReplyParameters(&t.ReplyParameters{
@@ -213,9 +375,9 @@ func (b *Bot) reply(originalMessage t.Message, newMessage *t.SendMessageParams)
 	})
 }
 
-func (b *Bot) sendTyping(chatID t.ChatID) {
+func (b *Bot) sendTyping(ctx context.Context, chatID t.ChatID) {
 	slog.Debug("bot:helpers: Setting 'typing' chat action", "chat_id", chatID)
-	err := b.bot.SendChatAction(context.Background(), tu.ChatAction(chatID, "typing"))
+	err := b.bot.SendChatAction(ctx, tu.ChatAction(chatID, "typing"))
 	if err != nil {
 		slog.Error("bot:helpers: Cannot set chat action", "error", err)
 	}
@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// mentionOfLength returns a mention link which is exactly length bytes long
+func mentionOfLength(id int, length int) string {
+	link := fmt.Sprintf("](tg://user?id=%d)", id)
+	return "[" + strings.Repeat("a", length-len(link)-1) + link
+}
+
+func TestSplitMentions(t *testing.T) {
+	manyMentions := func(n int) []string {
+		mentions := make([]string, n)
+		for i := range mentions {
+			mentions[i] = mentionOfLength(i, 30)
+		}
+		return mentions
+	}
+
+	tests := []struct {
+		name     string
+		mentions []string
+		// sizes is the number of mentions in each part
+		sizes []int
+	}{
+		{"no mentions", nil, nil},
+		{"one mention", manyMentions(1), []int{1}},
+		{"exactly the entity limit", manyMentions(maxMessageEntities), []int{maxMessageEntities}},
+		{"one over the entity limit", manyMentions(maxMessageEntities + 1), []int{maxMessageEntities, 1}},
+		{
+			// Three separators between four mentions make the text exactly maxMessageLength long
+			"exactly the length limit",
+			[]string{mentionOfLength(1, 1024), mentionOfLength(2, 1024), mentionOfLength(3, 1024), mentionOfLength(4, 1021)},
+			[]int{4},
+		},
+		{
+			"one byte over the length limit",
+			[]string{mentionOfLength(1, 1024), mentionOfLength(2, 1024), mentionOfLength(3, 1024), mentionOfLength(4, 1022)},
+			[]int{3, 1},
+		},
+		{
+			"mention longer than the limit is kept whole",
+			[]string{mentionOfLength(1, 100), mentionOfLength(2, maxMessageLength+1), mentionOfLength(3, 100)},
+			[]int{1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitMentions(tt.mentions)
+			if len(parts) != len(tt.sizes) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.sizes))
+			}
+			next := 0
+			for i, part := range parts {
+				want := strings.Join(tt.mentions[next:next+tt.sizes[i]], " ")
+				if part != want {
+					t.Errorf("part %d has %d mentions of %d bytes, want %d mentions of %d bytes",
+						i, strings.Count(part, "](tg://"), len(part), tt.sizes[i], len(want))
+				}
+				next += tt.sizes[i]
+			}
+		})
+	}
+}
+
+func TestSplitMentionsMultiByteNames(t *testing.T) {
+	// Every character of the name takes two bytes, so the limit is reached with half as many characters
+	var mentions []string
+	for i := range 150 {
+		mentions = append(mentions, fmt.Sprintf("[%s](tg://user?id=%d)", strings.Repeat("Ж", 64), i))
+	}
+
+	parts := splitMentions(mentions)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want the mentions split", len(parts))
+	}
+	for i, part := range parts {
+		if len(part) > maxMessageLength {
+			t.Errorf("part %d is %d bytes long, over the limit of %d", i, len(part), maxMessageLength)
+		}
+		if !utf8.ValidString(part) {
+			t.Errorf("part %d is not valid UTF-8, a name was cut", i)
+		}
+	}
+	if joined := strings.Join(parts, " "); joined != strings.Join(mentions, " ") {
+		t.Error("parts don't add up to all the mentions in order")
+	}
+}
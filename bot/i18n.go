@@ -0,0 +1,285 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	t "github.com/mymmrac/telego"
+)
+
+// Languages of bot replies. English texts are written in the code and serve as keys of the translations.
+const (
+	languageEnglish = "en"
+	languageRussian = "ru"
+
+	defaultLanguage = languageEnglish
+)
+
+var supportedLanguages = []string{languageEnglish, languageRussian}
+
+// tr translates a reply text and formats it like fmt.Sprintf. Texts are plain, so the result
+// still has to be escaped for MarkdownV2. Texts without a translation stay in English.
+func tr(lang string, format string, args ...any) string {
+	if translated, ok := translations[lang][format]; ok {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// userLanguage returns the supported language closest to the Telegram language of the user
+func userLanguage(user *t.User) string {
+	if user == nil {
+		return defaultLanguage
+	}
+	// Language codes are IETF tags like "en" or "pt-br"
+	code, _, _ := strings.Cut(strings.ToLower(user.LanguageCode), "-")
+	for _, lang := range supportedLanguages {
+		if code == lang {
+			return lang
+		}
+	}
+	return defaultLanguage
+}
+
+// language returns the language of replies to the user in the chat. The chat setting wins over
+// the language of the user, who may be nil for messages not caused by anyone.
+func (b *Bot) language(ctx context.Context, chatID int64, user *t.User) string {
+	settings, err := b.getChatSettings(ctx, chatID)
+	if err == nil && settings.Language != "" && settings.Language != languageAuto {
+		return settings.Language
+	}
+	return userLanguage(user)
+}
+
+// replyLanguage returns the language of a reply to the message, which may be nil
+func (b *Bot) replyLanguage(ctx context.Context, chatID int64, message *t.Message) string {
+	if message == nil {
+		return b.language(ctx, chatID, nil)
+	}
+	return b.language(ctx, chatID, message.From)
+}
+
+// translations maps English texts to their translations by language
+var translations = map[string]map[string]string{
+	languageRussian: {
+		"Usage: /new <group_name>\nGroup name can only contain lowercase letters, numbers, and dashes.": "Использование: /new <название_группы>\nНазвание группы может содержать только строчные латинские буквы, цифры и дефисы.",
+		"Invalid group name. Group name can only contain lowercase letters, numbers, and dashes.":       "Неверное название группы. Название может содержать только строчные латинские буквы, цифры и дефисы.",
+		"Failed to create group: %v":                                          "Не удалось создать группу: %v",
+		"Group '%s' created successfully!\nTo join this group, use: /join %s": "Группа '%s' создана!\nЧтобы вступить в неё, используйте: /join %s",
+		"Failed to get groups: %v":                                            "Не удалось получить группы: %v",
+		"Failed to create keyboard: %v":                                       "Не удалось создать клавиатуру: %v",
+		"No groups available to mention.":                                     "Нет групп, которые можно упомянуть.",
+		"Select a group to mention.":                                          "Выберите группу для упоминания.",
+		"Failed to find group: %v":                                            "Не удалось найти группу: %v",
+		"Group '%s' not found.":                                               "Группа '%s' не найдена.",
+		"No groups available to delete.":                                      "Нет групп, которые можно удалить.",
+		"Select a group to delete.":                                           "Выберите группу для удаления.",
+		"No groups available to show.":                                        "Нет групп, которые можно показать.",
+		"Select a group to show.":                                             "Выберите группу для просмотра.",
+		"Usage: /alias <group_name> <alias>\nAlias can only contain lowercase letters, numbers, and dashes.": "Использование: /alias <название_группы> <псевдоним>\nПсевдоним может содержать только строчные латинские буквы, цифры и дефисы.",
+		"Invalid alias. Alias can only contain lowercase letters, numbers, and dashes.":                      "Неверный псевдоним. Псевдоним может содержать только строчные латинские буквы, цифры и дефисы.",
+		"'%s' is reserved and can't be used as an alias.":                                                    "'%s' зарезервировано и не может быть псевдонимом.",
+		"Name '%s' is already used by a group or an alias in this chat.":                                     "Имя '%s' уже занято группой или псевдонимом в этом чате.",
+		"Failed to create alias: %v":                                                                         "Не удалось создать псевдоним: %v",
+		"'%s' is now an alias of group '%s'.":                                                                "'%s' теперь псевдоним группы '%s'.",
+		"Usage: /unalias <alias>":                                                                            "Использование: /unalias <псевдоним>",
+		"'%s' is a group name, not an alias. Use /del to delete groups.":                                     "'%s' — это название группы, а не псевдоним. Группы удаляются командой /del.",
+		"Failed to delete alias: %v":                                                                         "Не удалось удалить псевдоним: %v",
+		"Alias '%s' of group '%s' deleted.":                                                                  "Псевдоним '%s' группы '%s' удалён.",
+		"Usage: /include <group_name> <included_group_name>":                                                 "Использование: /include <название_группы> <название_включаемой_группы>",
+		"Usage: /exclude <group_name> <included_group_name>":                                                 "Использование: /exclude <название_группы> <название_включённой_группы>",
+		"Available commands:":                                                                                "Доступные команды:",
+		"No groups found in this chat.":                                                                      "В этом чате нет групп.",
+		"Groups in this chat:":                                                                               "Группы в этом чате:",
+		"Failed to get your groups: %v":                                                                      "Не удалось получить ваши группы: %v",
+		"You haven't joined any groups in this chat.":                                                        "Вы не состоите ни в одной группе этого чата.",
+		"Groups you've joined in this chat:":                                                                 "Ваши группы в этом чате:",
+		"No groups in this chat yet. Create one with /new <name>.":                                           "В этом чате пока нет групп. Создайте группу командой /new <название>.",
+		"Tap a group to join or leave it.":                                                                   "Нажмите на группу, чтобы вступить в неё или выйти.",
+		"This checklist is too old, use /join again.":                                                        "Этот список устарел, вызовите /join ещё раз.",
+		"This checklist belongs to someone else. Use /join to get your own.":                                 "Это чужой список. Вызовите /join, чтобы получить свой.",
+		"This group doesn't exist anymore.":                                                                  "Этой группы больше нет.",
+		"Failed to check membership: %v":                                                                     "Не удалось проверить участие: %v",
+		"Failed to check permissions: %v":                                                                    "Не удалось проверить права: %v",
+		"You are not allowed to leave group '%s' here.":                                                      "Вам нельзя выходить из группы '%s' в этом чате.",
+		"You are not allowed to join group '%s' here.":                                                       "Вам нельзя вступать в группу '%s' в этом чате.",
+		"Failed to leave group: %v":                                                                          "Не удалось выйти из группы: %v",
+		"You have left group '%s'.":                                                                          "Вы вышли из группы '%s'.",
+		"Failed to join group: %v":                                                                           "Не удалось вступить в группу: %v",
+		"You have joined group '%s'.":                                                                        "Вы вступили в группу '%s'.",
+		"expected 'off' or a duration up to 24h like 30s, 5m or 1h":                                          "ожидается 'off' или длительность до 24h, например 30s, 5m или 1h",
+		"Too many mentions, try again in %s.":                                                                "Слишком много упоминаний, попробуйте через %s.",
+		"You are already a member of group '%s'.":                                                            "Вы уже состоите в группе '%s'.",
+		"You have joined group '%s'!":                                                                        "Вы вступили в группу '%s'!",
+		"You are not a member of group '%s'.":                                                                "Вы не состоите в группе '%s'.",
+		"You have left group '%s'!":                                                                          "Вы вышли из группы '%s'!",
+		"Failed to get included groups: %v":                                                                  "Не удалось получить включённые группы: %v",
+		"No members to mention.":                                                                             "Некого упоминать.",
+		"No members to mention: you are the only one. Authors are not mentioned in their own mentions, chat admins can change it with /settings mention_sender yes.": "Некого упоминать: в группе только вы. Автор не упоминается в собственных упоминаниях, администраторы чата могут изменить это командой /settings mention_sender yes.",
+		"Failed to send %d of %d mention messages. Some members were not mentioned.":                                                                                 "Не удалось отправить сообщений с упоминаниями: %d из %d. Некоторые участники не были упомянуты.",
+		"Failed to delete group: %v":                                        "Не удалось удалить группу: %v",
+		"Cannot delete group: it has members. Ask members to /leave first.": "Нельзя удалить группу, в которой есть участники. Попросите их сначала выйти командой /leave.",
+		"Group '%s' has been deleted!":                                      "Группа '%s' удалена!",
+		"Failed to get group members: %v":                                   "Не удалось получить участников группы: %v",
+		"Group '%s' has no members.":                                        "В группе '%s' нет участников.",
+		"Members of group '%s':":                                            "Участники группы '%s':",
+		"Group '%s' has no direct members.":                                 "В группе '%s' нет собственных участников.",
+		"Included groups: %s":                                               "Включённые группы: %s",
+		"Members via included groups:":                                      "Участники включённых групп:",
+		"A group can't include itself.":                                     "Группа не может включать саму себя.",
+		"Failed to include group: %v":                                       "Не удалось включить группу: %v",
+		"Can't include group '%s' into '%s': it already includes '%s'.":     "Нельзя включить группу '%s' в '%s': она уже включает '%s'.",
+		"Group '%s' already includes '%s'.":                                 "Группа '%s' уже включает '%s'.",
+		"Members of group '%s' will now be mentioned with '%s'.":            "Участники группы '%s' теперь будут упоминаться вместе с '%s'.",
+		"Group '%s' doesn't include '%s'.":                                  "Группа '%s' не включает '%s'.",
+		"Failed to exclude group: %v":                                       "Не удалось исключить группу: %v",
+		"Group '%s' no longer includes '%s'.":                               "Группа '%s' больше не включает '%s'.",
+		"Group not found: %v":                                               "Группа не найдена: %v",
+		"Select group":                                                      "Выберите группу",
+		"too many members for one message, use /mention in the chat":        "слишком много участников для одного сообщения, используйте /mention в чате",
+		"%s (%d members)":                                                   "%s (участников: %d)",
+		"Usage: /%s <group_name> @username...\nOr reply to a message of the user with /%s <group_name>.": "Использование: /%s <название_группы> @username...\nИли ответьте на сообщение пользователя командой /%s <название_группы>.",
+		"Failed to find users: %v": "Не удалось найти пользователей: %v",
+		"I haven't seen %s in this chat. They need to write something here first, or reply to their message instead.": "Я не видел %s в этом чате. Сначала нужно написать что-нибудь здесь, или ответьте на их сообщение.",
+		"Mention users with @username or reply to their message with /%s <group_name>.":                               "Укажите пользователей через @username или ответьте на их сообщение командой /%s <название_группы>.",
+		"Failed to get chat settings: %v":         "Не удалось получить настройки чата: %v",
+		"Failed to request confirmation: %v":      "Не удалось запросить подтверждение: %v",
+		"%s wants to add you to group '%s'.":      "%s хочет добавить вас в группу '%s'.",
+		"%s wants to remove you from group '%s'.": "%s хочет удалить вас из группы '%s'.",
+		"Accept":                           "Принять",
+		"Decline":                          "Отклонить",
+		"This request has expired.":        "Срок действия запроса истёк.",
+		"Only %s can answer this request.": "Ответить на этот запрос может только %s.",
+		"%s declined the request for group '%s'.":                                   "%s отклоняет запрос для группы '%s'.",
+		"Group '%s' doesn't exist anymore.":                                         "Группы '%s' больше нет.",
+		"%s is already a member of group '%s'.":                                     "%s уже в группе '%s'.",
+		"Failed to add %s: %v":                                                      "Не удалось добавить %s: %v",
+		"%s has been added to group '%s'.":                                          "%s теперь в группе '%s'.",
+		"%s is not a member of group '%s'.":                                         "%s не состоит в группе '%s'.",
+		"Failed to remove %s: %v":                                                   "Не удалось удалить %s: %v",
+		"%s has been removed from group '%s'.":                                      "%s больше не в группе '%s'.",
+		"Only members of group '%s' and chat admins can use /%s here.":              "В этом чате /%[2]s могут использовать только участники группы '%[1]s' и администраторы.",
+		"Only chat admins can use /%s here.":                                        "В этом чате /%s могут использовать только администраторы.",
+		"Only the chat creator can use /%s here.":                                   "В этом чате /%s может использовать только создатель чата.",
+		"Failed to get command policy: %v":                                          "Не удалось получить правила команд: %v",
+		"Roles required for commands in this chat:":                                 "Роли, необходимые для команд в этом чате:",
+		"Usage: /policy <command> <role>\nRoles: everyone, member, admin, creator.": "Использование: /policy <команда> <роль>\nРоли: everyone, member, admin, creator.",
+		"Only chat admins can change the command policy.":                           "Менять правила команд могут только администраторы чата.",
+		"Unknown command '%s'. Available: %s.":                                      "Неизвестная команда '%s'. Доступные: %s.",
+		"Unknown role '%s'. Available: everyone, member, admin, creator.":           "Неизвестная роль '%s'. Доступные: everyone, member, admin, creator.",
+		"Role 'member' needs a target group and can't be used for /%s.":             "Роли 'member' нужна целевая группа, её нельзя использовать для /%s.",
+		"Failed to update command policy: %v":                                       "Не удалось изменить правила команд: %v",
+		"Command /%s now requires role '%s'.":                                       "Команда /%s теперь требует роль '%s'.",
+		"Usage: /remind <group_name> <when> [text]\nWhen: in 2h, 10:00, fri 10:00, tomorrow 10:00, 2025-12-31 10:00, every weekday 09:55, every mon,thu 18:00": "Использование: /remind <название_группы> <когда> [текст]\nКогда: in 2h, 10:00, fri 10:00, tomorrow 10:00, 2025-12-31 10:00, every weekday 09:55, every mon,thu 18:00",
+		"Invalid time: %s.":           "Неверное время: %s.",
+		"Failed to get reminders: %v": "Не удалось получить напоминания: %v",
+		"This chat already has %d reminders. Remove some with /unremind <id> first.":     "Напоминаний в этом чате уже %d. Сначала удалите лишние командой /unremind <id>.",
+		"Failed to create reminder: %v":                                                  "Не удалось создать напоминание: %v",
+		"%s, next on %s":                                                                 "%s, следующее %s",
+		"Reminder #%d for group '%s' is set: %s.\nCancel it with /unremind %d.":          "Напоминание #%d для группы '%s' установлено: %s.\nОтменить: /unremind %d.",
+		"No reminders in this chat. Create one with /remind <group_name> <when> [text].": "В этом чате нет напоминаний. Создайте напоминание командой /remind <название_группы> <когда> [текст].",
+		"Reminders in this chat:":                                                        "Напоминания в этом чате:",
+		"Usage: /unremind <id>\nSee reminder IDs with /reminders.":                       "Использование: /unremind <id>\nID напоминаний показывает /reminders.",
+		"Invalid reminder ID '%s'.":                                                      "Неверный ID напоминания '%s'.",
+		"Reminder #%d not found.":                                                        "Напоминание #%d не найдено.",
+		"Failed to get group: %v":                                                        "Не удалось получить группу: %v",
+		"Failed to delete reminder: %v":                                                  "Не удалось удалить напоминание: %v",
+		"Reminder #%d for group '%s' has been removed.":                                  "Напоминание #%d для группы '%s' удалено.",
+		"Reminder for group '%s'":                                                        "Напоминание для группы '%s'",
+		"expected time as HH:MM":                                                         "ожидается время в формате ЧЧ:ММ",
+		"expected days as day, weekday, weekend or names like mon,wed,fri":               "ожидаются дни: day, weekday, weekend или названия вроде mon,wed,fri",
+		"expected duration like 30m, 2h or 1d":                                           "ожидается длительность вроде 30m, 2h или 1d",
+		"expected 'in 2h', '10:00', 'fri 10:00', 'tomorrow 10:00', '2025-12-31 10:00' or 'every weekday 09:55'": "ожидается 'in 2h', '10:00', 'fri 10:00', 'tomorrow 10:00', '2025-12-31 10:00' или 'every weekday 09:55'",
+		"the time is in the past":                  "это время уже прошло",
+		"Usage: /settings <key> <value>":           "Использование: /settings <ключ> <значение>",
+		"Only chat admins can change settings.":    "Менять настройки могут только администраторы чата.",
+		"Unknown setting '%s'. Available: %s.":     "Неизвестная настройка '%s'. Доступные: %s.",
+		"Invalid value '%s' of %s: %s":             "Неверное значение '%s' для %s: %s",
+		"Unknown value '%s' of %s. Available: %s.": "Неизвестное значение '%s' для %s. Доступные: %s.",
+		"Failed to update settings: %v":            "Не удалось изменить настройки: %v",
+		"Setting %s is now '%s'.":                  "Настройка %s теперь '%s'.",
+		"Settings of this chat:":                   "Настройки этого чата:",
+		"Chat admins can tap a button to switch a setting or use /settings <key> <value>.":                                      "Администраторы чата могут переключать настройки кнопками или командой /settings <ключ> <значение>.",
+		"This menu is too old, use /settings again.":                                                                            "Это меню устарело, вызовите /settings ещё раз.",
+		"Failed to get your chats: %v":                                                                                          "Не удалось получить ваши чаты: %v",
+		"This command works in group chats. Use /start to manage your groups from here or /help to see what else is available.": "Эта команда работает в групповых чатах. Здесь вы можете управлять своими группами через /start, остальные команды есть в /help.",
+		"I haven't seen you in any chat yet. Write something in a group chat with me and use /start again.":                     "Я ещё не видел вас ни в одном чате. Напишите что-нибудь в групповом чате, где я есть, и вызовите /start ещё раз.",
+		"You haven't joined any groups here.":                                                                                   "Вы не состоите ни в одной группе.",
+		"Your chats and groups:":                                                                                                "Ваши чаты и группы:",
+		"Tap a chat to join, leave or mute its groups.":                                                                         "Нажмите на чат, чтобы вступить в его группы, выйти из них или отключить упоминания.",
+		"Back":                 "Назад",
+		"No groups in %s yet.": "В чате %s пока нет групп.",
+		"Groups in %s:":        "Группы в чате %s:",
+		"Tap a group to join or leave it, tap 🔔 to mute its mentions for you.":                              "Нажмите на группу, чтобы вступить в неё или выйти, нажмите 🔔, чтобы отключить её упоминания для себя.",
+		"This menu is too old, use /start again.":                                                           "Это меню устарело, вызовите /start ещё раз.",
+		"You are not in this chat anymore.":                                                                 "Вас больше нет в этом чате.",
+		"Failed to update membership: %v":                                                                   "Не удалось изменить участие: %v",
+		"You will be mentioned with group '%s' again.":                                                      "Вас снова будут упоминать с группой '%s'.",
+		"You won't be mentioned with group '%s' anymore.":                                                   "Вас больше не будут упоминать с группой '%s'.",
+		"Failed to export groups: %v":                                                                       "Не удалось экспортировать группы: %v",
+		"Groups of this chat: %d. Reply to this file with /import to restore them here or in another chat.": "Групп в этом чате: %d. Ответьте на этот файл командой /import, чтобы восстановить их здесь или в другом чате.",
+		"Reply with /import to a file made by /export.":                                                     "Ответьте командой /import на файл, созданный через /export.",
+		"This file is too large to be an export.":                                                           "Этот файл слишком большой для экспорта групп.",
+		"This file is not an export of groups: %v":                                                          "Этот файл не является экспортом групп: %v",
+		"Failed to download the file: %v":                                                                   "Не удалось скачать файл: %v",
+		"Invalid group name '%s' in the file.":                                                              "Неверное название группы '%s' в файле.",
+		"Failed to import groups: %v":                                                                       "Не удалось импортировать группы: %v",
+		"Nothing to import, all groups and members from the file are already here.":                         "Импортировать нечего, все группы и участники из файла уже здесь.",
+		"Import":                       "Импортировать",
+		"Cancel":                       "Отмена",
+		"+ %s (new group)":             "+ %s (новая группа)",
+		"    already in the group: %d": "    уже в группе: %d",
+		"    + alias %s":               "    + псевдоним %s",
+		"    ! alias %s is skipped, another group has this name": "    ! псевдоним %s пропущен, это имя занято другой группой",
+		"    + includes %s": "    + включает %s",
+		"    ! including %s is skipped, it would make a cycle": "    ! включение %s пропущено, получился бы цикл",
+		"…and %d more lines":                                   "…и ещё строк: %d",
+		"…and %d more":                                         "…и ещё %d",
+		"Import from a file exported on %s:":                   "Импорт из файла, экспортированного %s:",
+		"Unchanged: %s":                                        "Без изменений: %s",
+		"Nothing is removed by the import. Apply it?":          "Импорт ничего не удаляет. Применить?",
+		"Import canceled.":                                     "Импорт отменён.",
+		"Import done: %d groups created, %d members, %d aliases and %d inclusions added.": "Импорт завершён: создано групп: %d, добавлено участников: %d, псевдонимов: %d, включений: %d.",
+		"/join or /leave": "/join или /leave",
+		"/mention <name> or /m <name> or /call <name>":                                            "/mention <name>, /m <name> или /call <name>",
+		"Create a new mention group":                                                              "Создать новую группу для упоминаний",
+		"Join an existing mention group":                                                          "Вступить в существующую группу",
+		"Leave a mention group":                                                                   "Выйти из группы",
+		"Pick groups to join or leave from a checklist":                                           "Выбрать группы для вступления или выхода из списка",
+		"Add other users to a group, also works as a reply to their message":                      "Добавить других пользователей в группу, работает и в ответ на их сообщение",
+		"Remove other users from a group, also works as a reply to their message":                 "Удалить других пользователей из группы, работает и в ответ на их сообщение",
+		"Mention all members of a group":                                                          "Упомянуть всех участников группы",
+		"Show all members of a group without mentioning them":                                     "Показать участников группы без упоминания",
+		"Delete a group (only if it has no members)":                                              "Удалить группу (только без участников)",
+		"Add an alternative name for a group":                                                     "Добавить группе другое имя",
+		"Remove a group alias":                                                                    "Удалить псевдоним группы",
+		"Mention members of another group together with this one":                                 "Упоминать участников другой группы вместе с этой",
+		"Stop including another group":                                                            "Перестать включать другую группу",
+		"Show all groups in this chat":                                                            "Показать все группы этого чата",
+		"Show groups you've joined in this chat":                                                  "Показать ваши группы в этом чате",
+		"Show roles required for commands in this chat":                                           "Показать роли, необходимые для команд в этом чате",
+		"Change the role required for a command (admins only)":                                    "Изменить роль, необходимую для команды (только администраторы)",
+		"Show chat settings, admins can change them from the menu":                                "Показать настройки чата, администраторы могут менять их из меню",
+		"Change a chat setting (admins only)":                                                     "Изменить настройку чата (только администраторы)",
+		"Mention a group later, e.g. \"in 2h\", \"fri 10:00\" or \"every weekday 09:55\"":         "Упомянуть группу позже, например \"in 2h\", \"fri 10:00\" или \"every weekday 09:55\"",
+		"Show reminders in this chat":                                                             "Показать напоминания в этом чате",
+		"Cancel a reminder":                                                                       "Отменить напоминание",
+		"Show this help message":                                                                  "Показать эту справку",
+		"Export groups of this chat and their members to a file (admins only)":                    "Экспортировать группы этого чата и их участников в файл (только администраторы)",
+		"Import groups from a file made by /export, send it as a reply to the file (admins only)": "Импортировать группы из файла, созданного через /export, командой в ответ на файл (только администраторы)",
+		"Show your chats and manage your groups in them":                                          "Показать ваши чаты и управлять группами в них",
+		"add everyone who writes in the chat to the 'all' group if it exists":                     "добавлять всех, кто пишет в чат, в группу 'all', если она есть",
+		"mention groups written as @name in any message, not only with /mention":                  "упоминать группы, написанные как @name в любом сообщении, а не только через /mention",
+		"mention the author of a mention too if they are in the group":                            "упоминать и автора упоминания, если он в группе",
+		"how often the same group can be mentioned, like 30s or 5m, chat admins are exempt":       "как часто можно упоминать одну группу, например 30s или 5m, на администраторов не действует",
+		"how often the same user can mention groups, like 30s or 5m, chat admins are exempt":      "как часто один пользователь может упоминать группы, например 30s или 5m, на администраторов не действует",
+		"reply with the remaining time to mentions on cooldown instead of ignoring them":          "отвечать на упоминания во время паузы оставшимся временем, а не игнорировать их",
+		"who confirms /add and /remove of other users: 'target' - always the user, 'admin' - nobody when done by a chat admin": "кто подтверждает /add и /remove других пользователей: 'target' - всегда сам пользователь, 'admin' - никто, если команду дал администратор",
+		"language of bot replies, 'auto' follows the Telegram language of each user":                                           "язык ответов бота, 'auto' - язык Telegram каждого пользователя",
+		"time zone of reminders, like Europe/Berlin":                                                                           "часовой пояс напоминаний, например Europe/Moscow",
+	},
+}
@@ -0,0 +1,272 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	chatMembersCacheTTL = 10 * time.Minute
+	chatTitlesCacheTTL  = time.Hour
+
+	// Telegram accepts at most 50 results per inline query answer
+	maxInlineResults = 50
+	// Results depend on the user's chats, so they are cached by Telegram only briefly
+	inlineCacheTime = 10
+)
+
+type chatUserKey struct {
+	chatID int64
+	userID int64
+}
+
+// handleInlineQuery suggests groups to mention from the message composer. Inline queries don't tell
+// which chat they come from, so groups of every chat the user is still a member of are offered.
+func (b *Bot) handleInlineQuery(ctx *th.Context, query t.InlineQuery) error {
+	slog.Debug("bot: Handling inline query", "from_user_id", query.From.ID, "chat_type", query.ChatType)
+
+	search := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query.Query), "@"))
+
+	results, err := b.inlineResults(ctx, &query.From, search)
+	if err != nil {
+		slog.Error("bot: Failed to build inline query results", "error", err, "from_user_id", query.From.ID)
+	}
+
+	answer := tu.InlineQuery(query.ID, results...)
+	answer.IsPersonal = true
+	answer.CacheTime = inlineCacheTime
+	if err := b.bot.AnswerInlineQuery(ctx, answer); err != nil {
+		slog.Error("bot: Failed to answer inline query", "error", err, "from_user_id", query.From.ID)
+	}
+	return nil
+}
+
+// handleChosenInlineResult starts the cooldowns of a mention sent from inline mode. Telegram sends chosen
+// results only if inline feedback is enabled for the bot.
+func (b *Bot) handleChosenInlineResult(ctx *th.Context, result t.ChosenInlineResult) error {
+	slog.Debug("bot: Handling chosen inline result", "from_user_id", result.From.ID, "result_id", result.ResultID)
+
+	chatID, groupID, ok := parseInlineResultID(result.ResultID)
+	if !ok {
+		slog.Warn("bot: Invalid inline result ID", "result_id", result.ResultID, "from_user_id", result.From.ID)
+		return nil
+	}
+
+	message := &t.Message{Chat: chatWithID(chatID), From: &result.From}
+	groupCooldown, userCooldown, exempt := b.chatCooldowns(ctx, message)
+	if groupCooldown == 0 && userCooldown == 0 {
+		return nil
+	}
+	b.recordMention(ctx, chatID, result.From.ID, []storage.MentionGroup{{ID: groupID}}, exempt, time.Now())
+	return nil
+}
+
+// inlineResultID identifies a result by the chat and the group, so the mention can be recorded when it's chosen
+func inlineResultID(chatID int64, groupID uint) string {
+	return fmt.Sprintf("%d:%d", chatID, groupID)
+}
+
+func parseInlineResultID(id string) (chatID int64, groupID uint, ok bool) {
+	chatPart, groupPart, found := strings.Cut(id, ":")
+	if !found {
+		return 0, 0, false
+	}
+	chatID, err := strconv.ParseInt(chatPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	group, err := strconv.ParseUint(groupPart, 10, 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	return chatID, uint(group), true
+}
+
+func (b *Bot) inlineResults(ctx context.Context, user *t.User, search string) ([]t.InlineQueryResult, error) {
+	// Chats are discovered through group memberships, membership in the chat itself is then verified
+	chatIDs, err := b.storage.GetUserChatIDs(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []t.InlineQueryResult
+	for _, chatID := range chatIDs {
+		if len(results) >= maxInlineResults {
+			break
+		}
+
+		isMember, err := b.isChatMember(ctx, chatID, user.ID)
+		if err != nil {
+			slog.Error("bot: Failed to check chat membership", "error", err, "chat_id", chatID, "user_id", user.ID)
+			continue
+		}
+		if !isMember {
+			slog.Debug("bot: User is not in the chat anymore, skipping", "chat_id", chatID, "user_id", user.ID)
+			continue
+		}
+
+		chatResults, err := b.inlineChatResults(ctx, chatID, user, search)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, chatResults...)
+	}
+
+	if len(results) > maxInlineResults {
+		results = results[:maxInlineResults]
+	}
+	slog.Debug("bot: Inline query results built", "user_id", user.ID, "chat_count", len(chatIDs), "result_count", len(results))
+	return results, nil
+}
+
+// inlineChatResults returns results for groups of the chat matching the search by name or alias
+func (b *Bot) inlineChatResults(ctx context.Context, chatID int64, user *t.User, search string) ([]t.InlineQueryResult, error) {
+	groups, err := b.storage.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, group := range groups {
+		if groupMatches(group, search) {
+			names = append(names, group.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	groups, err = b.storage.FindGroupsByChatAndNamesWithMembers(ctx, chatID, names)
+	if err != nil {
+		return nil, err
+	}
+
+	chatTitle := b.chatTitle(ctx, chatID)
+
+	// Permission checks work with messages, so the query is treated like a message sent to the chat
+	message := &t.Message{Chat: chatWithID(chatID), From: user}
+
+	mentionsSender := b.settingEnabled(ctx, chatID, settingMentionSender)
+	// Groups on cooldown are left out, the mention is recorded once the user picks a result
+	groupCooldown, userCooldown, exempt := b.chatCooldowns(ctx, message)
+	now := time.Now()
+	// Results are seen by the user before they are sent to the chat
+	lang := userLanguage(user)
+
+	var results []t.InlineQueryResult
+	for _, group := range groups {
+		allowed, _, err := b.hasPermission(ctx, message, "mention", &group)
+		if err != nil {
+			return results, err
+		}
+		if !allowed {
+			continue
+		}
+		if !exempt {
+			remaining, err := b.cooldownRemaining(ctx, chatID, user.ID, []storage.MentionGroup{group}, groupCooldown, userCooldown, now)
+			if err != nil {
+				return results, err
+			}
+			if remaining > 0 {
+				slog.Debug("bot: Group is on cooldown, skipping", "chat_id", chatID, "group_id", group.ID, "user_id", user.ID, "remaining", remaining)
+				continue
+			}
+		}
+
+		members, err := b.groupMembers(ctx, []storage.MentionGroup{group})
+		if err != nil {
+			return results, err
+		}
+		// The mention is sent on behalf of the user, so they are left out like in the chat
+		if !mentionsSender {
+			members, _ = withoutUser(members, user.ID)
+		}
+		if len(members) == 0 {
+			continue
+		}
+		mentions := b.formatMentions(members)
+
+		// Only a single message can be sent from an inline result
+		parts := splitMentions(mentions)
+		description := chatTitle
+		if len(parts) > 1 {
+			description += " · " + tr(lang, "too many members for one message, use /mention in the chat")
+		}
+
+		content := tu.TextMessage(parts[0])
+		content.ParseMode = "MarkdownV2"
+		title := tr(lang, "%s (%d members)", group.Name, len(mentions))
+		results = append(results, tu.ResultArticle(inlineResultID(chatID, group.ID), title, content).
+			WithDescription(description))
+	}
+	return results, nil
+}
+
+func groupMatches(group storage.MentionGroup, search string) bool {
+	if strings.Contains(group.Name, search) {
+		return true
+	}
+	for _, alias := range group.Aliases {
+		if strings.Contains(alias.Name, search) {
+			return true
+		}
+	}
+	return false
+}
+
+// isChatMember checks with Telegram if the user is still in the chat, the answer is cached for a while
+func (b *Bot) isChatMember(ctx context.Context, chatID int64, userID int64) (bool, error) {
+	// Groups created in the private chat with the bot
+	if chatID == userID {
+		return true, nil
+	}
+
+	key := chatUserKey{chatID: chatID, userID: userID}
+	if isMember, ok := b.chatMembers.Get(key); ok {
+		return isMember, nil
+	}
+
+	slog.Debug("bot: Fetching chat member", "chat_id", chatID, "user_id", userID)
+	member, err := b.bot.GetChatMember(ctx, &t.GetChatMemberParams{
+		ChatID: tu.ID(chatID),
+		UserID: userID,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	isMember := member.MemberIsMember()
+	b.chatMembers.Set(key, isMember)
+	return isMember, nil
+}
+
+// chatTitle returns the cached chat title, falling back to the chat ID if it can't be fetched
+func (b *Bot) chatTitle(ctx context.Context, chatID int64) string {
+	if title, ok := b.chatTitles.Get(chatID); ok {
+		return title
+	}
+
+	slog.Debug("bot: Fetching chat title", "chat_id", chatID)
+	chat, err := b.bot.GetChat(ctx, &t.GetChatParams{ChatID: tu.ID(chatID)})
+	if err != nil {
+		slog.Error("bot: Failed to get chat", "error", err, "chat_id", chatID)
+		return fmt.Sprintf("Chat %d", chatID)
+	}
+
+	title := chat.Title
+	if title == "" {
+		title = strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+	}
+	b.chatTitles.Set(chatID, title)
+	return title
+}
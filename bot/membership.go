@@ -0,0 +1,297 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Pending confirmations are kept in memory, so they are lost on restart
+const consentRequestTTL = 24 * time.Hour
+
+const consentCallbackPrefix = "consent:"
+
+// Membership changes done on behalf of another user
+const (
+	membershipAdd    = "add"
+	membershipRemove = "remove"
+)
+
+// consentRequest is a membership change waiting for the target user to confirm it
+type consentRequest struct {
+	action      string
+	chatID      int64
+	groupID     uint
+	groupName   string
+	requesterID int64
+	target      storage.User
+}
+
+func (b *Bot) handleAdd(ctx *th.Context, message t.Message) error {
+	return b.handleMembershipChange(ctx, message, membershipAdd)
+}
+
+func (b *Bot) handleRemove(ctx *th.Context, message t.Message) error {
+	return b.handleMembershipChange(ctx, message, membershipRemove)
+}
+
+// handleMembershipChange adds or removes users targeted by the message to or from a group
+func (b *Bot) handleMembershipChange(ctx *th.Context, message t.Message, action string) error {
+	slog.Debug("bot: Handling membership change command", "action", action, "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) < 2 {
+		slog.Debug("bot: Invalid membership change command format", "action", action, "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /%s <group_name> @username...\nOr reply to a message of the user with /%s <group_name>.", action, action)), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	targets, unknown, err := b.resolveTargetUsers(ctx, &message, args[2:])
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to find users: %v", err)), &message)
+		return nil
+	}
+	if len(unknown) > 0 {
+		slog.Debug("bot: Unknown users in membership change", "action", action, "chat_id", message.Chat.ID, "usernames", unknown)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "I haven't seen %s in this chat. They need to write something here first, or reply to their message instead.", strings.Join(unknown, ", "))), &message)
+	}
+	if len(targets) == 0 {
+		if len(unknown) == 0 {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Mention users with @username or reply to their message with /%s <group_name>.", action)), &message)
+		}
+		return nil
+	}
+
+	groupName := args[1]
+	return b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, action, group) {
+			return nil
+		}
+
+		settings, err := b.getChatSettings(ctx, message.Chat.ID)
+		if err != nil {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get chat settings: %v", err)), originalMessage)
+			return nil
+		}
+		bypass := false
+		if settings.AddConsent == addConsentAdmin {
+			actual, err := b.chatRole(ctx, originalMessage)
+			if err != nil {
+				b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to check permissions: %v", err)), originalMessage)
+				return nil
+			}
+			bypass = actual >= roleAdmin
+		}
+
+		for _, target := range targets {
+			request := consentRequest{
+				action:      action,
+				chatID:      message.Chat.ID,
+				groupID:     group.ID,
+				groupName:   group.Name,
+				requesterID: message.From.ID,
+				target:      target,
+			}
+
+			// Changing own membership or being a chat admin in the 'admin' consent mode needs no confirmation
+			if bypass || target.ID == message.From.ID {
+				b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(b.applyMembershipChange(ctx, lang, request)), originalMessage)
+				continue
+			}
+
+			b.requestConsent(ctx, request, message.From, originalMessage)
+		}
+		return nil
+	})
+}
+
+// resolveTargetUsers finds users targeted by the replied message, text mentions and @username arguments.
+// Usernames are looked up only among users seen in this chat or in its groups, so stale usernames from
+// other chats can't target the wrong person. Usernames not found there are returned separately.
+func (b *Bot) resolveTargetUsers(ctx context.Context, message *t.Message, args []string) ([]storage.User, []string, error) {
+	var targets []storage.User
+	seen := make(map[int64]bool)
+	addUser := func(user *t.User) error {
+		if user.IsBot || seen[user.ID] {
+			return nil
+		}
+		// The target may have never written anything, so their data is saved here
+		stored, err := b.storage.CreateOrUpdateUser(ctx, user.ID, user.Username, user.FirstName, user.LastName)
+		if err != nil {
+			return err
+		}
+		seen[user.ID] = true
+		targets = append(targets, *stored)
+		return nil
+	}
+
+	// In forum topics every message replies to the topic creation message unless it's an explicit reply
+	reply := message.ReplyToMessage
+	if reply != nil && reply.From != nil && !(message.IsTopicMessage && reply.MessageID == message.MessageThreadID) {
+		if err := addUser(reply.From); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Users without a username can only be targeted with text mentions
+	for _, entity := range message.Entities {
+		if entity.Type == t.EntityTypeTextMention && entity.User != nil {
+			if err := addUser(entity.User); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var unknown []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			continue
+		}
+		username := strings.TrimRight(strings.TrimPrefix(arg, "@"), ".,")
+		user, err := b.storage.GetChatUserByUsername(ctx, message.Chat.ID, username)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				unknown = append(unknown, "@"+username)
+				continue
+			}
+			return nil, nil, err
+		}
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			targets = append(targets, *user)
+		}
+	}
+
+	slog.Debug("bot: Target users resolved", "chat_id", message.Chat.ID, "target_count", len(targets), "unknown_count", len(unknown))
+	return targets, unknown, nil
+}
+
+// newRequestID returns a random ID of a request waiting for an answer from inline buttons
+func newRequestID() (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+// requestConsent asks the target user to confirm the membership change with inline buttons
+func (b *Bot) requestConsent(ctx context.Context, request consentRequest, requester *t.User, originalMessage *t.Message) {
+	lang := b.replyLanguage(ctx, request.chatID, originalMessage)
+	id, err := newRequestID()
+	if err != nil {
+		slog.Error("bot: Failed to generate consent request ID", "error", err)
+		b.sendMessage(ctx, request.chatID, escapeMarkdownV2(tr(lang, "Failed to request confirmation: %v", err)), originalMessage)
+		return
+	}
+
+	var text string
+	if request.action == membershipAdd {
+		text = tr(lang, "%s wants to add you to group '%s'.", displayName(userFromTelegram(requester)), request.groupName)
+	} else {
+		text = tr(lang, "%s wants to remove you from group '%s'.", displayName(userFromTelegram(requester)), request.groupName)
+	}
+	mention := b.formatMentions([]storage.GroupMember{{UserID: request.target.ID, User: request.target}})[0]
+
+	keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("✅ "+tr(lang, "Accept")).WithCallbackData(consentCallbackPrefix+id+":y"),
+		tu.InlineKeyboardButton("❌ "+tr(lang, "Decline")).WithCallbackData(consentCallbackPrefix+id+":n"),
+	))
+
+	b.consentRequests.Set(id, request)
+	slog.Debug("bot: Requesting consent", "request_id", id, "action", request.action, "chat_id", request.chatID, "group_id", request.groupID, "target_user_id", request.target.ID)
+	params := b.messageParams(request.chatID, mention+", "+escapeMarkdownV2(text), originalMessage, keyboard)
+	b.queueMessage(params, func(_ *t.Message, err error) {
+		// Nobody can answer a request which was never shown
+		if err != nil {
+			b.consentRequests.Delete(id)
+		}
+	})
+}
+
+func (b *Bot) handleConsentCallback(ctx *th.Context, query t.CallbackQuery) error {
+	slog.Debug("bot: Handling consent callback", "from_user_id", query.From.ID, "data", query.Data)
+
+	id, answer, _ := strings.Cut(strings.TrimPrefix(query.Data, consentCallbackPrefix), ":")
+
+	request, ok := b.consentRequests.Get(id)
+	if !ok || query.Message == nil || !query.Message.IsAccessible() {
+		b.answerCallback(ctx, query.ID, tr(userLanguage(&query.From), "This request has expired."))
+		return nil
+	}
+	lang := b.language(ctx, request.chatID, &query.From)
+	if query.From.ID != request.target.ID {
+		b.answerCallback(ctx, query.ID, tr(lang, "Only %s can answer this request.", displayName(request.target)))
+		return nil
+	}
+	b.consentRequests.Delete(id)
+
+	var result string
+	if answer == "y" {
+		result = b.applyMembershipChange(ctx, lang, request)
+	} else {
+		slog.Info("bot: Membership change declined", "action", request.action, "chat_id", request.chatID, "group_id", request.groupID, "user_id", request.target.ID)
+		result = tr(lang, "%s declined the request for group '%s'.", displayName(request.target), request.groupName)
+	}
+
+	b.answerCallback(ctx, query.ID, "")
+	edit := tu.EditMessageText(tu.ID(query.Message.GetChat().ID), query.Message.GetMessageID(), escapeMarkdownV2(result))
+	edit.ParseMode = "MarkdownV2"
+	if _, err := b.bot.EditMessageText(ctx, edit); err != nil {
+		slog.Error("bot: Failed to edit consent request message", "error", err, "chat_id", request.chatID)
+	}
+	return nil
+}
+
+// applyMembershipChange performs the change and describes its result
+func (b *Bot) applyMembershipChange(ctx context.Context, lang string, request consentRequest) string {
+	name := displayName(request.target)
+
+	// The group might have been deleted or recreated while waiting for the confirmation
+	group, err := b.storage.GetGroup(ctx, request.groupName, request.chatID)
+	if err != nil || group.ID != request.groupID {
+		slog.Debug("bot: Group of membership change is gone", "error", err, "chat_id", request.chatID, "group_id", request.groupID)
+		return tr(lang, "Group '%s' doesn't exist anymore.", request.groupName)
+	}
+
+	isMember, err := b.storage.IsMember(ctx, group.ID, request.target.ID)
+	if err != nil {
+		return tr(lang, "Failed to check membership: %v", err)
+	}
+
+	switch request.action {
+	case membershipAdd:
+		if isMember {
+			return tr(lang, "%s is already a member of group '%s'.", name, group.Name)
+		}
+		if err := b.storage.AddMember(ctx, group.ID, &request.target); err != nil {
+			slog.Error("bot: Failed to add user to group", "error", err, "group_name", group.Name, "chat_id", request.chatID, "user_id", request.target.ID)
+			return tr(lang, "Failed to add %s: %v", name, err)
+		}
+		slog.Info("bot: User added to group", "group_name", group.Name, "chat_id", request.chatID, "user_id", request.target.ID, "requester_id", request.requesterID)
+		return tr(lang, "%s has been added to group '%s'.", name, group.Name)
+	default:
+		if !isMember {
+			return tr(lang, "%s is not a member of group '%s'.", name, group.Name)
+		}
+		if err := b.storage.RemoveMember(ctx, group.ID, request.target.ID); err != nil {
+			slog.Error("bot: Failed to remove user from group", "error", err, "group_name", group.Name, "chat_id", request.chatID, "user_id", request.target.ID)
+			return tr(lang, "Failed to remove %s: %v", name, err)
+		}
+		slog.Info("bot: User removed from group", "group_name", group.Name, "chat_id", request.chatID, "user_id", request.target.ID, "requester_id", request.requesterID)
+		return tr(lang, "%s has been removed from group '%s'.", name, group.Name)
+	}
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strconv"
+	"time"
+
+	"telegram-group-mention-bot/metrics"
+
+	t "github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// WithMetrics serves Prometheus metrics at /metrics on the local address, e.g. ":9090"
+func WithMetrics(listen string) Option {
+	return func(b *Bot) {
+		b.metricsListen = listen
+	}
+}
+
+// measureUpdate is a middleware that counts updates and measures handling of commands. Commands are
+// labeled by their registry name, so aliases and unknown commands don't add label values.
+func (b *Bot) measureUpdate(commands []botCommand) th.Handler {
+	names := make(map[string]string)
+	for _, command := range commands {
+		names[command.name] = command.name
+		for _, alias := range command.aliases {
+			names[alias] = command.name
+		}
+	}
+
+	return func(ctx *th.Context, update t.Update) error {
+		metrics.Updates.WithLabelValues(updateType(update)).Inc()
+
+		if update.Message == nil {
+			return ctx.Next(update)
+		}
+		name, _, _ := tu.ParseCommand(update.Message.Text)
+		if name == "" {
+			return ctx.Next(update)
+		}
+		command, ok := names[name]
+		if !ok {
+			command = "other"
+		}
+
+		start := time.Now()
+		err := ctx.Next(update)
+		metrics.CommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.Commands.WithLabelValues(command, result).Inc()
+		return err
+	}
+}
+
+// updateType returns the type of the update as named by the Bot API
+func updateType(update t.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.ChosenInlineResult != nil:
+		return "chosen_inline_result"
+	case update.ChatMember != nil:
+		return "chat_member"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	default:
+		return "other"
+	}
+}
+
+// apiCaller counts requests to the Bot API and their errors by method in metrics
+// and records successful polls for the readiness check
+type apiCaller struct {
+	caller ta.Caller
+	health *healthState
+}
+
+func (c apiCaller) Call(ctx context.Context, url string, data *ta.RequestData) (*ta.Response, error) {
+	// The URL ends with the method name, the token before it must not become a label
+	method := path.Base(url)
+	metrics.APIRequests.WithLabelValues(method).Inc()
+
+	response, err := c.caller.Call(ctx, url, data)
+	switch {
+	case err != nil:
+		// Requests canceled on shutdown are not failures of the API
+		if !errors.Is(err, context.Canceled) {
+			metrics.APIErrors.WithLabelValues(method, "network").Inc()
+		}
+	case !response.Ok:
+		code := "unknown"
+		if response.Error != nil {
+			code = strconv.Itoa(response.Error.ErrorCode)
+		}
+		metrics.APIErrors.WithLabelValues(method, code).Inc()
+	case method == "getUpdates":
+		c.health.polled(time.Now())
+	}
+	return response, err
+}
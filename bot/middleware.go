@@ -1,11 +1,12 @@
 package bot
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
+
 	"telegram-group-mention-bot/storage"
 
 	t "github.com/mymmrac/telego"
@@ -25,7 +26,7 @@ func (b *Bot) logUpdate(ctx *th.Context, update t.Update) error {
 		text = msg.Text
 
 		// Determine if we should mask the text based on log level
-		shouldMask := !slog.Default().Enabled(context.Background(), slog.LevelDebug)
+		shouldMask := !slog.Default().Enabled(ctx, slog.LevelDebug)
 
 		// Only log full text for commands or messages with @ mentions
 		if strings.HasPrefix(text, "/") {
@@ -52,6 +53,14 @@ func (b *Bot) logUpdate(ctx *th.Context, update t.Update) error {
 		updateType = "callback_query"
 		cb := update.CallbackQuery
 		details = fmt.Sprintf("from: %d, data: %q", cb.From.ID, cb.Data)
+	case update.InlineQuery != nil:
+		updateType = "inline_query"
+		query := update.InlineQuery
+		text = query.Query
+		if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+			text = "[redacted]"
+		}
+		details = fmt.Sprintf("from: %d, chat_type: %s, query: %q", query.From.ID, query.ChatType, text)
 	}
 
 	slog.Info("bot:middleware: Incoming update", "type", updateType, "update_id", update.UpdateID, "details", details)
@@ -75,7 +84,7 @@ func (b *Bot) syncUserData(ctx *th.Context, update t.Update) error {
 	slog.Debug("bot:middleware: Updating user data", "user_id", from.ID, "username", from.Username, "first_name", from.FirstName, "last_name", from.LastName, "chat_id", msg.Chat.ID, "chat_type", msg.Chat.Type)
 
 	// Update user data in the database
-	_, err := b.storage.CreateOrUpdateUser(
+	_, err := b.storage.CreateOrUpdateUser(ctx,
 		from.ID,
 		from.Username,
 		from.FirstName,
@@ -91,6 +100,36 @@ func (b *Bot) syncUserData(ctx *th.Context, update t.Update) error {
 	return ctx.Next(update)
 }
 
+// trackChatUsers is a middleware that remembers in which group chats users were seen, so they can manage
+// their groups there from the private chat. The time is saved at most once per chatUserSeenInterval.
+func (b *Bot) trackChatUsers(ctx *th.Context, update t.Update) error {
+	if update.Message == nil || update.Message.From == nil {
+		return ctx.Next(update)
+	}
+
+	msg := update.Message
+	if msg.Chat.Type != t.ChatTypeGroup && msg.Chat.Type != t.ChatTypeSupergroup {
+		return ctx.Next(update)
+	}
+	if leftChat(msg) {
+		// The user is forgotten by handleLeftChatMember
+		return ctx.Next(update)
+	}
+
+	key := chatUserKey{chatID: msg.Chat.ID, userID: msg.From.ID}
+	if _, ok := b.seenChatUsers.Get(key); ok {
+		return ctx.Next(update)
+	}
+
+	if err := b.storage.SaveChatUser(ctx, key.chatID, key.userID, time.Now()); err != nil {
+		slog.Error("bot:middleware: Failed to save chat user", "error", err, "chat_id", key.chatID, "user_id", key.userID)
+	} else {
+		b.seenChatUsers.Set(key, true)
+	}
+
+	return ctx.Next(update)
+}
+
 func (b *Bot) migrateChat(ctx *th.Context, update t.Update) error {
 	if update.Message == nil {
 		return ctx.Next(update)
@@ -103,12 +142,16 @@ func (b *Bot) migrateChat(ctx *th.Context, update t.Update) error {
 
 	slog.Info("bot:middleware: Chat migration detected", "from_chat_id", msg.MigrateFromChatID, "to_chat_id", msg.MigrateToChatID)
 
-	err := b.storage.MigrateChatGroups(msg.MigrateFromChatID, msg.MigrateToChatID)
+	err := b.storage.MigrateChatGroups(ctx, msg.MigrateFromChatID, msg.MigrateToChatID)
 	if err != nil {
 		slog.Error("bot:middleware: Failed to migrate chat groups", "error", err, "from_chat_id", msg.MigrateFromChatID, "to_chat_id", msg.MigrateToChatID)
 		return err
 	}
 
+	// Settings moved to the new chat ID together with the groups
+	b.settingsCache.Delete(msg.MigrateFromChatID)
+	b.settingsCache.Delete(msg.MigrateToChatID)
+
 	slog.Info("bot:middleware: Chat groups migrated", "from_chat_id", msg.MigrateFromChatID, "to_chat_id", msg.MigrateToChatID)
 
 	return ctx.Next(update)
@@ -128,10 +171,20 @@ func (b *Bot) addToAllGroup(ctx *th.Context, update t.Update) error {
 		return ctx.Next(update)
 	}
 
+	// Users leaving the chat are removed from its groups, so they must not be added back
+	if leftChat(msg) {
+		return ctx.Next(update)
+	}
+
+	if !b.settingEnabled(ctx, msg.Chat.ID, settingAutoAll) {
+		slog.Debug("bot:middleware: Automatic 'all' group enrollment is disabled", "chat_id", msg.Chat.ID)
+		return ctx.Next(update)
+	}
+
 	slog.Debug("bot:middleware: Checking for 'all' group", "chat_id", msg.Chat.ID, "user_id", from.ID)
 
 	// Check if "all" group exists in this chat
-	allGroup, err := b.storage.GetGroup(groupNameAll, msg.Chat.ID)
+	allGroup, err := b.storage.GetGroup(ctx, groupNameAll, msg.Chat.ID)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			slog.Debug("bot:middleware: 'all' group not found", "chat_id", msg.Chat.ID)
@@ -143,7 +196,7 @@ func (b *Bot) addToAllGroup(ctx *th.Context, update t.Update) error {
 	}
 
 	// Check if user is already a member of the "all" group
-	isMember, err := b.storage.IsMember(allGroup.ID, from.ID)
+	isMember, err := b.storage.IsMember(ctx, allGroup.ID, from.ID)
 	if err != nil {
 		slog.Error("bot:middleware: Failed to check membership in 'all' group", "error", err, "group_id", allGroup.ID, "user_id", from.ID)
 		return ctx.Next(update)
@@ -162,7 +215,7 @@ func (b *Bot) addToAllGroup(ctx *th.Context, update t.Update) error {
 	}
 
 	// Add user to the "all" group
-	err = b.storage.AddMember(allGroup.ID, newMinimalMember)
+	err = b.storage.AddMember(ctx, allGroup.ID, newMinimalMember)
 	if err != nil {
 		slog.Error("bot:middleware: Failed to add user to 'all' group", "error", err, "group_id", allGroup.ID, "user_id", from.ID)
 	} else {
@@ -171,3 +224,8 @@ func (b *Bot) addToAllGroup(ctx *th.Context, update t.Update) error {
 
 	return ctx.Next(update)
 }
+
+// leftChat tells if the message is the notice about its sender leaving the chat
+func leftChat(msg *t.Message) bool {
+	return msg.LeftChatMember != nil && msg.From != nil && msg.LeftChatMember.ID == msg.From.ID
+}
@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const chatAdminsCacheTTL = 5 * time.Minute
+
+// role is a level of access to bot commands, higher roles include all lower ones
+type role int
+
+const (
+	roleEveryone role = iota
+	roleMember
+	roleAdmin
+	roleCreator
+)
+
+var roleNames = map[role]string{
+	roleEveryone: "everyone",
+	roleMember:   "member",
+	roleAdmin:    "admin",
+	roleCreator:  "creator",
+}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (role, bool) {
+	for r, roleName := range roleNames {
+		if roleName == name {
+			return r, true
+		}
+	}
+	return roleEveryone, false
+}
+
+// policyCommands lists commands which can be restricted by the chat policy in display order
+var policyCommands = []string{"new", "join", "leave", "add", "remove", "mention", "show", "del", "alias", "unalias", "include", "exclude", "remind", "unremind"}
+
+// policyCommandAliases maps command aliases to the command name used in the policy
+var policyCommandAliases = map[string]string{
+	"m":    "mention",
+	"call": "mention",
+}
+
+// grouplessCommands don't operate on an existing group, so the "member" role makes no sense for them
+var grouplessCommands = map[string]bool{
+	"new": true,
+}
+
+func normalizePolicyCommand(command string) (string, bool) {
+	command = strings.ToLower(strings.TrimPrefix(command, "/"))
+	if alias, ok := policyCommandAliases[command]; ok {
+		command = alias
+	}
+	for _, policyCommand := range policyCommands {
+		if policyCommand == command {
+			return command, true
+		}
+	}
+	return "", false
+}
+
+// requiredRole returns the role the chat policy requires for a command
+func (b *Bot) requiredRole(ctx context.Context, chatID int64, command string) (role, error) {
+	permissions, err := b.storage.GetCommandPermissions(ctx, chatID)
+	if err != nil {
+		return roleEveryone, err
+	}
+
+	for _, permission := range permissions {
+		if permission.Command != command {
+			continue
+		}
+		r, ok := parseRole(permission.Role)
+		if !ok {
+			slog.Warn("bot:permissions: Unknown role in chat policy", "chat_id", chatID, "command", command, "role", permission.Role)
+			return roleEveryone, nil
+		}
+		return r, nil
+	}
+
+	return roleEveryone, nil
+}
+
+// chatRole resolves the chat-level role of the message sender using the cached list of chat administrators
+func (b *Bot) chatRole(ctx context.Context, message *t.Message) (role, error) {
+	if message.Chat.Type == t.ChatTypePrivate {
+		return roleCreator, nil
+	}
+
+	// Anonymous administrators send messages on behalf of the chat itself
+	if message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID {
+		return roleAdmin, nil
+	}
+
+	if message.From == nil {
+		return roleEveryone, nil
+	}
+
+	admins, ok := b.chatAdmins.Get(message.Chat.ID)
+	if !ok {
+		slog.Debug("bot:permissions: Fetching chat administrators", "chat_id", message.Chat.ID)
+		members, err := b.bot.GetChatAdministrators(ctx, &t.GetChatAdministratorsParams{
+			ChatID: tu.ID(message.Chat.ID),
+		})
+		if err != nil {
+			slog.Error("bot:permissions: Failed to get chat administrators", "error", err, "chat_id", message.Chat.ID)
+			return roleEveryone, err
+		}
+
+		admins = make(map[int64]string, len(members))
+		for _, member := range members {
+			admins[member.MemberUser().ID] = member.MemberStatus()
+		}
+		b.chatAdmins.Set(message.Chat.ID, admins)
+	}
+
+	switch admins[message.From.ID] {
+	case t.MemberStatusCreator:
+		return roleCreator, nil
+	case t.MemberStatusAdministrator:
+		return roleAdmin, nil
+	default:
+		return roleEveryone, nil
+	}
+}
+
+// hasPermission checks if the message sender is allowed to run a command on a group (which may be nil)
+func (b *Bot) hasPermission(ctx context.Context, message *t.Message, command string, group *storage.MentionGroup) (bool, role, error) {
+	required, err := b.requiredRole(ctx, message.Chat.ID, command)
+	if err != nil {
+		return false, required, err
+	}
+	if required == roleEveryone {
+		return true, required, nil
+	}
+
+	actual, err := b.chatRole(ctx, message)
+	if err != nil {
+		return false, required, err
+	}
+
+	if actual < roleMember && group != nil && message.From != nil {
+		isMember, err := b.storage.IsMember(ctx, group.ID, message.From.ID)
+		if err != nil {
+			return false, required, err
+		}
+		if isMember {
+			actual = roleMember
+		}
+	}
+
+	slog.Debug("bot:permissions: Permission check", "chat_id", message.Chat.ID, "command", command, "required_role", required, "actual_role", actual)
+	return actual >= required, required, nil
+}
+
+// checkPermission is like hasPermission, but also tells the sender why the command was rejected
+func (b *Bot) checkPermission(ctx context.Context, message *t.Message, command string, group *storage.MentionGroup) bool {
+	allowed, required, err := b.hasPermission(ctx, message, command, group)
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	if err != nil {
+		slog.Error("bot:permissions: Failed to check permission", "error", err, "chat_id", message.Chat.ID, "command", command)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to check permissions: %v", err)), message)
+		return false
+	}
+	if allowed {
+		return true
+	}
+
+	slog.Debug("bot:permissions: Permission denied", "chat_id", message.Chat.ID, "command", command, "required_role", required)
+	var text string
+	switch required {
+	case roleMember:
+		text = tr(lang, "Only members of group '%s' and chat admins can use /%s here.", group.Name, command)
+	case roleAdmin:
+		text = tr(lang, "Only chat admins can use /%s here.", command)
+	default:
+		text = tr(lang, "Only the chat creator can use /%s here.", command)
+	}
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(text), message)
+	return false
+}
+
+func (b *Bot) handlePolicy(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling policy command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	if len(args) == 1 {
+		var lines []string
+		for _, command := range policyCommands {
+			r, err := b.requiredRole(ctx, message.Chat.ID, command)
+			if err != nil {
+				slog.Error("bot: Failed to get chat policy", "error", err, "chat_id", message.Chat.ID)
+				b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get command policy: %v", err)), &message)
+				return nil
+			}
+			lines = append(lines, escapeMarkdownV2(fmt.Sprintf("• /%s - %s", command, r)))
+		}
+
+		header := escapeMarkdownV2(tr(lang, "Roles required for commands in this chat:")) + "\n"
+		b.sendMessage(ctx, message.Chat.ID, header+strings.Join(lines, "\n"), &message)
+		return nil
+	}
+
+	if len(args) != 3 {
+		slog.Debug("bot: Invalid policy command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /policy <command> <role>\nRoles: everyone, member, admin, creator.")), &message)
+		return nil
+	}
+
+	actual, err := b.chatRole(ctx, &message)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to check permissions: %v", err)), &message)
+		return nil
+	}
+	if actual < roleAdmin {
+		slog.Debug("bot: Non-admin tried to change policy", "chat_id", message.Chat.ID, "user_id", message.From.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Only chat admins can change the command policy.")), &message)
+		return nil
+	}
+
+	command, ok := normalizePolicyCommand(args[1])
+	if !ok {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Unknown command '%s'. Available: %s.", args[1], strings.Join(policyCommands, ", "))), &message)
+		return nil
+	}
+
+	required, ok := parseRole(strings.ToLower(args[2]))
+	if !ok {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Unknown role '%s'. Available: everyone, member, admin, creator.", args[2])), &message)
+		return nil
+	}
+	if required == roleMember && grouplessCommands[command] {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Role 'member' needs a target group and can't be used for /%s.", command)), &message)
+		return nil
+	}
+
+	if err := b.storage.SetCommandPermission(ctx, message.Chat.ID, command, required.String()); err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to update command policy: %v", err)), &message)
+		return nil
+	}
+
+	slog.Info("bot: Chat policy updated", "chat_id", message.Chat.ID, "command", command, "role", required)
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Command /%s now requires role '%s'.", command, required)), &message)
+	return nil
+}
@@ -0,0 +1,391 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"telegram-group-mention-bot/metrics"
+
+	t "github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+)
+
+// Telegram allows bots about 30 messages per second overall, 20 messages per minute in a group
+// and about one message per second in a private chat
+const (
+	globalSendLimit  = 30
+	globalSendPeriod = time.Second
+	groupSendLimit   = 20
+	groupSendPeriod  = time.Minute
+	privateSendLimit = 3
+	// privateSendPeriod allows short bursts like a reply followed by mentions
+	privateSendPeriod = 3 * time.Second
+
+	sendQueueSize = 1000
+
+	maxSendAttempts = 5
+	// Failures without retry_after are retried after 1s, 2s, 4s and so on
+	initialSendRetryDelay = time.Second
+	maxSendRetryDelay     = 30 * time.Second
+)
+
+var (
+	errSendQueueFull   = errors.New("outbound message queue is full")
+	errSendQueueClosed = errors.New("outbound message queue is closed")
+)
+
+// tokenBucket allows up to capacity events at once, refilling at the rate of capacity per period
+type tokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	updated  time.Time
+	// blockedUntil is set when Telegram asks to wait with retry_after or a retry is delayed
+	blockedUntil time.Time
+}
+
+func newTokenBucket(capacity int, period time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		rate:     float64(capacity) / period.Seconds(),
+		tokens:   float64(capacity),
+		updated:  now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.updated) {
+		b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+		b.updated = now
+	}
+}
+
+// readyAt returns the time when a token is available
+func (b *tokenBucket) readyAt(now time.Time) time.Time {
+	b.refill(now)
+	ready := now
+	if b.tokens < 1 {
+		ready = now.Add(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+	}
+	if b.blockedUntil.After(ready) {
+		ready = b.blockedUntil
+	}
+	return ready
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+// idle tells if the bucket is full, so forgetting it changes nothing
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.capacity && !b.blockedUntil.After(now)
+}
+
+type (
+	sendFunc         func(ctx context.Context, params *t.SendMessageParams) (*t.Message, error)
+	sendDocumentFunc func(ctx context.Context, params *t.SendDocumentParams) (*t.Message, error)
+)
+
+// outboundMessage is a message waiting in the queue. Documents count against the same limits as text messages.
+type outboundMessage struct {
+	chatID int64
+	params *t.SendMessageParams
+	// document builds the request instead of params for every attempt, as the file of a request is read once
+	document func() *t.SendDocumentParams
+	done     func(sent *t.Message, err error)
+	attempts int
+}
+
+type sendResult struct {
+	chatID  int64
+	message *outboundMessage
+	sent    *t.Message
+	err     error
+}
+
+// chatQueue keeps messages of a single chat in order, only one of them is sent at a time
+type chatQueue struct {
+	messages []*outboundMessage
+	bucket   *tokenBucket
+	sending  bool
+}
+
+// sendQueue sends messages in the background respecting Telegram rate limits. Messages of a chat are sent
+// in the order they were queued, chats waiting for their limits don't delay other chats.
+type sendQueue struct {
+	send         sendFunc
+	sendDocument sendDocumentFunc
+
+	mu       sync.Mutex
+	closed   bool
+	incoming chan *outboundMessage
+	closing  chan struct{}
+	stopped  chan struct{}
+
+	// Fields below are owned by the run goroutine
+	results chan sendResult
+	global  *tokenBucket
+	chats   map[int64]*chatQueue
+}
+
+func newSendQueue(send sendFunc, sendDocument sendDocumentFunc) *sendQueue {
+	return &sendQueue{
+		send:         send,
+		sendDocument: sendDocument,
+		incoming:     make(chan *outboundMessage, sendQueueSize),
+		closing:      make(chan struct{}),
+		stopped:      make(chan struct{}),
+		results:      make(chan sendResult),
+		global:       newTokenBucket(globalSendLimit, globalSendPeriod, time.Now()),
+		chats:        make(map[int64]*chatQueue),
+	}
+}
+
+// enqueue adds the message to the queue without waiting. The done function is called exactly once,
+// with an error if the message couldn't be queued or sent. It's called from the queue goroutine,
+// so it must not block.
+func (q *sendQueue) enqueue(params *t.SendMessageParams, done func(sent *t.Message, err error)) error {
+	return q.push(&outboundMessage{chatID: params.ChatID.ID, params: params, done: done})
+}
+
+// enqueueDocument adds the document to the queue like enqueue, the request is built for every attempt
+func (q *sendQueue) enqueueDocument(chatID int64, document func() *t.SendDocumentParams, done func(sent *t.Message, err error)) error {
+	return q.push(&outboundMessage{chatID: chatID, document: document, done: done})
+}
+
+func (q *sendQueue) push(message *outboundMessage) error {
+	q.mu.Lock()
+	err := errSendQueueClosed
+	if !q.closed {
+		select {
+		case q.incoming <- message:
+			err = nil
+		default:
+			err = errSendQueueFull
+		}
+	}
+	q.mu.Unlock()
+
+	if err != nil {
+		message.done(nil, err)
+	}
+	return err
+}
+
+// close stops accepting messages, the queued ones are still sent
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.closing)
+	}
+}
+
+// wait blocks until all queued messages are handled after close or the context is done
+func (q *sendQueue) wait(ctx context.Context) error {
+	select {
+	case <-q.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends queued messages until the queue is closed and drained. Messages left when the context
+// is canceled are failed with the context error.
+func (q *sendQueue) run(ctx context.Context) {
+	defer close(q.stopped)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	closing := q.closing
+	for {
+		next := q.dispatch(ctx, time.Now())
+
+		if closing == nil && next.IsZero() && !q.sending() {
+			// Closed and nothing is left, late messages are not possible as enqueue checks closed first
+			slog.Debug("bot:queue: Outbound queue drained")
+			return
+		}
+
+		var wake <-chan time.Time
+		if !next.IsZero() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(next))
+			wake = timer.C
+		}
+
+		select {
+		case message := <-q.incoming:
+			q.add(message)
+		case result := <-q.results:
+			q.handleResult(result, time.Now())
+		case <-wake:
+		case <-closing:
+			closing = nil
+			// Messages queued before closing are still in the channel
+			for drained := false; !drained; {
+				select {
+				case message := <-q.incoming:
+					q.add(message)
+				default:
+					drained = true
+				}
+			}
+		case <-ctx.Done():
+			q.fail(ctx.Err())
+			return
+		}
+	}
+}
+
+func (q *sendQueue) add(message *outboundMessage) {
+	chatID := message.chatID
+	chat, ok := q.chats[chatID]
+	if !ok {
+		limit, period := privateSendLimit, privateSendPeriod
+		if chatID < 0 {
+			limit, period = groupSendLimit, groupSendPeriod
+		}
+		chat = &chatQueue{bucket: newTokenBucket(limit, period, time.Now())}
+		q.chats[chatID] = chat
+	}
+	chat.messages = append(chat.messages, message)
+}
+
+// dispatch starts sending messages of all chats within the limits. It returns when the next message
+// can be sent or zero time if nothing is waiting.
+func (q *sendQueue) dispatch(ctx context.Context, now time.Time) time.Time {
+	var next time.Time
+	for chatID, chat := range q.chats {
+		if chat.sending {
+			continue
+		}
+		if len(chat.messages) == 0 {
+			if chat.bucket.idle(now) {
+				delete(q.chats, chatID)
+			}
+			continue
+		}
+
+		ready := chat.bucket.readyAt(now)
+		if globalReady := q.global.readyAt(now); globalReady.After(ready) {
+			ready = globalReady
+		}
+		if ready.After(now) {
+			if next.IsZero() || ready.Before(next) {
+				next = ready
+			}
+			continue
+		}
+
+		chat.bucket.take(now)
+		q.global.take(now)
+		chat.sending = true
+		message := chat.messages[0]
+		message.attempts++
+		go func() {
+			sent, err := q.deliver(ctx, message)
+			select {
+			case q.results <- sendResult{chatID: chatID, message: message, sent: sent, err: err}:
+			case <-ctx.Done():
+				// The queue has stopped, so nobody is going to handle the result
+				message.done(sent, err)
+			}
+		}()
+	}
+	return next
+}
+
+func (q *sendQueue) deliver(ctx context.Context, message *outboundMessage) (*t.Message, error) {
+	if message.document != nil {
+		return q.sendDocument(ctx, message.document())
+	}
+	return q.send(ctx, message.params)
+}
+
+func (q *sendQueue) sending() bool {
+	for _, chat := range q.chats {
+		if chat.sending {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *sendQueue) handleResult(result sendResult, now time.Time) {
+	chat := q.chats[result.chatID]
+	chat.sending = false
+
+	if result.err != nil && result.message.attempts < maxSendAttempts {
+		if delay, ok := retryDelay(result.err, result.message.attempts); ok {
+			slog.Warn("bot:queue: Failed to send message, retrying", "error", result.err, "chat_id", result.chatID, "attempt", result.message.attempts, "delay", delay)
+			chat.bucket.blockedUntil = now.Add(delay)
+			return
+		}
+	}
+
+	chat.messages = chat.messages[1:]
+	result.message.done(result.sent, result.err)
+}
+
+// fail gives up on all queued messages
+func (q *sendQueue) fail(err error) {
+	for chatID, chat := range q.chats {
+		messages := chat.messages
+		if chat.sending {
+			// The message being sent is reported by its goroutine
+			messages = messages[1:]
+		}
+		for _, message := range messages {
+			message.done(nil, err)
+		}
+		delete(q.chats, chatID)
+	}
+	for {
+		select {
+		case message := <-q.incoming:
+			message.done(nil, err)
+		default:
+			return
+		}
+	}
+}
+
+// retryDelay tells if a failed request should be retried and when
+func retryDelay(err error, attempts int) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	var apiErr *ta.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Parameters != nil && apiErr.Parameters.RetryAfter > 0 {
+			metrics.RateLimitWaits.Inc()
+			metrics.RateLimitWaitSeconds.Add(float64(apiErr.Parameters.RetryAfter))
+			return time.Duration(apiErr.Parameters.RetryAfter) * time.Second, true
+		}
+		// Other client errors like a deleted chat or a malformed message won't go away by themselves
+		if apiErr.ErrorCode != 429 && apiErr.ErrorCode < 500 {
+			return 0, false
+		}
+	}
+
+	// Network and server errors are retried with exponential backoff
+	delay := initialSendRetryDelay << (attempts - 1)
+	return min(delay, maxSendRetryDelay), true
+}
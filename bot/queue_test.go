@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// fakeSender records attempts to send messages and fails them with the errors scripted for their texts
+type fakeSender struct {
+	mu       sync.Mutex
+	failures map[string][]error
+	attempts []string
+}
+
+func newFakeSender(failures map[string][]error) *fakeSender {
+	return &fakeSender{failures: failures}
+}
+
+func (s *fakeSender) send(_ context.Context, params *telego.SendMessageParams) (*telego.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts = append(s.attempts, params.Text)
+	if errs := s.failures[params.Text]; len(errs) > 0 {
+		s.failures[params.Text] = errs[1:]
+		return nil, errs[0]
+	}
+	return &telego.Message{Text: params.Text}, nil
+}
+
+func (s *fakeSender) sendDocument(context.Context, *telego.SendDocumentParams) (*telego.Message, error) {
+	return &telego.Message{}, nil
+}
+
+// startQueue runs a queue with the fake sender until the test ends
+func startQueue(t *testing.T, sender *fakeSender) *sendQueue {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := newSendQueue(sender.send, sender.sendDocument)
+	go queue.run(ctx)
+	t.Cleanup(func() {
+		queue.close()
+		cancel()
+		_ = queue.wait(context.Background())
+	})
+	return queue
+}
+
+type sendOutcome struct {
+	text string
+	err  error
+	at   time.Time
+}
+
+// enqueueTexts queues the messages to the chat and returns a channel with their outcomes
+func enqueueTexts(t *testing.T, queue *sendQueue, chatID int64, texts ...string) <-chan sendOutcome {
+	t.Helper()
+	outcomes := make(chan sendOutcome, len(texts))
+	for _, text := range texts {
+		err := queue.enqueue(tu.Message(tu.ID(chatID), text), func(_ *telego.Message, err error) {
+			outcomes <- sendOutcome{text: text, err: err, at: time.Now()}
+		})
+		if err != nil {
+			t.Fatalf("enqueue(%q) error: %v", text, err)
+		}
+	}
+	return outcomes
+}
+
+func waitOutcome(t *testing.T, outcomes <-chan sendOutcome) sendOutcome {
+	t.Helper()
+	select {
+	case outcome := <-outcomes:
+		return outcome
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message to be sent")
+		return sendOutcome{}
+	}
+}
+
+func TestSendQueueKeepsOrderAcrossRetry(t *testing.T) {
+	sender := newFakeSender(map[string][]error{
+		"first": {&ta.Error{ErrorCode: 502, Description: "Bad Gateway"}},
+	})
+	queue := startQueue(t, sender)
+
+	outcomes := enqueueTexts(t, queue, 1, "first", "second", "third")
+	for _, want := range []string{"first", "second", "third"} {
+		outcome := waitOutcome(t, outcomes)
+		if outcome.text != want || outcome.err != nil {
+			t.Fatalf("got %q with error %v, want %q sent", outcome.text, outcome.err, want)
+		}
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if want := []string{"first", "first", "second", "third"}; !slices.Equal(sender.attempts, want) {
+		t.Errorf("attempts = %q, want %q", sender.attempts, want)
+	}
+}
+
+func TestSendQueueRetryAfterBlocksOnlyItsChat(t *testing.T) {
+	sender := newFakeSender(map[string][]error{
+		"limited": {&ta.Error{ErrorCode: 429, Description: "Too Many Requests", Parameters: &ta.ResponseParameters{RetryAfter: 1}}},
+	})
+	queue := startQueue(t, sender)
+
+	start := time.Now()
+	limited := enqueueTexts(t, queue, -100, "limited", "limited later")
+	// Let the first attempt fail before the other chat sends
+	time.Sleep(100 * time.Millisecond)
+	other := enqueueTexts(t, queue, -200, "other")
+
+	if outcome := waitOutcome(t, other); outcome.err != nil || outcome.at.Sub(start) >= time.Second {
+		t.Errorf("other chat: error %v after %v, want it sent without waiting", outcome.err, outcome.at.Sub(start))
+	}
+	for _, want := range []string{"limited", "limited later"} {
+		outcome := waitOutcome(t, limited)
+		if outcome.text != want || outcome.err != nil {
+			t.Fatalf("got %q with error %v, want %q sent", outcome.text, outcome.err, want)
+		}
+		if elapsed := outcome.at.Sub(start); elapsed < time.Second {
+			t.Errorf("%q sent after %v, want at least retry_after", want, elapsed)
+		}
+	}
+}
+
+func TestSendQueueDoesNotRetryClientErrors(t *testing.T) {
+	badRequest := &ta.Error{ErrorCode: 400, Description: "Bad Request: can't parse entities"}
+	sender := newFakeSender(map[string][]error{
+		"malformed": {badRequest},
+	})
+	queue := startQueue(t, sender)
+
+	outcomes := enqueueTexts(t, queue, 1, "malformed", "next")
+	if outcome := waitOutcome(t, outcomes); outcome.text != "malformed" || !errors.Is(outcome.err, badRequest) {
+		t.Fatalf("got %q with error %v, want %q failed with %v", outcome.text, outcome.err, "malformed", badRequest)
+	}
+	if outcome := waitOutcome(t, outcomes); outcome.text != "next" || outcome.err != nil {
+		t.Fatalf("got %q with error %v, want %q sent", outcome.text, outcome.err, "next")
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if want := []string{"malformed", "next"}; !slices.Equal(sender.attempts, want) {
+		t.Errorf("attempts = %q, want %q", sender.attempts, want)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+		want     time.Duration
+		retry    bool
+	}{
+		{"retry after", &ta.Error{ErrorCode: 429, Parameters: &ta.ResponseParameters{RetryAfter: 7}}, 1, 7 * time.Second, true},
+		{"too many requests without retry after", &ta.Error{ErrorCode: 429}, 2, 2 * time.Second, true},
+		{"bad request", &ta.Error{ErrorCode: 400}, 1, 0, false},
+		{"forbidden", &ta.Error{ErrorCode: 403}, 1, 0, false},
+		{"server error", &ta.Error{ErrorCode: 500}, 1, time.Second, true},
+		{"network error", errors.New("connection reset"), 3, 4 * time.Second, true},
+		{"capped backoff", errors.New("connection reset"), 10, maxSendRetryDelay, true},
+		{"canceled", context.Canceled, 1, 0, false},
+	}
+	for _, tt := range tests {
+		delay, retry := retryDelay(tt.err, tt.attempts)
+		if delay != tt.want || retry != tt.retry {
+			t.Errorf("%s: retryDelay = %v, %t, want %v, %t", tt.name, delay, retry, tt.want, tt.retry)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2025, time.June, 4, 12, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(groupSendLimit, groupSendPeriod, now)
+
+	for range groupSendLimit {
+		if ready := bucket.readyAt(now); !ready.Equal(now) {
+			t.Fatalf("readyAt = %v with tokens left, want now", ready)
+		}
+		bucket.take(now)
+	}
+	// A token comes back every 3 seconds
+	if ready, want := bucket.readyAt(now), now.Add(3*time.Second); !ready.Equal(want) {
+		t.Errorf("readyAt = %v when empty, want %v", ready, want)
+	}
+	if bucket.idle(now.Add(time.Second)) {
+		t.Error("bucket is idle before it refilled")
+	}
+	if !bucket.idle(now.Add(groupSendPeriod + time.Second)) {
+		t.Error("bucket is not idle after it refilled")
+	}
+
+	bucket.blockedUntil = now.Add(2 * groupSendPeriod)
+	if ready := bucket.readyAt(now.Add(groupSendPeriod)); !ready.Equal(bucket.blockedUntil) {
+		t.Errorf("readyAt = %v while blocked, want %v", ready, bucket.blockedUntil)
+	}
+}
@@ -0,0 +1,315 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	maxRemindersPerChat = 50
+
+	schedulerInterval = 30 * time.Second
+	// Reminders missed for longer, e.g. while the bot was down, are skipped instead of being sent late
+	reminderMaxDelay = time.Hour
+
+	reminderTimeLayout = "Mon 2006-01-02 15:04 MST"
+)
+
+func (b *Bot) handleRemind(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling remind command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) < 3 {
+		slog.Debug("bot: Invalid remind command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /remind <group_name> <when> [text]\n"+
+			"When: in 2h, 10:00, fri 10:00, tomorrow 10:00, 2025-12-31 10:00, every weekday 09:55, every mon,thu 18:00")), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	settings, err := b.getChatSettings(ctx, message.Chat.ID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get chat settings: %v", err)), &message)
+		return nil
+	}
+	loc := chatLocation(settings)
+
+	runAt, s, consumed, err := parseWhen(args[2:], time.Now(), loc)
+	if err != nil {
+		slog.Debug("bot: Invalid reminder time", "error", err, "args", args[2:])
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid time: %s.", tr(lang, err.Error()))), &message)
+		return nil
+	}
+	text := strings.Join(args[2+consumed:], " ")
+
+	groupName := args[1]
+	return b.executeOnGroup(ctx, message.Chat.ID, groupName, &message, func(group *storage.MentionGroup, originalMessage *t.Message) error {
+		if !b.checkPermission(ctx, originalMessage, "remind", group) {
+			return nil
+		}
+
+		existing, err := b.storage.GetRemindersByChat(ctx, message.Chat.ID)
+		if err != nil {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get reminders: %v", err)), originalMessage)
+			return nil
+		}
+		if len(existing) >= maxRemindersPerChat {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "This chat already has %d reminders. Remove some with /unremind <id> first.", len(existing))), originalMessage)
+			return nil
+		}
+
+		reminder := &storage.Reminder{
+			ChatID:    message.Chat.ID,
+			GroupID:   group.ID,
+			CreatorID: message.From.ID,
+			Text:      text,
+			NextRunAt: runAt,
+		}
+		if message.IsTopicMessage {
+			reminder.ThreadID = message.MessageThreadID
+		}
+		if s != nil {
+			reminder.Schedule = s.String()
+		}
+		if err := b.storage.CreateReminder(ctx, reminder); err != nil {
+			slog.Error("bot: Failed to create reminder", "error", err, "group_name", group.Name, "chat_id", message.Chat.ID)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to create reminder: %v", err)), originalMessage)
+			return nil
+		}
+
+		slog.Info("bot: Reminder created", "reminder_id", reminder.ID, "group_name", group.Name, "chat_id", message.Chat.ID, "schedule", reminder.Schedule, "next_run_at", reminder.NextRunAt)
+		when := runAt.In(loc).Format(reminderTimeLayout)
+		if s != nil {
+			when = tr(lang, "%s, next on %s", s, when)
+		}
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Reminder #%d for group '%s' is set: %s.\nCancel it with /unremind %d.", reminder.ID, group.Name, when, reminder.ID)), originalMessage)
+		return nil
+	})
+}
+
+func (b *Bot) handleReminders(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling reminders command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	reminders, err := b.storage.GetRemindersByChat(ctx, message.Chat.ID)
+	if err != nil {
+		slog.Error("bot: Failed to get reminders", "error", err, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get reminders: %v", err)), &message)
+		return nil
+	}
+	if len(reminders) == 0 {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "No reminders in this chat. Create one with /remind <group_name> <when> [text].")), &message)
+		return nil
+	}
+
+	settings, err := b.getChatSettings(ctx, message.Chat.ID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get chat settings: %v", err)), &message)
+		return nil
+	}
+	loc := chatLocation(settings)
+
+	groups, err := b.storage.GetGroupsByChat(ctx, message.Chat.ID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get groups: %v", err)), &message)
+		return nil
+	}
+	groupNames := make(map[uint]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.ID] = group.Name
+	}
+
+	lines := make([]string, 0, len(reminders))
+	for _, reminder := range reminders {
+		line := fmt.Sprintf("#%d %s - ", reminder.ID, groupNames[reminder.GroupID])
+		if reminder.Schedule != "" {
+			line += tr(lang, "%s, next on %s", reminder.Schedule, reminder.NextRunAt.In(loc).Format(reminderTimeLayout))
+		} else {
+			line += reminder.NextRunAt.In(loc).Format(reminderTimeLayout)
+		}
+		if reminder.Text != "" {
+			line += ": " + reminder.Text
+		}
+		lines = append(lines, escapeMarkdownV2(line))
+	}
+
+	slog.Debug("bot: Reminders listed", "chat_id", message.Chat.ID, "reminder_count", len(reminders))
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Reminders in this chat:"))+"\n"+strings.Join(lines, "\n"), &message)
+	return nil
+}
+
+func (b *Bot) handleUnremind(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling unremind command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+	if len(args) != 2 {
+		slog.Debug("bot: Invalid unremind command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /unremind <id>\nSee reminder IDs with /reminders.")), &message)
+		return nil
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[1], "#"), 10, 0)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid reminder ID '%s'.", args[1])), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	reminders, err := b.storage.GetRemindersByChat(ctx, message.Chat.ID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get reminders: %v", err)), &message)
+		return nil
+	}
+	var reminder *storage.Reminder
+	for i := range reminders {
+		if reminders[i].ID == uint(id) {
+			reminder = &reminders[i]
+			break
+		}
+	}
+	if reminder == nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Reminder #%d not found.", id)), &message)
+		return nil
+	}
+
+	// The "member" role is checked against the group the reminder mentions
+	group, err := b.storage.GetGroupByID(ctx, reminder.GroupID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get group: %v", err)), &message)
+		return nil
+	}
+	if !b.checkPermission(ctx, &message, "unremind", group) {
+		return nil
+	}
+
+	if err := b.storage.DeleteReminder(ctx, message.Chat.ID, reminder.ID); err != nil {
+		slog.Error("bot: Failed to delete reminder", "error", err, "reminder_id", reminder.ID, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to delete reminder: %v", err)), &message)
+		return nil
+	}
+
+	slog.Info("bot: Reminder deleted", "reminder_id", reminder.ID, "group_name", group.Name, "chat_id", message.Chat.ID)
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Reminder #%d for group '%s' has been removed.", reminder.ID, group.Name)), &message)
+	return nil
+}
+
+// runScheduler sends due reminders until the context is canceled
+func (b *Bot) runScheduler(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	// Reminders are sent to the end even if the scheduler is being stopped meanwhile
+	sendCtx := context.WithoutCancel(ctx)
+
+	for {
+		b.sendDueReminders(sendCtx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			slog.Debug("bot: Scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bot) sendDueReminders(ctx context.Context, now time.Time) {
+	reminders, err := b.storage.GetDueReminders(ctx, now)
+	if err != nil {
+		slog.Error("bot: Failed to get due reminders", "error", err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		// Reminders are rescheduled before sending, so a failure can't make them fire repeatedly
+		if !b.advanceReminder(ctx, reminder, now) {
+			continue
+		}
+		if now.Sub(reminder.NextRunAt) > reminderMaxDelay {
+			slog.Warn("bot: Skipping overdue reminder", "reminder_id", reminder.ID, "chat_id", reminder.ChatID, "next_run_at", reminder.NextRunAt)
+			continue
+		}
+		b.sendReminder(ctx, reminder)
+	}
+}
+
+// advanceReminder reschedules a recurring reminder or deletes a one-off one. It returns false if that failed.
+func (b *Bot) advanceReminder(ctx context.Context, reminder storage.Reminder, now time.Time) bool {
+	if reminder.Schedule == "" {
+		if err := b.storage.DeleteReminder(ctx, reminder.ChatID, reminder.ID); err != nil {
+			slog.Error("bot: Failed to delete sent reminder", "error", err, "reminder_id", reminder.ID, "chat_id", reminder.ChatID)
+			return false
+		}
+		return true
+	}
+
+	s, err := parseSchedule(reminder.Schedule)
+	if err != nil {
+		slog.Error("bot: Invalid reminder schedule, deleting reminder", "error", err, "reminder_id", reminder.ID, "schedule", reminder.Schedule)
+		if err := b.storage.DeleteReminder(ctx, reminder.ChatID, reminder.ID); err != nil {
+			slog.Error("bot: Failed to delete reminder", "error", err, "reminder_id", reminder.ID, "chat_id", reminder.ChatID)
+		}
+		return false
+	}
+
+	settings, err := b.getChatSettings(ctx, reminder.ChatID)
+	if err != nil {
+		slog.Error("bot: Failed to get chat settings", "error", err, "chat_id", reminder.ChatID)
+		return false
+	}
+
+	nextRunAt := s.next(now, chatLocation(settings))
+	if err := b.storage.UpdateReminderNextRun(ctx, reminder.ID, nextRunAt); err != nil {
+		slog.Error("bot: Failed to reschedule reminder", "error", err, "reminder_id", reminder.ID, "chat_id", reminder.ChatID)
+		return false
+	}
+	slog.Debug("bot: Reminder rescheduled", "reminder_id", reminder.ID, "next_run_at", nextRunAt)
+	return true
+}
+
+// sendReminder posts the reminder text and mentions the group in reply to it
+func (b *Bot) sendReminder(ctx context.Context, reminder storage.Reminder) {
+	slog.Info("bot: Sending reminder", "reminder_id", reminder.ID, "chat_id", reminder.ChatID, "group_id", reminder.GroupID)
+
+	group, err := b.storage.GetGroupByID(ctx, reminder.GroupID)
+	if err != nil {
+		slog.Error("bot: Failed to get reminder group", "error", err, "reminder_id", reminder.ID, "group_id", reminder.GroupID)
+		return
+	}
+	group.Members, err = b.storage.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		slog.Error("bot: Failed to get group members", "error", err, "group_id", group.ID)
+		return
+	}
+	// The reminder isn't sent on behalf of anyone, so only the chat setting picks the language
+	lang := b.language(ctx, reminder.ChatID, nil)
+	text := reminder.Text
+	if text == "" {
+		text = tr(lang, "Reminder for group '%s'", group.Name)
+	}
+	params := tu.Message(tu.ID(reminder.ChatID), escapeMarkdownV2("⏰ "+text))
+	params.ParseMode = "MarkdownV2"
+	params.MessageThreadID = reminder.ThreadID
+
+	// The mentions reply to the reminder once it's sent, nobody is excluded as it has no author
+	if err := b.mentionGroups(ctx, []storage.MentionGroup{*group}, reminder.ChatID, nil, params); err != nil {
+		slog.Error("bot: Failed to mention reminder group", "error", err, "reminder_id", reminder.ID, "group_id", group.ID)
+	}
+}
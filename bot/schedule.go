@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidTime     = errors.New("expected time as HH:MM")
+	errInvalidDays     = errors.New("expected days as day, weekday, weekend or names like mon,wed,fri")
+	errInvalidDuration = errors.New("expected duration like 30m, 2h or 1d")
+	errInvalidWhen     = errors.New("expected 'in 2h', '10:00', 'fri 10:00', 'tomorrow 10:00', '2025-12-31 10:00' or 'every weekday 09:55'")
+	errTimeInPast      = errors.New("the time is in the past")
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// schedule is a recurring time of day on some days of the week
+type schedule struct {
+	days   [7]bool
+	hour   int
+	minute int
+}
+
+// String returns the normalized schedule which can be parsed back with parseSchedule
+func (s schedule) String() string {
+	var days string
+	switch s.days {
+	case [7]bool{true, true, true, true, true, true, true}:
+		days = "day"
+	case [7]bool{false, true, true, true, true, true, false}:
+		days = "weekday"
+	case [7]bool{true, false, false, false, false, false, true}:
+		days = "weekend"
+	default:
+		var names []string
+		// Weeks start on Monday here
+		for _, day := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday} {
+			if s.days[day] {
+				names = append(names, strings.ToLower(day.String()[:3]))
+			}
+		}
+		days = strings.Join(names, ",")
+	}
+	return fmt.Sprintf("every %s %02d:%02d", days, s.hour, s.minute)
+}
+
+// next returns the first occurrence strictly after the given time
+func (s schedule) next(after time.Time, loc *time.Location) time.Time {
+	local := after.In(loc)
+	for i := 0; i <= 7; i++ {
+		day := local.AddDate(0, 0, i)
+		candidate := time.Date(day.Year(), day.Month(), day.Day(), s.hour, s.minute, 0, 0, loc)
+		if s.days[candidate.Weekday()] && candidate.After(after) {
+			return candidate
+		}
+	}
+	// Unreachable for schedules with at least one day
+	return time.Time{}
+}
+
+// parseSchedule parses a schedule formatted by schedule.String
+func parseSchedule(spec string) (schedule, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) != 3 || fields[0] != "every" {
+		return schedule{}, errInvalidWhen
+	}
+	return parseEvery(fields[1], fields[2])
+}
+
+func parseEvery(daysSpec, clock string) (schedule, error) {
+	days, err := parseDays(daysSpec)
+	if err != nil {
+		return schedule{}, err
+	}
+	hour, minute, err := parseClock(clock)
+	if err != nil {
+		return schedule{}, err
+	}
+	return schedule{days: days, hour: hour, minute: minute}, nil
+}
+
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	switch spec {
+	case "day":
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	case "weekday":
+		return [7]bool{false, true, true, true, true, true, false}, nil
+	case "weekend":
+		return [7]bool{true, false, false, false, false, false, true}, nil
+	}
+
+	for _, name := range strings.Split(spec, ",") {
+		day, ok := weekdayNames[name]
+		if !ok {
+			return days, errInvalidDays
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+func parseClock(clock string) (int, int, error) {
+	hourText, minuteText, found := strings.Cut(clock, ":")
+	if !found {
+		return 0, 0, errInvalidTime
+	}
+	hour, err := strconv.Atoi(hourText)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, errInvalidTime
+	}
+	minute, err := strconv.Atoi(minuteText)
+	if err != nil || len(minuteText) != 2 || minute < 0 || minute > 59 {
+		return 0, 0, errInvalidTime
+	}
+	return hour, minute, nil
+}
+
+// parseDuration is time.ParseDuration which also understands whole days like "2d"
+func parseDuration(text string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(text, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errInvalidDuration
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil || duration < time.Minute {
+		return 0, errInvalidDuration
+	}
+	return duration, nil
+}
+
+// parseWhen parses the time at the beginning of args. It returns the first run time, the recurring
+// schedule for repeating reminders and the number of consumed args.
+func parseWhen(args []string, now time.Time, loc *time.Location) (time.Time, *schedule, int, error) {
+	if len(args) == 0 {
+		return time.Time{}, nil, 0, errInvalidWhen
+	}
+	first := strings.ToLower(args[0])
+	second := ""
+	if len(args) > 1 {
+		second = strings.ToLower(args[1])
+	}
+	weekday, isWeekday := weekdayNames[first]
+
+	switch {
+	case first == "every":
+		// "every 09:55" means every day
+		if _, _, err := parseClock(second); err == nil {
+			s, _ := parseEvery("day", second)
+			return s.next(now, loc), &s, 2, nil
+		}
+		if len(args) < 3 {
+			return time.Time{}, nil, 0, errInvalidWhen
+		}
+		s, err := parseEvery(second, strings.ToLower(args[2]))
+		if err != nil {
+			return time.Time{}, nil, 0, err
+		}
+		return s.next(now, loc), &s, 3, nil
+
+	case first == "in":
+		duration, err := parseDuration(second)
+		if err != nil {
+			return time.Time{}, nil, 0, err
+		}
+		return now.Add(duration), nil, 2, nil
+
+	case first == "today" || first == "tomorrow":
+		hour, minute, err := parseClock(second)
+		if err != nil {
+			return time.Time{}, nil, 0, err
+		}
+		day := now.In(loc)
+		if first == "tomorrow" {
+			day = day.AddDate(0, 0, 1)
+		}
+		at := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		if !at.After(now) {
+			return time.Time{}, nil, 0, errTimeInPast
+		}
+		return at, nil, 2, nil
+
+	case isWeekday:
+		hour, minute, err := parseClock(second)
+		if err != nil {
+			return time.Time{}, nil, 0, err
+		}
+		var days [7]bool
+		days[weekday] = true
+		s := schedule{days: days, hour: hour, minute: minute}
+		return s.next(now, loc), nil, 2, nil
+
+	case strings.Count(first, "-") == 2:
+		date, err := time.ParseInLocation("2006-01-02", first, loc)
+		if err != nil {
+			return time.Time{}, nil, 0, errInvalidWhen
+		}
+		hour, minute, err := parseClock(second)
+		if err != nil {
+			return time.Time{}, nil, 0, err
+		}
+		at := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+		if !at.After(now) {
+			return time.Time{}, nil, 0, errTimeInPast
+		}
+		return at, nil, 2, nil
+
+	default:
+		// Just a time means its next occurrence, today or tomorrow
+		hour, minute, err := parseClock(first)
+		if err != nil {
+			return time.Time{}, nil, 0, errInvalidWhen
+		}
+		s, _ := parseEvery("day", fmt.Sprintf("%02d:%02d", hour, minute))
+		return s.next(now, loc), nil, 1, nil
+	}
+}
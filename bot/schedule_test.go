@@ -0,0 +1,197 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseWhen(t *testing.T) {
+	// Wednesday
+	now := time.Date(2025, time.June, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		args     []string
+		want     time.Time
+		schedule string
+		consumed int
+	}{
+		{[]string{"in", "30m", "standup"}, now.Add(30 * time.Minute), "", 2},
+		{[]string{"in", "2h"}, now.Add(2 * time.Hour), "", 2},
+		{[]string{"in", "2d"}, now.Add(48 * time.Hour), "", 2},
+		{[]string{"today", "15:00"}, time.Date(2025, time.June, 4, 15, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"Tomorrow", "09:00"}, time.Date(2025, time.June, 5, 9, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"fri", "10:00"}, time.Date(2025, time.June, 6, 10, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"wednesday", "13:00"}, time.Date(2025, time.June, 4, 13, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"wed", "10:00"}, time.Date(2025, time.June, 11, 10, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"2025-12-31", "10:00"}, time.Date(2025, time.December, 31, 10, 0, 0, 0, time.UTC), "", 2},
+		{[]string{"15:30", "standup"}, time.Date(2025, time.June, 4, 15, 30, 0, 0, time.UTC), "", 1},
+		{[]string{"09:00"}, time.Date(2025, time.June, 5, 9, 0, 0, 0, time.UTC), "", 1},
+		{[]string{"every", "09:55"}, time.Date(2025, time.June, 5, 9, 55, 0, 0, time.UTC), "every day 09:55", 2},
+		{[]string{"every", "weekday", "09:55"}, time.Date(2025, time.June, 5, 9, 55, 0, 0, time.UTC), "every weekday 09:55", 3},
+		{[]string{"every", "weekend", "10:00"}, time.Date(2025, time.June, 7, 10, 0, 0, 0, time.UTC), "every weekend 10:00", 3},
+		{[]string{"every", "mon,wed", "13:00"}, time.Date(2025, time.June, 4, 13, 0, 0, 0, time.UTC), "every mon,wed 13:00", 3},
+		{[]string{"every", "day", "08:00"}, time.Date(2025, time.June, 5, 8, 0, 0, 0, time.UTC), "every day 08:00", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args[0]+" "+tt.args[len(tt.args)-1], func(t *testing.T) {
+			got, s, consumed, err := parseWhen(tt.args, now, time.UTC)
+			if err != nil {
+				t.Fatalf("parseWhen(%q) error: %v", tt.args, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseWhen(%q) = %v, want %v", tt.args, got, tt.want)
+			}
+			if consumed != tt.consumed {
+				t.Errorf("parseWhen(%q) consumed %d args, want %d", tt.args, consumed, tt.consumed)
+			}
+			switch {
+			case tt.schedule == "" && s != nil:
+				t.Errorf("parseWhen(%q) returned schedule %q, want none", tt.args, s)
+			case tt.schedule != "" && s == nil:
+				t.Errorf("parseWhen(%q) returned no schedule, want %q", tt.args, tt.schedule)
+			case s != nil && s.String() != tt.schedule:
+				t.Errorf("parseWhen(%q) returned schedule %q, want %q", tt.args, s, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	now := time.Date(2025, time.June, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		args []string
+		want error
+	}{
+		{nil, errInvalidWhen},
+		{[]string{"soon"}, errInvalidWhen},
+		{[]string{"today", "11:00"}, errTimeInPast},
+		{[]string{"today", "12:00"}, errTimeInPast},
+		{[]string{"2025-01-01", "10:00"}, errTimeInPast},
+		{[]string{"2025-13-01", "10:00"}, errInvalidWhen},
+		{[]string{"in", "30s"}, errInvalidDuration},
+		{[]string{"in", "0d"}, errInvalidDuration},
+		{[]string{"in", "soon"}, errInvalidDuration},
+		{[]string{"tomorrow", "9"}, errInvalidTime},
+		{[]string{"fri", "9:5"}, errInvalidTime},
+		{[]string{"today", "24:00"}, errInvalidTime},
+		{[]string{"every", "weekday"}, errInvalidWhen},
+		{[]string{"every", "someday", "10:00"}, errInvalidDays},
+		{[]string{"every", "mon,xyz", "10:00"}, errInvalidDays},
+		{[]string{"every", "weekday", "25:00"}, errInvalidTime},
+	}
+	for _, tt := range tests {
+		_, _, _, err := parseWhen(tt.args, now, time.UTC)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("parseWhen(%q) error = %v, want %v", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"every day 09:00", "every day 09:00"},
+		{"every weekday 09:55", "every weekday 09:55"},
+		{"every weekend 10:00", "every weekend 10:00"},
+		{"Every mon,wed,fri 08:30", "every mon,wed,fri 08:30"},
+		{"every sun,mon 08:30", "every mon,sun 08:30"},
+		{"every mon,tue,wed,thu,fri 07:00", "every weekday 07:00"},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if s.String() != tt.want {
+			t.Errorf("parseSchedule(%q) = %q, want %q", tt.spec, s, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "day 09:00", "every day", "every day 09:00 extra"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestScheduleNextWeekday(t *testing.T) {
+	s, err := parseSchedule("every weekday 09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2025, time.June, 9, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		after time.Time
+		want  time.Time
+	}{
+		{"friday before", time.Date(2025, time.June, 6, 8, 0, 0, 0, time.UTC), time.Date(2025, time.June, 6, 9, 0, 0, 0, time.UTC)},
+		{"friday at the time", time.Date(2025, time.June, 6, 9, 0, 0, 0, time.UTC), monday},
+		{"friday after", time.Date(2025, time.June, 6, 18, 0, 0, 0, time.UTC), monday},
+		{"saturday", time.Date(2025, time.June, 7, 12, 0, 0, 0, time.UTC), monday},
+		{"sunday late", time.Date(2025, time.June, 8, 23, 59, 0, 0, time.UTC), monday},
+	}
+	for _, tt := range tests {
+		if got := s.next(tt.after, time.UTC); !got.Equal(tt.want) {
+			t.Errorf("%s: next = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := parseSchedule("every day 09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clocks go back from 03:00 CEST to 02:00 CET on October 26, 2025, so that day has 25 hours
+	after := time.Date(2025, time.October, 25, 9, 0, 0, 0, berlin)
+	got := s.next(after, berlin)
+	want := time.Date(2025, time.October, 26, 8, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", after, got, want)
+	}
+	if got.Sub(after) != 25*time.Hour {
+		t.Errorf("next after %v is %v later, want 25h", after, got.Sub(after))
+	}
+
+	// Clocks go forward from 02:00 CET to 03:00 CEST on March 30, 2025, so that day has 23 hours
+	after = time.Date(2025, time.March, 29, 9, 0, 0, 0, berlin)
+	got = s.next(after, berlin)
+	want = time.Date(2025, time.March, 30, 7, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("next after %v = %v, want %v", after, got, want)
+	}
+
+	// 02:30 doesn't exist on March 30, time.Date moves it by an hour, but it must stay on that day
+	s, err = parseSchedule("every day 02:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after = time.Date(2025, time.March, 29, 12, 0, 0, 0, berlin)
+	got = s.next(after, berlin)
+	if local := got.In(berlin); local.Day() != 30 || !got.After(after) {
+		t.Errorf("next after %v = %v, want a time on March 30", after, local)
+	}
+
+	// Relative dates are computed in the chat's time zone too
+	now := time.Date(2025, time.October, 25, 20, 0, 0, 0, berlin)
+	got, _, _, err = parseWhen([]string{"tomorrow", "09:00"}, now, berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, time.October, 26, 8, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("tomorrow 09:00 at %v = %v, want %v", now, got, want)
+	}
+}
@@ -0,0 +1,412 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Settings are read for most incoming messages, changes made by this bot instance update the cache immediately
+const chatSettingsCacheTTL = 10 * time.Minute
+
+// Callback data of the settings menu is "settings:<key>", a tap switches the setting to its next value
+const settingsCallbackPrefix = "settings:"
+
+// Keys of settings read by handlers and middleware
+const (
+	settingAddConsent    = "add_consent"
+	settingMentionSender = "mention_sender"
+	settingAutoAll       = "auto_all"
+	settingFreeForm      = "free_form"
+	settingLanguage      = "language"
+	settingTimezone      = "timezone"
+	settingGroupCooldown = "group_cooldown"
+	settingUserCooldown  = "user_cooldown"
+	settingCooldownReply = "cooldown_reply"
+)
+
+// Values of switch settings
+const (
+	settingYes = "yes"
+	settingNo  = "no"
+)
+
+// Values of the add_consent setting
+const (
+	// addConsentTarget makes the target user confirm every /add and /remove done by someone else
+	addConsentTarget = "target"
+	// addConsentAdmin lets chat admins add and remove users without a confirmation
+	addConsentAdmin = "admin"
+)
+
+// languageAuto picks the language of every reply by the user's Telegram language
+const languageAuto = "auto"
+
+const defaultTimezone = "UTC"
+
+// chatSetting describes a single option changeable with /settings
+type chatSetting struct {
+	key          string
+	description  string
+	defaultValue string
+	// values lists allowed values, settings without them use parse instead and can't be changed from the menu
+	values []string
+	parse  func(value string) (string, error)
+	get    func(settings *storage.ChatSettings) string
+	set    func(settings *storage.ChatSettings, value string)
+}
+
+// chatSettings lists all chat options in display order
+var chatSettings = []chatSetting{
+	{
+		key:          settingAutoAll,
+		description:  "add everyone who writes in the chat to the 'all' group if it exists",
+		defaultValue: settingYes,
+		values:       []string{settingYes, settingNo},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.AutoAll
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.AutoAll = value
+		},
+	},
+	{
+		key:          settingFreeForm,
+		description:  "mention groups written as @name in any message, not only with /mention",
+		defaultValue: settingYes,
+		values:       []string{settingYes, settingNo},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.FreeForm
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.FreeForm = value
+		},
+	},
+	{
+		key:          settingMentionSender,
+		description:  "mention the author of a mention too if they are in the group",
+		defaultValue: settingNo,
+		values:       []string{settingNo, settingYes},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.MentionSender
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.MentionSender = value
+		},
+	},
+	{
+		key:          settingGroupCooldown,
+		description:  "how often the same group can be mentioned, like 30s or 5m, chat admins are exempt",
+		defaultValue: cooldownOff,
+		parse:        parseCooldown,
+		get: func(settings *storage.ChatSettings) string {
+			return settings.GroupCooldown
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.GroupCooldown = value
+		},
+	},
+	{
+		key:          settingUserCooldown,
+		description:  "how often the same user can mention groups, like 30s or 5m, chat admins are exempt",
+		defaultValue: cooldownOff,
+		parse:        parseCooldown,
+		get: func(settings *storage.ChatSettings) string {
+			return settings.UserCooldown
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.UserCooldown = value
+		},
+	},
+	{
+		key:          settingCooldownReply,
+		description:  "reply with the remaining time to mentions on cooldown instead of ignoring them",
+		defaultValue: settingYes,
+		values:       []string{settingYes, settingNo},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.CooldownReply
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.CooldownReply = value
+		},
+	},
+	{
+		key:          settingAddConsent,
+		description:  "who confirms /add and /remove of other users: 'target' - always the user, 'admin' - nobody when done by a chat admin",
+		defaultValue: addConsentTarget,
+		values:       []string{addConsentTarget, addConsentAdmin},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.AddConsent
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.AddConsent = value
+		},
+	},
+	{
+		key:          settingLanguage,
+		description:  "language of bot replies, 'auto' follows the Telegram language of each user",
+		defaultValue: languageAuto,
+		values:       append([]string{languageAuto}, supportedLanguages...),
+		get: func(settings *storage.ChatSettings) string {
+			return settings.Language
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.Language = value
+		},
+	},
+	{
+		key:          settingTimezone,
+		description:  "time zone of reminders, like Europe/Berlin",
+		defaultValue: defaultTimezone,
+		parse: func(value string) (string, error) {
+			loc, err := time.LoadLocation(value)
+			if err != nil {
+				return "", err
+			}
+			return loc.String(), nil
+		},
+		get: func(settings *storage.ChatSettings) string {
+			return settings.Timezone
+		},
+		set: func(settings *storage.ChatSettings, value string) {
+			settings.Timezone = value
+		},
+	},
+}
+
+// value returns the current value of the setting falling back to the default one
+func (s chatSetting) value(settings *storage.ChatSettings) string {
+	if value := s.get(settings); value != "" {
+		return value
+	}
+	return s.defaultValue
+}
+
+// nextValue returns the value following the current one in the menu
+func (s chatSetting) nextValue(settings *storage.ChatSettings) string {
+	i := slices.Index(s.values, s.value(settings))
+	return s.values[(i+1)%len(s.values)]
+}
+
+// chatLocation returns the time zone of the chat
+func chatLocation(settings *storage.ChatSettings) *time.Location {
+	if settings.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		slog.Warn("bot: Unknown time zone in chat settings", "error", err, "chat_id", settings.ChatID, "timezone", settings.Timezone)
+		return time.UTC
+	}
+	return loc
+}
+
+func findChatSetting(key string) (chatSetting, bool) {
+	for _, setting := range chatSettings {
+		if setting.key == key {
+			return setting, true
+		}
+	}
+	return chatSetting{}, false
+}
+
+// newChatSettingsCache exists because the storage package is shadowed by the argument of New
+func newChatSettingsCache() *ttlCache[int64, storage.ChatSettings] {
+	return newTTLCache[int64, storage.ChatSettings](chatSettingsCacheTTL)
+}
+
+// getChatSettings returns settings of the chat from the cache or the storage. The result is a copy,
+// changes must be saved with saveChatSettings.
+func (b *Bot) getChatSettings(ctx context.Context, chatID int64) (*storage.ChatSettings, error) {
+	if settings, ok := b.settingsCache.Get(chatID); ok {
+		return &settings, nil
+	}
+
+	settings, err := b.storage.GetChatSettings(ctx, chatID)
+	if err != nil {
+		slog.Error("bot: Failed to get chat settings", "error", err, "chat_id", chatID)
+		return nil, err
+	}
+	b.settingsCache.Set(chatID, *settings)
+	return settings, nil
+}
+
+func (b *Bot) saveChatSettings(ctx context.Context, settings *storage.ChatSettings) error {
+	if err := b.storage.SaveChatSettings(ctx, settings); err != nil {
+		b.settingsCache.Delete(settings.ChatID)
+		return err
+	}
+	b.settingsCache.Set(settings.ChatID, *settings)
+	return nil
+}
+
+// settingEnabled tells if a yes/no setting is on in the chat. The default is used if settings can't be loaded.
+func (b *Bot) settingEnabled(ctx context.Context, chatID int64, key string) bool {
+	setting, _ := findChatSetting(key)
+	settings, err := b.getChatSettings(ctx, chatID)
+	if err != nil {
+		return setting.defaultValue == settingYes
+	}
+	return setting.value(settings) == settingYes
+}
+
+func (b *Bot) handleSettings(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling settings command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	args := strings.Fields(message.Text)
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	settings, err := b.getChatSettings(ctx, message.Chat.ID)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to get chat settings: %v", err)), &message)
+		return nil
+	}
+
+	if len(args) == 1 {
+		return b.sendMessage(ctx, message.Chat.ID, settingsMenuText(lang, settings), &message, settingsMenuKeyboard(settings))
+	}
+
+	if len(args) != 3 {
+		slog.Debug("bot: Invalid settings command format", "args_count", len(args))
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Usage: /settings <key> <value>")), &message)
+		return nil
+	}
+
+	actual, err := b.chatRole(ctx, &message)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to check permissions: %v", err)), &message)
+		return nil
+	}
+	if actual < roleAdmin {
+		slog.Debug("bot: Non-admin tried to change settings", "chat_id", message.Chat.ID, "user_id", message.From.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Only chat admins can change settings.")), &message)
+		return nil
+	}
+
+	key, value := strings.ToLower(args[1]), args[2]
+	setting, ok := findChatSetting(key)
+	if !ok {
+		keys := make([]string, 0, len(chatSettings))
+		for _, setting := range chatSettings {
+			keys = append(keys, setting.key)
+		}
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Unknown setting '%s'. Available: %s.", args[1], strings.Join(keys, ", "))), &message)
+		return nil
+	}
+	if setting.parse != nil {
+		parsed, err := setting.parse(value)
+		if err != nil {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid value '%s' of %s: %s", args[2], setting.key, tr(lang, err.Error()))), &message)
+			return nil
+		}
+		value = parsed
+	} else {
+		value = strings.ToLower(value)
+		if !slices.Contains(setting.values, value) {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Unknown value '%s' of %s. Available: %s.", args[2], setting.key, strings.Join(setting.values, ", "))), &message)
+			return nil
+		}
+	}
+
+	setting.set(settings, value)
+	if err := b.saveChatSettings(ctx, settings); err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to update settings: %v", err)), &message)
+		return nil
+	}
+
+	slog.Info("bot: Chat settings updated", "chat_id", message.Chat.ID, "key", setting.key, "value", value)
+	// The language itself might have just changed
+	lang = b.language(ctx, message.Chat.ID, message.From)
+	b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Setting %s is now '%s'.", setting.key, value)), &message)
+	return nil
+}
+
+func settingsMenuText(lang string, settings *storage.ChatSettings) string {
+	lines := make([]string, 0, len(chatSettings))
+	for _, setting := range chatSettings {
+		lines = append(lines, escapeMarkdownV2(fmt.Sprintf("• %s = %s - %s", setting.key, setting.value(settings), tr(lang, setting.description))))
+	}
+
+	header := escapeMarkdownV2(tr(lang, "Settings of this chat:")) + "\n"
+	footer := "\n\n" + escapeMarkdownV2(tr(lang, "Chat admins can tap a button to switch a setting or use /settings <key> <value>."))
+	return header + strings.Join(lines, "\n") + footer
+}
+
+// settingsMenuKeyboard has a button for every setting with a fixed set of values
+func settingsMenuKeyboard(settings *storage.ChatSettings) *t.InlineKeyboardMarkup {
+	var rows [][]t.InlineKeyboardButton
+	for _, setting := range chatSettings {
+		if len(setting.values) == 0 {
+			continue
+		}
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(fmt.Sprintf("%s: %s", setting.key, setting.value(settings))).WithCallbackData(settingsCallbackPrefix+setting.key),
+		))
+	}
+	return tu.InlineKeyboard(rows...)
+}
+
+func (b *Bot) handleSettingsCallback(ctx *th.Context, query t.CallbackQuery) error {
+	slog.Debug("bot: Handling settings callback", "from_user_id", query.From.ID, "data", query.Data)
+
+	if query.Message == nil || !query.Message.IsAccessible() {
+		b.answerCallback(ctx, query.ID, tr(userLanguage(&query.From), "This menu is too old, use /settings again."))
+		return nil
+	}
+	chat := query.Message.GetChat()
+	lang := b.language(ctx, chat.ID, &query.From)
+
+	setting, ok := findChatSetting(strings.TrimPrefix(query.Data, settingsCallbackPrefix))
+	if !ok || len(setting.values) == 0 {
+		slog.Warn("bot: Malformed settings callback data", "data", query.Data)
+		b.answerCallback(ctx, query.ID, "")
+		return nil
+	}
+
+	// Permission checks work with messages, so the tap is treated like a command sent by the user
+	actual, err := b.chatRole(ctx, &t.Message{Chat: chat, From: &query.From})
+	if err != nil {
+		b.answerCallback(ctx, query.ID, tr(lang, "Failed to check permissions: %v", err))
+		return nil
+	}
+	if actual < roleAdmin {
+		slog.Debug("bot: Non-admin tried to change settings", "chat_id", chat.ID, "user_id", query.From.ID)
+		b.answerCallback(ctx, query.ID, tr(lang, "Only chat admins can change settings."))
+		return nil
+	}
+
+	settings, err := b.getChatSettings(ctx, chat.ID)
+	if err != nil {
+		b.answerCallback(ctx, query.ID, tr(lang, "Failed to get chat settings: %v", err))
+		return nil
+	}
+	value := setting.nextValue(settings)
+	setting.set(settings, value)
+	if err := b.saveChatSettings(ctx, settings); err != nil {
+		b.answerCallback(ctx, query.ID, tr(lang, "Failed to update settings: %v", err))
+		return nil
+	}
+
+	slog.Info("bot: Chat settings updated", "chat_id", chat.ID, "key", setting.key, "value", value, "user_id", query.From.ID)
+	lang = b.language(ctx, chat.ID, &query.From)
+	b.answerCallback(ctx, query.ID, tr(lang, "Setting %s is now '%s'.", setting.key, value))
+
+	edit := tu.EditMessageText(tu.ID(chat.ID), query.Message.GetMessageID(), settingsMenuText(lang, settings))
+	edit.ParseMode = "MarkdownV2"
+	edit.ReplyMarkup = settingsMenuKeyboard(settings)
+	if _, err := b.bot.EditMessageText(ctx, edit); err != nil {
+		slog.Error("bot: Failed to edit settings menu", "error", err, "chat_id", chat.ID)
+	}
+	return nil
+}
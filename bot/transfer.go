@@ -0,0 +1,311 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+
+	t "github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	// Pending imports keep the whole document in memory, so they expire sooner than consent requests
+	importRequestTTL     = time.Hour
+	importCallbackPrefix = "import:"
+
+	// maxImportFileSize is far above any real export, it only keeps random files out of memory
+	maxImportFileSize = 1 << 20
+)
+
+// importRequest is an import waiting for the admin who started it to confirm the dry run
+type importRequest struct {
+	chatID    int64
+	requester storage.User
+	export    *storage.ChatExport
+}
+
+func (b *Bot) handleExport(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling export command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	if !b.checkAdmin(ctx, &message, "export") {
+		return nil
+	}
+
+	export, err := storage.ExportChat(ctx, b.storage, message.Chat.ID, time.Now())
+	if err != nil {
+		slog.Error("bot: Failed to export chat groups", "error", err, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to export groups: %v", err)), &message)
+		return nil
+	}
+	data, err := export.Marshal()
+	if err != nil {
+		slog.Error("bot: Failed to encode chat export", "error", err, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to export groups: %v", err)), &message)
+		return nil
+	}
+
+	fileName := fmt.Sprintf("groups-%d-%s.json", message.Chat.ID, export.ExportedAt.Format("20060102-150405"))
+	caption := tr(lang, "Groups of this chat: %d. Reply to this file with /import to restore them here or in another chat.", len(export.Groups))
+	document := func() *t.SendDocumentParams {
+		return tu.Document(tu.ID(message.Chat.ID), tu.FileFromBytes(data, fileName)).
+			WithCaption(caption).
+			WithReplyParameters(&t.ReplyParameters{MessageID: message.MessageID})
+	}
+	b.queueDocument(message.Chat.ID, document, func(_ *t.Message, err error) {
+		if err != nil {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to export groups: %v", err)), &message)
+			return
+		}
+		slog.Info("bot: Chat groups exported", "chat_id", message.Chat.ID, "group_count", len(export.Groups), "user_id", message.From.ID)
+	})
+	return nil
+}
+
+func (b *Bot) handleImport(ctx *th.Context, message t.Message) error {
+	slog.Debug("bot: Handling import command", "chat_id", message.Chat.ID, "from_user_id", message.From.ID)
+
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	if !b.checkAdmin(ctx, &message, "import") {
+		return nil
+	}
+
+	if message.ReplyToMessage == nil || message.ReplyToMessage.Document == nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Reply with /import to a file made by /export.")), &message)
+		return nil
+	}
+	document := message.ReplyToMessage.Document
+	if document.FileSize > maxImportFileSize {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "This file is too large to be an export.")), &message)
+		return nil
+	}
+
+	b.sendTyping(ctx, tu.ID(message.Chat.ID))
+
+	export, err := b.downloadExport(ctx, document.FileID)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidExport) {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "This file is not an export of groups: %v", err)), &message)
+		} else {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to download the file: %v", err)), &message)
+		}
+		return nil
+	}
+	for _, name := range export.Names() {
+		if !IsValidGroupName(name) {
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Invalid group name '%s' in the file.", name)), &message)
+			return nil
+		}
+	}
+
+	plan, err := storage.PlanImport(ctx, b.storage, message.Chat.ID, export)
+	if err != nil {
+		slog.Error("bot: Failed to plan import", "error", err, "chat_id", message.Chat.ID)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to import groups: %v", err)), &message)
+		return nil
+	}
+	if plan.Empty() {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Nothing to import, all groups and members from the file are already here.")), &message)
+		return nil
+	}
+
+	id, err := newRequestID()
+	if err != nil {
+		slog.Error("bot: Failed to generate import request ID", "error", err)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to request confirmation: %v", err)), &message)
+		return nil
+	}
+	keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("✅ "+tr(lang, "Import")).WithCallbackData(importCallbackPrefix+id+":y"),
+		tu.InlineKeyboardButton("❌ "+tr(lang, "Cancel")).WithCallbackData(importCallbackPrefix+id+":n"),
+	))
+
+	b.importRequests.Set(id, importRequest{
+		chatID:    message.Chat.ID,
+		requester: userFromTelegram(message.From),
+		export:    export,
+	})
+	slog.Debug("bot: Requesting import confirmation", "request_id", id, "chat_id", message.Chat.ID, "group_count", len(export.Groups))
+	params := b.messageParams(message.Chat.ID, formatImportPlan(lang, export, plan), &message, keyboard)
+	b.queueMessage(params, func(_ *t.Message, err error) {
+		// Nobody can confirm an import which was never shown
+		if err != nil {
+			b.importRequests.Delete(id)
+			b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to request confirmation: %v", err)), &message)
+		}
+	})
+	return nil
+}
+
+// downloadExport fetches and parses a document sent to the chat
+func (b *Bot) downloadExport(ctx context.Context, fileID string) (*storage.ChatExport, error) {
+	file, err := b.bot.GetFile(ctx, &t.GetFileParams{FileID: fileID})
+	if err != nil {
+		slog.Error("bot: Failed to get file", "error", err, "file_id", fileID)
+		return nil, err
+	}
+	data, err := tu.DownloadFile(b.bot.FileDownloadURL(file.FilePath))
+	if err != nil {
+		slog.Error("bot: Failed to download file", "error", err, "file_id", fileID)
+		return nil, err
+	}
+	return storage.ParseChatExport(data)
+}
+
+// formatImportPlan describes the dry run of an import as MarkdownV2. Long plans are cut to fit into
+// a single message, changes are kept before unchanged groups.
+func formatImportPlan(lang string, export *storage.ChatExport, plan *storage.ImportPlan) string {
+	var lines []string
+	var unchanged []string
+	for _, group := range plan.Groups {
+		switch {
+		case group.Create:
+			lines = append(lines, tr(lang, "+ %s (new group)", group.Name))
+		case group.Changed() || len(group.SkippedAliases) > 0 || len(group.SkippedIncludes) > 0:
+			lines = append(lines, group.Name)
+		default:
+			unchanged = append(unchanged, group.Name)
+			continue
+		}
+		for _, alias := range group.NewAliases {
+			lines = append(lines, tr(lang, "    + alias %s", alias))
+		}
+		for _, alias := range group.SkippedAliases {
+			lines = append(lines, tr(lang, "    ! alias %s is skipped, another group has this name", alias))
+		}
+		for _, child := range group.NewIncludes {
+			lines = append(lines, tr(lang, "    + includes %s", child))
+		}
+		for _, child := range group.SkippedIncludes {
+			lines = append(lines, tr(lang, "    ! including %s is skipped, it would make a cycle", child))
+		}
+		for _, member := range group.NewMembers {
+			lines = append(lines, "    + "+displayName(storage.User{ID: member.UserID, Username: member.Username, FirstName: member.Name}))
+		}
+		if group.KeptMembers > 0 {
+			lines = append(lines, tr(lang, "    already in the group: %d", group.KeptMembers))
+		}
+	}
+
+	header := escapeMarkdownV2(tr(lang, "Import from a file exported on %s:", export.ExportedAt.Format("2006-01-02 15:04 MST")))
+	footer := "\n\n" + escapeMarkdownV2(tr(lang, "Nothing is removed by the import. Apply it?"))
+	moreLines := func(n int) string { return tr(lang, "…and %d more lines", n) }
+	moreGroups := func(n int) string { return tr(lang, "…and %d more", n) }
+	unchangedPrefix := "\n" + escapeMarkdownV2(tr(lang, "Unchanged: %s", ""))
+
+	limit := maxMessageLength - len(header) - len(footer)
+	// Room for at least the count of unchanged groups is kept, whatever the changes take
+	var unchangedReserve int
+	if len(unchanged) > 0 {
+		unchangedReserve = len(unchangedPrefix) + len(escapeMarkdownV2(moreGroups(len(unchanged))))
+	}
+	text := header + "\n" + joinWithinLength(lines, "\n", limit-1-unchangedReserve, moreLines)
+	if len(unchanged) > 0 {
+		text += unchangedPrefix + joinWithinLength(unchanged, ", ", maxMessageLength-len(text)-len(unchangedPrefix)-len(footer), moreGroups)
+	}
+	return text + footer
+}
+
+// joinWithinLength escapes the items for MarkdownV2 and joins them while the result fits into limit bytes.
+// Items which don't fit are replaced with the more text for their count.
+func joinWithinLength(items []string, separator string, limit int, more func(n int) string) string {
+	var result strings.Builder
+	for i, item := range items {
+		piece := escapeMarkdownV2(item)
+		if i > 0 {
+			piece = separator + piece
+		}
+		// The rest must still fit in case the next item doesn't, their count only gets shorter
+		var rest int
+		if left := len(items) - i - 1; left > 0 {
+			rest = len(separator) + len(escapeMarkdownV2(more(left)))
+		}
+		if result.Len()+len(piece)+rest > limit {
+			if i > 0 {
+				result.WriteString(separator)
+			}
+			result.WriteString(escapeMarkdownV2(more(len(items) - i)))
+			break
+		}
+		result.WriteString(piece)
+	}
+	return result.String()
+}
+
+func (b *Bot) handleImportCallback(ctx *th.Context, query t.CallbackQuery) error {
+	slog.Debug("bot: Handling import callback", "from_user_id", query.From.ID, "data", query.Data)
+
+	id, answer, _ := strings.Cut(strings.TrimPrefix(query.Data, importCallbackPrefix), ":")
+
+	request, ok := b.importRequests.Get(id)
+	if !ok || query.Message == nil || !query.Message.IsAccessible() {
+		b.answerCallback(ctx, query.ID, tr(userLanguage(&query.From), "This request has expired."))
+		return nil
+	}
+	lang := b.language(ctx, request.chatID, &query.From)
+	if query.From.ID != request.requester.ID {
+		b.answerCallback(ctx, query.ID, tr(lang, "Only %s can answer this request.", displayName(request.requester)))
+		return nil
+	}
+	b.importRequests.Delete(id)
+
+	var result string
+	if answer == "y" {
+		result = b.applyImport(ctx, lang, request)
+	} else {
+		result = tr(lang, "Import canceled.")
+	}
+
+	b.answerCallback(ctx, query.ID, "")
+	edit := tu.EditMessageText(tu.ID(query.Message.GetChat().ID), query.Message.GetMessageID(), escapeMarkdownV2(result))
+	edit.ParseMode = "MarkdownV2"
+	if _, err := b.bot.EditMessageText(ctx, edit); err != nil {
+		slog.Error("bot: Failed to edit import request message", "error", err, "chat_id", request.chatID)
+	}
+	return nil
+}
+
+// applyImport imports the document and describes the result
+func (b *Bot) applyImport(ctx context.Context, lang string, request importRequest) string {
+	plan, err := storage.ImportChat(ctx, b.storage, request.chatID, request.export)
+	if err != nil {
+		slog.Error("bot: Failed to import chat groups", "error", err, "chat_id", request.chatID)
+		return tr(lang, "Failed to import groups: %v", err)
+	}
+
+	var created, added, aliases, inclusions int
+	for _, group := range plan.Groups {
+		if group.Create {
+			created++
+		}
+		added += len(group.NewMembers)
+		aliases += len(group.NewAliases)
+		inclusions += len(group.NewIncludes)
+	}
+	slog.Info("bot: Chat groups imported", "chat_id", request.chatID, "created_groups", created, "added_members", added,
+		"added_aliases", aliases, "added_inclusions", inclusions, "user_id", request.requester.ID)
+	return tr(lang, "Import done: %d groups created, %d members, %d aliases and %d inclusions added.", created, added, aliases, inclusions)
+}
+
+// checkAdmin tells if the message sender is a chat admin and tells them otherwise
+func (b *Bot) checkAdmin(ctx context.Context, message *t.Message, command string) bool {
+	lang := b.language(ctx, message.Chat.ID, message.From)
+	actual, err := b.chatRole(ctx, message)
+	if err != nil {
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Failed to check permissions: %v", err)), message)
+		return false
+	}
+	if actual < roleAdmin {
+		slog.Debug("bot: Non-admin tried to use admin command", "chat_id", message.Chat.ID, "command", command)
+		b.sendMessage(ctx, message.Chat.ID, escapeMarkdownV2(tr(lang, "Only chat admins can use /%s here.", command)), message)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	t "github.com/mymmrac/telego"
+)
+
+// WebhookConfig describes how the bot receives updates via webhook
+type WebhookConfig struct {
+	// Listen is the local address of the HTTP server, e.g. ":8080"
+	Listen string
+	// URL is the public base URL the reverse proxy exposes the bot at
+	URL string
+	// Path is the HTTP path of the webhook handler
+	Path string
+	// SecretToken is checked against the X-Telegram-Bot-Api-Secret-Token header if not empty
+	SecretToken string
+}
+
+// Option configures optional bot features
+type Option func(b *Bot)
+
+// WithWebhook switches the bot from long polling to webhook updates
+func WithWebhook(config WebhookConfig) Option {
+	return func(b *Bot) {
+		b.webhook = &config
+	}
+}
+
+// allowedUpdates are the update types the bot handles. Telegram sends chat_member updates only if they are
+// asked for explicitly.
+var allowedUpdates = []string{"message", "callback_query", "inline_query", "chosen_inline_result", "chat_member", "my_chat_member"}
+
+// longPollingTimeout is how long a getUpdates request waits for updates in seconds. telego applies its
+// own default only without params, with a zero timeout it would poll back to back.
+const longPollingTimeout = 30
+
+// getUpdates starts receiving updates via webhook if it's configured or via long polling otherwise
+func (b *Bot) getUpdates(ctx context.Context) (<-chan t.Update, error) {
+	if b.webhook == nil {
+		// getUpdates doesn't work while a webhook is set, so remove the one left from the previous runs
+		if err := b.bot.DeleteWebhook(ctx, nil); err != nil {
+			slog.Warn("bot:updates: Failed to delete webhook", "error", err)
+		}
+
+		slog.Debug("bot:updates: Using long polling")
+		return b.bot.UpdatesViaLongPolling(ctx, &t.GetUpdatesParams{
+			Timeout:        longPollingTimeout,
+			AllowedUpdates: allowedUpdates,
+		})
+	}
+
+	path := b.webhook.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	webhookURL := strings.TrimSuffix(b.webhook.URL, "/") + path
+
+	slog.Debug("bot:updates: Using webhook", "listen", b.webhook.Listen, "url", webhookURL, "has_secret_token", b.webhook.SecretToken != "")
+
+	var secretToken []string
+	if b.webhook.SecretToken != "" {
+		secretToken = append(secretToken, b.webhook.SecretToken)
+	}
+
+	mux := http.NewServeMux()
+	updates, err := b.bot.UpdatesViaWebhook(ctx,
+		t.WebhookHTTPServeMux(mux, "POST "+path, secretToken...),
+		t.WithWebhookSet(ctx, &t.SetWebhookParams{
+			URL:            webhookURL,
+			SecretToken:    b.webhook.SecretToken,
+			AllowedUpdates: allowedUpdates,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up webhook: %w", err)
+	}
+
+	b.webhookServer = &http.Server{
+		Addr:    b.webhook.Listen,
+		Handler: mux,
+	}
+	go func() {
+		slog.Info("bot:updates: Webhook server listening", "listen", b.webhook.Listen, "path", path)
+		if err := b.webhookServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("bot:updates: Webhook server failed", "error", err)
+		}
+	}()
+
+	return updates, nil
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	// Chat time zones must work in containers without system time zone data
+	_ "time/tzdata"
 
 	"telegram-group-mention-bot/bot"
 	"telegram-group-mention-bot/storage"
@@ -12,14 +22,25 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout limits graceful shutdown, Docker kills the container 10 seconds after SIGTERM by default
+const shutdownTimeout = 8 * time.Second
+
 func main() {
 	// Parse command-line flags
 	verbose := flag.Bool("v", false, "Enable verbose logging (LevelInfo)")
 	veryVerbose := flag.Bool("vv", false, "Enable very verbose logging (LevelDebug)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate status|up|down | %s [-json] [args]]\n", os.Args[0], adminUsage())
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// Set up logging
-	setLogLevel(*verbose, *veryVerbose)
+	// Set up logging, subcommands print their results to stdout, so their logs go to stderr
+	logOutput := os.Stdout
+	if flag.NArg() > 0 {
+		logOutput = os.Stderr
+	}
+	setLogLevel(logOutput, *verbose, *veryVerbose)
 
 	slog.Debug("main: Command-line flags parsed", "verbose", *verbose, "very_verbose", *veryVerbose)
 
@@ -30,6 +51,49 @@ func main() {
 		slog.Debug("main: Environment variables loaded from .env file", "env", os.Environ())
 	}
 
+	// DATABASE_URL selects the database by its scheme, otherwise SQLite at DATABASE_PATH is used
+	dbPath := os.Getenv("DATABASE_URL")
+	if dbPath != "" {
+		slog.Debug("main: Using database URL")
+	} else if dbPath = os.Getenv("DATABASE_PATH"); dbPath == "" {
+		dbPath = "data.sqlite"
+		slog.Debug("main: Using default database path", "path", dbPath)
+	} else {
+		slog.Debug("main: Using custom database path", "path", dbPath)
+	}
+
+	// Signals are handled from the very start, so the database is closed even if one arrives during startup
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// Root context of everything the bot does, canceled only after graceful shutdown. Until the bot has
+	// started a signal cancels it right away, as there is nothing to shut down gracefully yet.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopStartupAbort := context.AfterFunc(signalCtx, cancel)
+
+	// Subcommands work with the database only and don't need the bot token
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(ctx, dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("main: Migration command failed", "error", err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if _, ok := adminCommands[flag.Arg(0)]; ok {
+		if err := runAdmin(ctx, dbPath, flag.Arg(0), flag.Args()[1:]); err != nil {
+			slog.Error("main: Admin command failed", "command", flag.Arg(0), "error", err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	// Get configuration from environment
 	token := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if token == "" {
@@ -37,47 +101,137 @@ func main() {
 		os.Exit(1)
 	}
 
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath = "data.sqlite"
-		slog.Debug("main: Using default database path", "path", dbPath)
-	} else {
-		slog.Debug("main: Using custom database path", "path", dbPath)
-	}
-
 	// Initialize storage
-	slog.Debug("main: Initializing storage", "db_path", dbPath)
-	storage, err := storage.New(dbPath)
+	slog.Debug("main: Initializing storage")
+	storage, err := storage.New(ctx, dbPath)
 	if err != nil {
 		slog.Error("main: Failed to initialize storage", "error", err)
 		os.Exit(1)
 	}
 	slog.Debug("main: Storage initialized successfully")
 
+	// os.Exit skips deferred calls, so failures after this point close the database themselves
+	fail := func(msg string, err error) {
+		slog.Error(msg, "error", err)
+		if err := storage.Close(); err != nil {
+			slog.Error("main: Failed to close storage", "error", err)
+		}
+		os.Exit(1)
+	}
+
+	// Updates are received via webhook only if its public URL is configured
+	var botOptions []bot.Option
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		webhook := bot.WebhookConfig{
+			Listen:      getEnvDefault("WEBHOOK_LISTEN", ":8080"),
+			URL:         webhookURL,
+			Path:        getEnvDefault("WEBHOOK_PATH", "/webhook"),
+			SecretToken: os.Getenv("WEBHOOK_SECRET"),
+		}
+		slog.Debug("main: Using webhook", "listen", webhook.Listen, "url", webhook.URL, "path", webhook.Path)
+		botOptions = append(botOptions, bot.WithWebhook(webhook))
+	} else {
+		slog.Debug("main: Using long polling")
+	}
+
+	// Metrics and health endpoints are served only if their addresses are configured
+	if metricsListen := os.Getenv("METRICS_LISTEN"); metricsListen != "" {
+		slog.Debug("main: Serving metrics", "listen", metricsListen)
+		botOptions = append(botOptions, bot.WithMetrics(metricsListen))
+	}
+	if healthListen := os.Getenv("HEALTH_LISTEN"); healthListen != "" {
+		slog.Debug("main: Serving health endpoints", "listen", healthListen)
+		botOptions = append(botOptions, bot.WithHealth(healthListen))
+	}
+
 	// Initialize bot
 	slog.Debug("main: Initializing bot")
-	bot, err := bot.New(token, storage)
+	bot, err := bot.New(token, storage, botOptions...)
 	if err != nil {
-		slog.Error("main: Failed to initialize bot", "error", err)
-		os.Exit(1)
+		fail("main: Failed to initialize bot", err)
 	}
 	slog.Debug("main: Bot initialized successfully")
 
 	// Start bot
 	slog.Info("main: Starting bot...")
-	if err := bot.Start(); err != nil {
-		slog.Error("main: Failed to start bot", "error", err)
-		os.Exit(1)
+	if err := bot.Start(ctx); err != nil {
+		fail("main: Failed to start bot", err)
 	}
 	slog.Info("main: Bot started successfully")
 
+	// From now on a signal starts graceful shutdown instead of canceling everything at once
+	if !stopStartupAbort() {
+		slog.Info("main: Interrupted during startup")
+	}
+
 	// Wait for interrupt signal
 	slog.Debug("main: Bot is running, waiting for interrupt signal")
-	select {}
+	<-signalCtx.Done()
+	stopSignals()
+
+	slog.Info("main: Shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	if err := bot.Stop(shutdownCtx); err != nil {
+		slog.Error("main: Failed to stop bot gracefully", "error", err)
+	}
+
+	// Abort whatever is still running after the deadline
+	cancel()
+
+	if err := storage.Close(); err != nil {
+		slog.Error("main: Failed to close storage", "error", err)
+	}
+	slog.Info("main: Shutdown complete")
 }
 
-// setLogLevel configures the logging level based on the provided flags and environment variable
-func setLogLevel(verbose, veryVerbose bool) {
+// runMigrate runs the "migrate" subcommand which manages the database schema
+func runMigrate(ctx context.Context, dbPath string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate status|up|down")
+	}
+
+	s, err := storage.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "status":
+		// Only shows the state
+	case "up":
+		if err := s.MigrateUp(ctx); err != nil {
+			return err
+		}
+	case "down":
+		if err := s.MigrateDown(ctx); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, use status, up or down", args[0])
+	}
+
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// setLogLevel configures the logging level based on the provided flags and environment variable and writes logs to the output
+func setLogLevel(output io.Writer, verbose, veryVerbose bool) {
 	// Determine logging level based on flags and environment variable
 	logLevel := slog.LevelWarn // Default level
 
@@ -105,10 +259,18 @@ func setLogLevel(verbose, veryVerbose bool) {
 	}
 
 	// Configure structured logging with JSON output
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	logger := slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{
 		Level: logLevel,
 	}))
 	slog.SetDefault(logger)
 
 	slog.Debug("main: Log level set to", "level", logLevel.String())
 }
+
+// getEnvDefault returns the value of the environment variable or the fallback if it's empty
+func getEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,95 @@
+// Package metrics defines Prometheus metrics of the bot. Metrics are always collected, but exposed only
+// when the metrics endpoint is enabled. Labels never include chat or user IDs to keep their cardinality low.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mention_bot"
+
+// registry holds metrics of the bot and of the Go runtime only, unlike the global default registry
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	Updates = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Updates received from Telegram by type.",
+	}, []string{"type"})
+
+	Commands = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Handled bot commands by command and result. Aliases count as their command, unknown commands as 'other'.",
+	}, []string{"command", "result"})
+
+	CommandDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Time spent handling bot commands, without sending the queued replies.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	Mentions = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mentions_total",
+		Help:      "Mentions of groups sent, including reminders and free-form mentions.",
+	})
+
+	MentionedUsers = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mentioned_users_total",
+		Help:      "Users mentioned by all mentions.",
+	})
+
+	APIRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Requests to the Telegram Bot API by method.",
+	}, []string{"method"})
+
+	APIErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Failed requests to the Telegram Bot API by method and error code, 'network' if there was no response.",
+	}, []string{"method", "code"})
+
+	RateLimitWaits = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_waits_total",
+		Help:      "Times outgoing messages waited because Telegram answered with 429 Too Many Requests.",
+	})
+
+	RateLimitWaitSeconds = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds_total",
+		Help:      "Total time Telegram asked to wait with retry_after.",
+	})
+
+	StorageQueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_query_duration_seconds",
+		Help:      "Duration of database queries by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "table"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
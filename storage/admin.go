@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"gorm.io/gorm"
+)
+
+// Queries below are for operators and work on the database directly, the bot doesn't need them
+
+// ChatSummary describes a chat known from its groups, reminders or seen users
+type ChatSummary struct {
+	ChatID         int64 `json:"chat_id"`
+	Groups         int64 `json:"groups"`
+	ArchivedGroups int64 `json:"archived_groups"`
+	// Members is the number of distinct users in groups of the chat
+	Members   int64 `json:"members"`
+	SeenUsers int64 `json:"seen_users"`
+	Reminders int64 `json:"reminders"`
+}
+
+// Stats are row counts of the whole database
+type Stats struct {
+	// Chats is the number of chats with groups
+	Chats          int64 `json:"chats"`
+	Groups         int64 `json:"groups"`
+	ArchivedGroups int64 `json:"archived_groups"`
+	Aliases        int64 `json:"aliases"`
+	Inclusions     int64 `json:"inclusions"`
+	Members        int64 `json:"members"`
+	MutedMembers   int64 `json:"muted_members"`
+	Users          int64 `json:"users"`
+	ChatUsers      int64 `json:"chat_users"`
+	Reminders      int64 `json:"reminders"`
+	Settings       int64 `json:"chat_settings"`
+	Permissions    int64 `json:"command_permissions"`
+}
+
+// VacuumResult is the number of orphaned rows Vacuum deleted from a table
+type VacuumResult struct {
+	Table   string `json:"table"`
+	Deleted int64  `json:"deleted"`
+}
+
+// chatCount is a row of a per-chat count query
+type chatCount struct {
+	ChatID int64
+	Count  int64
+}
+
+// ListChats summarizes all chats ordered by chat ID
+func (s *SQLStorage) ListChats(ctx context.Context) ([]ChatSummary, error) {
+	db := s.db.WithContext(ctx)
+	summaries := make(map[int64]*ChatSummary)
+	summary := func(chatID int64) *ChatSummary {
+		if summaries[chatID] == nil {
+			summaries[chatID] = &ChatSummary{ChatID: chatID}
+		}
+		return summaries[chatID]
+	}
+
+	counts := []struct {
+		query *gorm.DB
+		apply func(*ChatSummary, int64)
+	}{
+		{
+			db.Model(&MentionGroup{}).Select("chat_id, COUNT(*) AS count").Group("chat_id"),
+			func(chat *ChatSummary, count int64) { chat.Groups = count },
+		},
+		{
+			db.Model(&MentionGroup{}).Select("chat_id, COUNT(*) AS count").Where("archived_at IS NOT NULL").Group("chat_id"),
+			func(chat *ChatSummary, count int64) { chat.ArchivedGroups = count },
+		},
+		{
+			db.Model(&GroupMember{}).Select("mention_groups.chat_id AS chat_id, COUNT(DISTINCT group_members.user_id) AS count").
+				Joins("JOIN mention_groups ON mention_groups.id = group_members.group_id").Group("mention_groups.chat_id"),
+			func(chat *ChatSummary, count int64) { chat.Members = count },
+		},
+		{
+			db.Model(&ChatUser{}).Select("chat_id, COUNT(*) AS count").Group("chat_id"),
+			func(chat *ChatSummary, count int64) { chat.SeenUsers = count },
+		},
+		{
+			db.Model(&Reminder{}).Select("chat_id, COUNT(*) AS count").Group("chat_id"),
+			func(chat *ChatSummary, count int64) { chat.Reminders = count },
+		},
+	}
+	for _, c := range counts {
+		var rows []chatCount
+		if err := c.query.Scan(&rows).Error; err != nil {
+			slog.Error("storage: Failed to count chat rows", "error", err)
+			return nil, errors.Join(ErrGet, err)
+		}
+		for _, row := range rows {
+			c.apply(summary(row.ChatID), row.Count)
+		}
+	}
+
+	result := make([]ChatSummary, 0, len(summaries))
+	for _, chatID := range sortedKeys(summaries) {
+		result = append(result, *summaries[chatID])
+	}
+	return result, nil
+}
+
+// Stats counts rows of all tables
+func (s *SQLStorage) Stats(ctx context.Context) (*Stats, error) {
+	db := s.db.WithContext(ctx)
+	var stats Stats
+	counts := []struct {
+		query *gorm.DB
+		count *int64
+	}{
+		{db.Model(&MentionGroup{}).Distinct("chat_id"), &stats.Chats},
+		{db.Model(&MentionGroup{}), &stats.Groups},
+		{db.Model(&MentionGroup{}).Where("archived_at IS NOT NULL"), &stats.ArchivedGroups},
+		{db.Model(&GroupAlias{}), &stats.Aliases},
+		{db.Model(&GroupInclusion{}), &stats.Inclusions},
+		{db.Model(&GroupMember{}), &stats.Members},
+		{db.Model(&GroupMember{}).Where("muted = ?", true), &stats.MutedMembers},
+		{db.Model(&User{}), &stats.Users},
+		{db.Model(&ChatUser{}), &stats.ChatUsers},
+		{db.Model(&Reminder{}), &stats.Reminders},
+		{db.Model(&ChatSettings{}), &stats.Settings},
+		{db.Model(&CommandPermission{}), &stats.Permissions},
+	}
+	for _, c := range counts {
+		if err := c.query.Count(c.count).Error; err != nil {
+			slog.Error("storage: Failed to count rows", "error", err)
+			return nil, errors.Join(ErrGet, err)
+		}
+	}
+	return &stats, nil
+}
+
+// Vacuum deletes rows left behind by deleted groups and reclaims free space of the database.
+// SQLite doesn't enforce foreign keys by default, so such rows may remain after manual changes.
+func (s *SQLStorage) Vacuum(ctx context.Context) ([]VacuumResult, error) {
+	var results []VacuumResult
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		groupIDs := tx.Model(&MentionGroup{}).Select("id")
+		orphans := []struct {
+			table string
+			query *gorm.DB
+			model any
+		}{
+			{"group_members", tx.Where("group_id NOT IN (?)", groupIDs), &GroupMember{}},
+			{"group_aliases", tx.Where("group_id NOT IN (?)", groupIDs), &GroupAlias{}},
+			{"group_inclusions", tx.Where("parent_id NOT IN (?) OR child_id NOT IN (?)", groupIDs, groupIDs), &GroupInclusion{}},
+			{"reminders", tx.Where("group_id NOT IN (?)", groupIDs), &Reminder{}},
+			// Rows of users have zero group ID
+			{"mention_cooldowns", tx.Where("group_id <> 0 AND group_id NOT IN (?)", groupIDs), &MentionCooldown{}},
+		}
+		results = make([]VacuumResult, 0, len(orphans))
+		for _, orphan := range orphans {
+			result := orphan.query.Delete(orphan.model)
+			if result.Error != nil {
+				return result.Error
+			}
+			results = append(results, VacuumResult{Table: orphan.table, Deleted: result.RowsAffected})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("storage: Failed to delete orphaned rows", "error", err)
+		return nil, errors.Join(ErrDelete, err)
+	}
+
+	// VACUUM can't run inside a transaction
+	if err := s.db.WithContext(ctx).Exec("VACUUM").Error; err != nil {
+		slog.Error("storage: Failed to vacuum database", "error", err)
+		return results, errors.Join(ErrUpdate, err)
+	}
+	var deleted int64
+	for _, result := range results {
+		deleted += result.Deleted
+	}
+	slog.Info("storage: Database vacuumed", "deleted_rows", deleted)
+	return results, nil
+}
@@ -0,0 +1,414 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExportVersion is the version of the export format. Version 1 documents have no aliases and inclusions
+// and are still accepted, documents of other versions are rejected.
+const ExportVersion = 2
+
+var ErrInvalidExport = errors.New("invalid export document")
+
+// ChatExport is a portable document with the groups of a chat and their members.
+// It's used to move groups to another chat or to restore them after mistakes.
+type ChatExport struct {
+	Version    int           `json:"version"`
+	ChatID     int64         `json:"chat_id"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Groups     []GroupExport `json:"groups"`
+}
+
+// GroupExport is a group with its aliases, names of the groups it includes and its members
+type GroupExport struct {
+	Name     string         `json:"name"`
+	Aliases  []string       `json:"aliases,omitempty"`
+	Includes []string       `json:"includes,omitempty"`
+	Members  []MemberExport `json:"members"`
+}
+
+// MemberExport identifies a member by the user ID, the names only make the document readable
+// and are used for users the importing storage doesn't know yet
+type MemberExport struct {
+	UserID   int64  `json:"user_id"`
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+}
+
+// ExportChat builds the export document of all groups of the chat
+func ExportChat(ctx context.Context, s Storage, chatID int64, at time.Time) (*ChatExport, error) {
+	groups, err := s.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(groups, func(a, b MentionGroup) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	export := &ChatExport{
+		Version:    ExportVersion,
+		ChatID:     chatID,
+		ExportedAt: at.UTC(),
+		Groups:     make([]GroupExport, 0, len(groups)),
+	}
+	for _, group := range groups {
+		members, err := s.GetGroupMembers(ctx, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(members, func(a, b GroupMember) int {
+			return cmp.Compare(a.UserID, b.UserID)
+		})
+
+		groupExport := GroupExport{Name: group.Name, Members: make([]MemberExport, 0, len(members))}
+		for _, alias := range group.Aliases {
+			groupExport.Aliases = append(groupExport.Aliases, alias.Name)
+		}
+		slices.Sort(groupExport.Aliases)
+
+		included, err := s.GetIncludedGroups(ctx, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range included {
+			groupExport.Includes = append(groupExport.Includes, child.Name)
+		}
+		slices.Sort(groupExport.Includes)
+
+		for _, member := range members {
+			groupExport.Members = append(groupExport.Members, MemberExport{
+				UserID:   member.UserID,
+				Name:     strings.TrimSpace(member.User.FirstName + " " + member.User.LastName),
+				Username: member.User.Username,
+			})
+		}
+		export.Groups = append(export.Groups, groupExport)
+	}
+	return export, nil
+}
+
+// Marshal encodes the document as indented JSON
+func (e *ChatExport) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseChatExport decodes and validates an export document. Group names and aliases are checked to be
+// unique and inclusions to refer to groups of the document, but names are not checked against the naming
+// rules of the bot, see Names.
+func ParseChatExport(data []byte) (*ChatExport, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var export ChatExport
+	if err := decoder.Decode(&export); err != nil {
+		return nil, errors.Join(ErrInvalidExport, err)
+	}
+	if export.Version < 1 || export.Version > ExportVersion {
+		return nil, errors.Join(ErrInvalidExport, fmt.Errorf("unsupported version %d", export.Version))
+	}
+
+	// Aliases share the namespace of group names
+	names := make(map[string]bool)
+	for _, name := range export.Names() {
+		if name == "" {
+			return nil, errors.Join(ErrInvalidExport, ErrEmptyGroupName)
+		}
+		if names[name] {
+			return nil, errors.Join(ErrInvalidExport, fmt.Errorf("name '%s' is listed twice", name))
+		}
+		names[name] = true
+	}
+
+	groupNames := make(map[string]bool, len(export.Groups))
+	for _, group := range export.Groups {
+		groupNames[group.Name] = true
+	}
+	for _, group := range export.Groups {
+		includes := make(map[string]bool, len(group.Includes))
+		for _, child := range group.Includes {
+			if includes[child] {
+				return nil, errors.Join(ErrInvalidExport, fmt.Errorf("group '%s' includes '%s' twice", group.Name, child))
+			}
+			includes[child] = true
+			if !groupNames[child] {
+				return nil, errors.Join(ErrInvalidExport, fmt.Errorf("group '%s' includes '%s', which is not in the document", group.Name, child))
+			}
+			if child == group.Name {
+				return nil, errors.Join(ErrInvalidExport, fmt.Errorf("group '%s' includes itself", group.Name))
+			}
+		}
+
+		userIDs := make(map[int64]bool, len(group.Members))
+		for _, member := range group.Members {
+			if member.UserID == 0 {
+				return nil, errors.Join(ErrInvalidExport, ErrZeroUserID)
+			}
+			if userIDs[member.UserID] {
+				return nil, errors.Join(ErrInvalidExport, fmt.Errorf("user %d is listed twice in group '%s'", member.UserID, group.Name))
+			}
+			userIDs[member.UserID] = true
+		}
+	}
+	return &export, nil
+}
+
+// Names returns names and aliases of all groups of the document
+func (e *ChatExport) Names() []string {
+	var names []string
+	for _, group := range e.Groups {
+		names = append(names, group.Name)
+		names = append(names, group.Aliases...)
+	}
+	return names
+}
+
+// ImportPlan is what importing a document changes in a chat. Imports only add groups, aliases, inclusions
+// and members, anything missing from the document is kept.
+type ImportPlan struct {
+	Groups []GroupImport `json:"groups"`
+}
+
+// GroupImport lists what to add to a group, the group is created first if it doesn't exist.
+// Names taken by an alias refer to the group of the alias, like in all commands. Aliases taken
+// by other groups and inclusions which would create a cycle are skipped.
+type GroupImport struct {
+	Name            string         `json:"name"`
+	Create          bool           `json:"create"`
+	NewAliases      []string       `json:"new_aliases"`
+	SkippedAliases  []string       `json:"skipped_aliases"`
+	NewIncludes     []string       `json:"new_includes"`
+	SkippedIncludes []string       `json:"skipped_includes"`
+	NewMembers      []MemberExport `json:"new_members"`
+	KeptMembers     int            `json:"kept_members"`
+}
+
+// Empty tells if the import changes nothing
+func (p *ImportPlan) Empty() bool {
+	for _, group := range p.Groups {
+		if group.Changed() {
+			return false
+		}
+	}
+	return true
+}
+
+// Changed tells if the import changes the group
+func (g *GroupImport) Changed() bool {
+	return g.Create || len(g.NewAliases) > 0 || len(g.NewIncludes) > 0 || len(g.NewMembers) > 0
+}
+
+// importNode is a group in the inclusion graph of a planned import. Groups to be created have no ID yet,
+// so they are identified by the name.
+type importNode struct {
+	id   uint
+	name string
+}
+
+// importGraph is the inclusion graph of the chat with inclusions planned so far
+type importGraph struct {
+	ctx      context.Context
+	storage  Storage
+	children map[importNode][]importNode
+	loaded   map[importNode]bool
+}
+
+// edges returns groups directly included by the node, existing inclusions are loaded on first use
+func (g *importGraph) edges(node importNode) ([]importNode, error) {
+	if node.id != 0 && !g.loaded[node] {
+		g.loaded[node] = true
+		included, err := g.storage.GetIncludedGroups(g.ctx, node.id)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range included {
+			g.children[node] = append(g.children[node], importNode{id: child.ID})
+		}
+	}
+	return g.children[node], nil
+}
+
+// reaches tells if the target is included by the node, directly or not
+func (g *importGraph) reaches(node, target importNode) (bool, error) {
+	visited := map[importNode]bool{node: true}
+	queue := []importNode{node}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == target {
+			return true, nil
+		}
+		children, err := g.edges(current)
+		if err != nil {
+			return false, err
+		}
+		for _, child := range children {
+			if !visited[child] {
+				visited[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return false, nil
+}
+
+// PlanImport compares the document with the groups of the chat without changing anything
+func PlanImport(ctx context.Context, s Storage, chatID int64, export *ChatExport) (*ImportPlan, error) {
+	plan := &ImportPlan{Groups: make([]GroupImport, 0, len(export.Groups))}
+	nodes := make(map[string]importNode, len(export.Groups))
+	// A group can be listed under its name and an alias, its members are added once
+	planned := make(map[uint]map[int64]bool)
+	for _, groupExport := range export.Groups {
+		groupImport := GroupImport{Name: groupExport.Name}
+
+		group, err := s.GetGroup(ctx, groupExport.Name, chatID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			groupImport.Create = true
+			groupImport.NewMembers = groupExport.Members
+			nodes[groupExport.Name] = importNode{name: groupExport.Name}
+		case err != nil:
+			return nil, err
+		default:
+			nodes[groupExport.Name] = importNode{id: group.ID}
+			if planned[group.ID] == nil {
+				planned[group.ID] = make(map[int64]bool)
+			}
+			for _, member := range groupExport.Members {
+				isMember, err := s.IsMember(ctx, group.ID, member.UserID)
+				if err != nil {
+					return nil, err
+				}
+				if isMember || planned[group.ID][member.UserID] {
+					groupImport.KeptMembers++
+				} else {
+					groupImport.NewMembers = append(groupImport.NewMembers, member)
+					planned[group.ID][member.UserID] = true
+				}
+			}
+		}
+
+		for _, alias := range groupExport.Aliases {
+			taken, err := s.GetGroup(ctx, alias, chatID)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				groupImport.NewAliases = append(groupImport.NewAliases, alias)
+			case err != nil:
+				return nil, err
+			case groupImport.Create || taken.ID != group.ID:
+				groupImport.SkippedAliases = append(groupImport.SkippedAliases, alias)
+			}
+		}
+		plan.Groups = append(plan.Groups, groupImport)
+	}
+
+	// Inclusions are planned once all groups are known, as a group may include one listed after it
+	graph := &importGraph{ctx: ctx, storage: s, children: make(map[importNode][]importNode), loaded: make(map[importNode]bool)}
+	for i, groupExport := range export.Groups {
+		parent := nodes[groupExport.Name]
+		for _, childName := range groupExport.Includes {
+			child := nodes[childName]
+			children, err := graph.edges(parent)
+			if err != nil {
+				return nil, err
+			}
+			if slices.Contains(children, child) {
+				continue
+			}
+			// Including a group which already includes the parent, even indirectly, would create a cycle
+			cycle, err := graph.reaches(child, parent)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				plan.Groups[i].SkippedIncludes = append(plan.Groups[i].SkippedIncludes, childName)
+				continue
+			}
+			graph.children[parent] = append(graph.children[parent], child)
+			plan.Groups[i].NewIncludes = append(plan.Groups[i].NewIncludes, childName)
+		}
+	}
+	return plan, nil
+}
+
+// ImportChat adds groups, aliases, inclusions and members of the document to the chat in a single transaction.
+// The plan is made again inside the transaction, so changes made since the dry run are taken into account.
+func ImportChat(ctx context.Context, s Storage, chatID int64, export *ChatExport) (*ImportPlan, error) {
+	var plan *ImportPlan
+	err := s.Transaction(ctx, func(tx Storage) error {
+		var err error
+		plan, err = PlanImport(ctx, tx, chatID, export)
+		if err != nil {
+			return err
+		}
+
+		for _, groupImport := range plan.Groups {
+			if groupImport.Create {
+				if err := tx.CreateGroup(ctx, groupImport.Name, chatID); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, groupImport := range plan.Groups {
+			if !groupImport.Changed() {
+				continue
+			}
+			group, err := tx.GetGroup(ctx, groupImport.Name, chatID)
+			if err != nil {
+				return err
+			}
+			for _, alias := range groupImport.NewAliases {
+				if err := tx.CreateAlias(ctx, group.ID, chatID, alias); err != nil {
+					return err
+				}
+			}
+			for _, childName := range groupImport.NewIncludes {
+				child, err := tx.GetGroup(ctx, childName, chatID)
+				if err != nil {
+					return err
+				}
+				if err := tx.IncludeGroup(ctx, group.ID, child.ID); err != nil {
+					return err
+				}
+			}
+			for _, member := range groupImport.NewMembers {
+				user, err := importUser(ctx, tx, member)
+				if err != nil {
+					return err
+				}
+				if err := tx.AddMember(ctx, group.ID, user); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// importUser returns the known user, unknown users are created from the names in the document
+func importUser(ctx context.Context, s Storage, member MemberExport) (*User, error) {
+	user, err := s.GetUser(ctx, member.UserID)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return s.CreateOrUpdateUser(ctx, member.UserID, member.Username, member.Name, "")
+}
@@ -0,0 +1,872 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is the Storage implementation which keeps everything in memory.
+// It's meant for tests and mirrors the behavior of SQLStorage including its errors.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	memoryData
+}
+
+// memoryData is the state of MemoryStorage, it's a separate type so transactions can work on a copy of it
+type memoryData struct {
+	users       map[int64]User
+	groups      map[uint]MentionGroup
+	members     map[uint]GroupMember
+	permissions map[uint]CommandPermission
+	aliases     map[uint]GroupAlias
+	inclusions  map[uint]GroupInclusion
+	settings    map[int64]ChatSettings
+	reminders   map[uint]Reminder
+	cooldowns   map[cooldownKey]time.Time
+	chatUsers   map[chatUserKey]time.Time
+
+	lastGroupID      uint
+	lastMemberID     uint
+	lastPermissionID uint
+	lastAliasID      uint
+	lastInclusionID  uint
+	lastReminderID   uint
+}
+
+// clone copies the maps, so changes of the copy don't affect the original
+func (d memoryData) clone() memoryData {
+	d.users = maps.Clone(d.users)
+	d.groups = maps.Clone(d.groups)
+	d.members = maps.Clone(d.members)
+	d.permissions = maps.Clone(d.permissions)
+	d.aliases = maps.Clone(d.aliases)
+	d.inclusions = maps.Clone(d.inclusions)
+	d.settings = maps.Clone(d.settings)
+	d.reminders = maps.Clone(d.reminders)
+	d.cooldowns = maps.Clone(d.cooldowns)
+	d.chatUsers = maps.Clone(d.chatUsers)
+	return d
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+type cooldownKey struct {
+	chatID  int64
+	groupID uint
+	userID  int64
+}
+
+type chatUserKey struct {
+	chatID int64
+	userID int64
+}
+
+func NewMemory() *MemoryStorage {
+	return &MemoryStorage{
+		memoryData: memoryData{
+			users:       make(map[int64]User),
+			groups:      make(map[uint]MentionGroup),
+			members:     make(map[uint]GroupMember),
+			permissions: make(map[uint]CommandPermission),
+			aliases:     make(map[uint]GroupAlias),
+			inclusions:  make(map[uint]GroupInclusion),
+			settings:    make(map[int64]ChatSettings),
+			reminders:   make(map[uint]Reminder),
+			cooldowns:   make(map[cooldownKey]time.Time),
+			chatUsers:   make(map[chatUserKey]time.Time),
+		},
+	}
+}
+
+// Close does nothing as there is nothing to release
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// Ping always succeeds as the data is in memory
+func (s *MemoryStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+// Transaction runs fn with a storage holding a copy of the data, which replaces the data only if fn
+// returns nil. Other callers wait until the transaction ends, so their changes are never lost on rollback.
+// Nested transactions work on a copy of the copy, so like savepoints they roll back only their own changes.
+func (s *MemoryStorage) Transaction(_ context.Context, fn func(tx Storage) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &MemoryStorage{memoryData: s.memoryData.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.memoryData = tx.memoryData
+	return nil
+}
+
+// CreateGroup creates a new mention group in a chat
+func (s *MemoryStorage) CreateGroup(_ context.Context, name string, chatID int64) error {
+	if name == "" {
+		return ErrEmptyGroupName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.findGroup(name, chatID); ok {
+		return errors.Join(ErrCreate, ErrAlreadyExists)
+	}
+
+	s.lastGroupID++
+	s.groups[s.lastGroupID] = MentionGroup{
+		ID:     s.lastGroupID,
+		Name:   name,
+		ChatID: chatID,
+	}
+	return nil
+}
+
+// GetGroup retrieves a group by its name or alias and chat ID
+func (s *MemoryStorage) GetGroup(_ context.Context, name string, chatID int64) (*MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, ok := s.findGroup(name, chatID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &group, nil
+}
+
+// DeleteGroup deletes a group by ID together with its memberships, aliases, inclusions and reminders
+func (s *MemoryStorage) DeleteGroup(_ context.Context, groupID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, member := range s.members {
+		if member.GroupID == groupID {
+			delete(s.members, id)
+		}
+	}
+	for id, alias := range s.aliases {
+		if alias.GroupID == groupID {
+			delete(s.aliases, id)
+		}
+	}
+	for id, inclusion := range s.inclusions {
+		if inclusion.ParentID == groupID || inclusion.ChildID == groupID {
+			delete(s.inclusions, id)
+		}
+	}
+	for id, reminder := range s.reminders {
+		if reminder.GroupID == groupID {
+			delete(s.reminders, id)
+		}
+	}
+	for key := range s.cooldowns {
+		if key.groupID == groupID {
+			delete(s.cooldowns, key)
+		}
+	}
+	delete(s.groups, groupID)
+	return nil
+}
+
+func (s *MemoryStorage) GetGroupsByChat(_ context.Context, chatID int64) ([]MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := s.filterGroups(func(group MentionGroup) bool {
+		return group.ChatID == chatID
+	})
+	for i := range groups {
+		groups[i].Aliases = s.groupAliases(groups[i].ID)
+	}
+	return groups, nil
+}
+
+func (s *MemoryStorage) GetGroupsToJoinByChatAndUser(_ context.Context, chatID int64, userID int64) ([]MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterGroups(func(group MentionGroup) bool {
+		return group.ChatID == chatID && !s.isMember(group.ID, userID)
+	}), nil
+}
+
+func (s *MemoryStorage) GetUserGroupsByChat(_ context.Context, chatID int64, userID int64) ([]MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterGroups(func(group MentionGroup) bool {
+		return group.ChatID == chatID && s.isMember(group.ID, userID)
+	}), nil
+}
+
+// GetUserChatIDs retrieves IDs of chats where the user is a member of at least one group which is not archived
+func (s *MemoryStorage) GetUserChatIDs(_ context.Context, userID int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var chatIDs []int64
+	for _, group := range s.filterGroups(func(group MentionGroup) bool {
+		return group.ArchivedAt == nil && s.isMember(group.ID, userID)
+	}) {
+		if !slices.Contains(chatIDs, group.ChatID) {
+			chatIDs = append(chatIDs, group.ChatID)
+		}
+	}
+	slices.Sort(chatIDs)
+	return chatIDs, nil
+}
+
+func (s *MemoryStorage) FindGroupsByChatAndNamesWithMembers(_ context.Context, chatID int64, names []string) ([]MentionGroup, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := s.filterGroups(func(group MentionGroup) bool {
+		if group.ChatID != chatID {
+			return false
+		}
+		if slices.Contains(names, group.Name) {
+			return true
+		}
+		return slices.ContainsFunc(s.groupAliases(group.ID), func(alias GroupAlias) bool {
+			return slices.Contains(names, alias.Name)
+		})
+	})
+	for i := range groups {
+		groups[i].Members = s.groupMembers(groups[i].ID)
+	}
+	return groups, nil
+}
+
+// MigrateChatGroups moves groups, their aliases and reminders, chat policy, settings and seen users
+// to the new chat ID when a group becomes a supergroup. The groups are restored if the old chat archived them.
+// It fails with ErrAlreadyExists without moving anything if a name of a group or alias is taken in the new chat.
+func (s *MemoryStorage) MigrateChatGroups(_ context.Context, fromChatID, toChatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := make(map[string]bool)
+	for _, group := range s.groups {
+		if group.ChatID == toChatID {
+			taken[group.Name] = true
+		}
+	}
+	for _, alias := range s.aliases {
+		if alias.ChatID == toChatID {
+			taken[alias.Name] = true
+		}
+	}
+	for _, group := range s.groups {
+		if group.ChatID == fromChatID && taken[group.Name] {
+			return errors.Join(ErrUpdate, ErrAlreadyExists)
+		}
+	}
+	for _, alias := range s.aliases {
+		if alias.ChatID == fromChatID && taken[alias.Name] {
+			return errors.Join(ErrUpdate, ErrAlreadyExists)
+		}
+	}
+
+	for id, group := range s.groups {
+		if group.ChatID == fromChatID {
+			group.ChatID = toChatID
+			group.ArchivedAt = nil
+			s.groups[id] = group
+		}
+	}
+	for id, alias := range s.aliases {
+		if alias.ChatID == fromChatID {
+			alias.ChatID = toChatID
+			s.aliases[id] = alias
+		}
+	}
+	for id, permission := range s.permissions {
+		if permission.ChatID == fromChatID {
+			permission.ChatID = toChatID
+			s.permissions[id] = permission
+		}
+	}
+	for id, reminder := range s.reminders {
+		if reminder.ChatID == fromChatID {
+			reminder.ChatID = toChatID
+			s.reminders[id] = reminder
+		}
+	}
+	for key, at := range s.cooldowns {
+		if key.chatID == fromChatID {
+			delete(s.cooldowns, key)
+			key.chatID = toChatID
+			s.cooldowns[key] = at
+		}
+	}
+	for key, at := range s.chatUsers {
+		if key.chatID == fromChatID {
+			delete(s.chatUsers, key)
+			// Users already seen in the new chat keep their newer time
+			key.chatID = toChatID
+			if _, ok := s.chatUsers[key]; !ok {
+				s.chatUsers[key] = at
+			}
+		}
+	}
+	if settings, ok := s.settings[fromChatID]; ok {
+		delete(s.settings, fromChatID)
+		settings.ChatID = toChatID
+		s.settings[toChatID] = settings
+	}
+	return nil
+}
+
+// ArchiveChatGroups archives all groups of the chat which are not archived yet
+func (s *MemoryStorage) ArchiveChatGroups(_ context.Context, chatID int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, group := range s.groups {
+		if group.ChatID == chatID && group.ArchivedAt == nil {
+			group.ArchivedAt = &at
+			s.groups[id] = group
+		}
+	}
+	return nil
+}
+
+// RestoreChatGroups restores archived groups of the chat
+func (s *MemoryStorage) RestoreChatGroups(_ context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, group := range s.groups {
+		if group.ChatID == chatID {
+			group.ArchivedAt = nil
+			s.groups[id] = group
+		}
+	}
+	return nil
+}
+
+// GetGroupByID retrieves a group by its ID
+func (s *MemoryStorage) GetGroupByID(_ context.Context, groupID uint) (*MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &group, nil
+}
+
+// GetUser retrieves a user by ID
+func (s *MemoryStorage) GetUser(_ context.Context, userID int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+// GetUserByUsername retrieves a user by username ignoring its case
+func (s *MemoryStorage) GetUserByUsername(_ context.Context, username string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, id := range sortedKeys(s.users) {
+		if user := s.users[id]; strings.EqualFold(user.Username, username) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetChatUserByUsername retrieves a user by username ignoring its case among users seen in the chat or
+// members of its groups, the one seen in the chat most recently first
+func (s *MemoryStorage) GetChatUserByUsername(_ context.Context, chatID int64, username string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inChatGroups := make(map[int64]bool)
+	for _, member := range s.members {
+		if s.groups[member.GroupID].ChatID == chatID {
+			inChatGroups[member.UserID] = true
+		}
+	}
+
+	var found *User
+	var foundSeenAt time.Time
+	for _, id := range sortedKeys(s.users) {
+		user := s.users[id]
+		if !strings.EqualFold(user.Username, username) {
+			continue
+		}
+		seenAt, seen := s.chatUsers[chatUserKey{chatID: chatID, userID: id}]
+		if !seen && !inChatGroups[id] {
+			continue
+		}
+		if found == nil || seenAt.After(foundSeenAt) {
+			found, foundSeenAt = &user, seenAt
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// CreateOrUpdateUser creates a new user or updates an existing one
+func (s *MemoryStorage) CreateOrUpdateUser(_ context.Context, userID int64, username, firstName, lastName string) (*User, error) {
+	if userID == 0 {
+		return nil, ErrZeroUserID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := User{
+		ID:        userID,
+		Username:  username,
+		FirstName: firstName,
+		LastName:  lastName,
+	}
+	s.users[userID] = user
+	return &user, nil
+}
+
+func (s *MemoryStorage) AddMember(_ context.Context, groupID uint, user *User) error {
+	if user == nil {
+		return ErrNilUser
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isMember(groupID, user.ID) {
+		return errors.Join(ErrCreate, ErrAlreadyExists)
+	}
+
+	s.lastMemberID++
+	s.members[s.lastMemberID] = GroupMember{
+		ID:      s.lastMemberID,
+		GroupID: groupID,
+		UserID:  user.ID,
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a mention group
+func (s *MemoryStorage) RemoveMember(_ context.Context, groupID uint, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, member := range s.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			delete(s.members, id)
+		}
+	}
+	return nil
+}
+
+// GetGroupMembers retrieves all members of a group
+func (s *MemoryStorage) GetGroupMembers(_ context.Context, groupID uint) ([]GroupMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.groupMembers(groupID), nil
+}
+
+// IsMember checks if a user is a member of a group
+func (s *MemoryStorage) IsMember(_ context.Context, groupID uint, userID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.isMember(groupID, userID), nil
+}
+
+// SetMemberMuted mutes or unmutes mentions of a member in a group
+func (s *MemoryStorage) SetMemberMuted(_ context.Context, groupID uint, userID int64, muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, member := range s.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			member.Muted = muted
+			s.members[id] = member
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// RemoveChatMember removes the user from all groups of the chat and forgets the user was seen there
+func (s *MemoryStorage) RemoveChatMember(_ context.Context, chatID int64, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, member := range s.members {
+		if member.UserID == userID && s.groups[member.GroupID].ChatID == chatID {
+			delete(s.members, id)
+		}
+	}
+	delete(s.chatUsers, chatUserKey{chatID: chatID, userID: userID})
+	return nil
+}
+
+// CreateAlias adds an alternative name to a group. It fails with ErrAlreadyExists if the name
+// is already taken by a group or another alias in the chat.
+func (s *MemoryStorage) CreateAlias(_ context.Context, groupID uint, chatID int64, name string) error {
+	if name == "" {
+		return ErrEmptyGroupName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.findGroup(name, chatID); ok {
+		return errors.Join(ErrCreate, ErrAlreadyExists)
+	}
+
+	s.lastAliasID++
+	s.aliases[s.lastAliasID] = GroupAlias{
+		ID:      s.lastAliasID,
+		GroupID: groupID,
+		Name:    name,
+		ChatID:  chatID,
+	}
+	return nil
+}
+
+// DeleteAlias removes a group alias by its name
+func (s *MemoryStorage) DeleteAlias(_ context.Context, chatID int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, alias := range s.aliases {
+		if alias.ChatID == chatID && alias.Name == name {
+			delete(s.aliases, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// IncludeGroup makes the child group a part of the parent group. Cycles are not checked here.
+func (s *MemoryStorage) IncludeGroup(_ context.Context, parentID, childID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inclusion := range s.inclusions {
+		if inclusion.ParentID == parentID && inclusion.ChildID == childID {
+			return errors.Join(ErrCreate, ErrAlreadyExists)
+		}
+	}
+
+	s.lastInclusionID++
+	s.inclusions[s.lastInclusionID] = GroupInclusion{
+		ID:       s.lastInclusionID,
+		ParentID: parentID,
+		ChildID:  childID,
+	}
+	return nil
+}
+
+// ExcludeGroup removes the child group from the parent group
+func (s *MemoryStorage) ExcludeGroup(_ context.Context, parentID, childID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, inclusion := range s.inclusions {
+		if inclusion.ParentID == parentID && inclusion.ChildID == childID {
+			delete(s.inclusions, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetIncludedGroups retrieves groups directly included by a group
+func (s *MemoryStorage) GetIncludedGroups(_ context.Context, groupID uint) ([]MentionGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	childIDs := make(map[uint]bool)
+	for _, inclusion := range s.inclusions {
+		if inclusion.ParentID == groupID {
+			childIDs[inclusion.ChildID] = true
+		}
+	}
+	return s.filterGroups(func(group MentionGroup) bool {
+		return childIDs[group.ID]
+	}), nil
+}
+
+// GetCommandPermissions retrieves all command permission overrides of a chat
+func (s *MemoryStorage) GetCommandPermissions(_ context.Context, chatID int64) ([]CommandPermission, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var permissions []CommandPermission
+	for _, id := range sortedKeys(s.permissions) {
+		if s.permissions[id].ChatID == chatID {
+			permissions = append(permissions, s.permissions[id])
+		}
+	}
+	return permissions, nil
+}
+
+// SetCommandPermission sets the role required to use a command in a chat
+func (s *MemoryStorage) SetCommandPermission(_ context.Context, chatID int64, command, role string) error {
+	if command == "" {
+		return ErrEmptyCommand
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, permission := range s.permissions {
+		if permission.ChatID == chatID && permission.Command == command {
+			permission.Role = role
+			s.permissions[id] = permission
+			return nil
+		}
+	}
+
+	s.lastPermissionID++
+	s.permissions[s.lastPermissionID] = CommandPermission{
+		ID:      s.lastPermissionID,
+		ChatID:  chatID,
+		Command: command,
+		Role:    role,
+	}
+	return nil
+}
+
+// GetChatSettings retrieves settings of a chat, chats which were never configured get the defaults
+func (s *MemoryStorage) GetChatSettings(_ context.Context, chatID int64) (*ChatSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings := s.settings[chatID]
+	settings.ChatID = chatID
+	return &settings, nil
+}
+
+// SaveChatSettings creates or replaces settings of a chat
+func (s *MemoryStorage) SaveChatSettings(_ context.Context, settings *ChatSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings[settings.ChatID] = *settings
+	return nil
+}
+
+// CreateReminder saves a new reminder and sets its ID
+func (s *MemoryStorage) CreateReminder(_ context.Context, reminder *Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastReminderID++
+	reminder.ID = s.lastReminderID
+	s.reminders[reminder.ID] = *reminder
+	return nil
+}
+
+// GetRemindersByChat retrieves all reminders of a chat ordered by their next run
+func (s *MemoryStorage) GetRemindersByChat(_ context.Context, chatID int64) ([]Reminder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterReminders(func(reminder Reminder) bool {
+		return reminder.ChatID == chatID
+	}), nil
+}
+
+// GetDueReminders retrieves reminders of all chats which should run until the given time, except reminders
+// of archived groups
+func (s *MemoryStorage) GetDueReminders(_ context.Context, until time.Time) ([]Reminder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterReminders(func(reminder Reminder) bool {
+		return !reminder.NextRunAt.After(until) && s.groups[reminder.GroupID].ArchivedAt == nil
+	}), nil
+}
+
+// UpdateReminderNextRun reschedules a recurring reminder
+func (s *MemoryStorage) UpdateReminderNextRun(_ context.Context, reminderID uint, nextRunAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reminder, ok := s.reminders[reminderID]; ok {
+		reminder.NextRunAt = nextRunAt
+		s.reminders[reminderID] = reminder
+	}
+	return nil
+}
+
+// DeleteReminder deletes a reminder of the chat
+func (s *MemoryStorage) DeleteReminder(_ context.Context, chatID int64, reminderID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reminder, ok := s.reminders[reminderID]
+	if !ok || reminder.ChatID != chatID {
+		return ErrNotFound
+	}
+	delete(s.reminders, reminderID)
+	return nil
+}
+
+// GetLastMention returns the time of the last mention, zero if there was none
+func (s *MemoryStorage) GetLastMention(_ context.Context, chatID int64, groupID uint, userID int64) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cooldowns[cooldownKey{chatID: chatID, groupID: groupID, userID: userID}], nil
+}
+
+// SaveLastMention creates or updates the time of the last mention
+func (s *MemoryStorage) SaveLastMention(_ context.Context, chatID int64, groupID uint, userID int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cooldowns[cooldownKey{chatID: chatID, groupID: groupID, userID: userID}] = at
+	return nil
+}
+
+// SaveChatUser creates or updates the time the user was last seen in the chat
+func (s *MemoryStorage) SaveChatUser(_ context.Context, chatID int64, userID int64, at time.Time) error {
+	if userID == 0 {
+		return ErrZeroUserID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chatUsers[chatUserKey{chatID: chatID, userID: userID}] = at
+	return nil
+}
+
+// GetUserSeenChatIDs retrieves IDs of chats where the user was seen, most recently seen first
+func (s *MemoryStorage) GetUserSeenChatIDs(_ context.Context, userID int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []chatUserKey
+	for key := range s.chatUsers {
+		if key.userID == userID {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b chatUserKey) int {
+		if c := s.chatUsers[b].Compare(s.chatUsers[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.chatID, b.chatID)
+	})
+
+	chatIDs := make([]int64, 0, len(keys))
+	for _, key := range keys {
+		chatIDs = append(chatIDs, key.chatID)
+	}
+	return chatIDs, nil
+}
+
+// filterReminders returns matching reminders ordered by their next run like the database does
+func (s *MemoryStorage) filterReminders(match func(Reminder) bool) []Reminder {
+	var reminders []Reminder
+	for _, id := range sortedKeys(s.reminders) {
+		if match(s.reminders[id]) {
+			reminders = append(reminders, s.reminders[id])
+		}
+	}
+	slices.SortStableFunc(reminders, func(a, b Reminder) int {
+		return a.NextRunAt.Compare(b.NextRunAt)
+	})
+	return reminders
+}
+
+// findGroup looks a group up by its name or alias
+func (s *MemoryStorage) findGroup(name string, chatID int64) (MentionGroup, bool) {
+	for _, group := range s.groups {
+		if group.Name == name && group.ChatID == chatID {
+			return group, true
+		}
+	}
+	for _, alias := range s.aliases {
+		if alias.Name == name && alias.ChatID == chatID {
+			group, ok := s.groups[alias.GroupID]
+			return group, ok
+		}
+	}
+	return MentionGroup{}, false
+}
+
+func (s *MemoryStorage) groupAliases(groupID uint) []GroupAlias {
+	var aliases []GroupAlias
+	for _, id := range sortedKeys(s.aliases) {
+		if s.aliases[id].GroupID == groupID {
+			aliases = append(aliases, s.aliases[id])
+		}
+	}
+	return aliases
+}
+
+// filterGroups returns matching groups ordered by ID like the database does
+func (s *MemoryStorage) filterGroups(match func(MentionGroup) bool) []MentionGroup {
+	var groups []MentionGroup
+	for _, id := range sortedKeys(s.groups) {
+		if match(s.groups[id]) {
+			groups = append(groups, s.groups[id])
+		}
+	}
+	return groups
+}
+
+func (s *MemoryStorage) isMember(groupID uint, userID int64) bool {
+	for _, member := range s.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// groupMembers returns members of a group with their users loaded. Like with a database preload,
+// the user is left empty if it doesn't exist.
+func (s *MemoryStorage) groupMembers(groupID uint) []GroupMember {
+	var members []GroupMember
+	for _, id := range sortedKeys(s.members) {
+		member := s.members[id]
+		if member.GroupID != groupID {
+			continue
+		}
+		member.User = s.users[member.UserID]
+		members = append(members, member)
+	}
+	return members
+}
+
+func sortedKeys[K uint | int64, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
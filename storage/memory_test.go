@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"telegram-group-mention-bot/storage"
+	"telegram-group-mention-bot/storage/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemory()
+	})
+}
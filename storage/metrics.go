@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"telegram-group-mention-bot/metrics"
+
+	"gorm.io/gorm"
+)
+
+const metricsStartKey = "metrics:start"
+
+// registerMetrics times all queries with gorm callbacks. Tables are known in advance, so they are
+// safe to use as labels, raw statements without a table are labeled "none".
+func registerMetrics(db *gorm.DB) error {
+	start := func(tx *gorm.DB) {
+		tx.InstanceSet(metricsStartKey, time.Now())
+	}
+	observe := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			if table == "" {
+				table = "none"
+			}
+			metrics.StorageQueryDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+		}
+	}
+
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("metrics:start_create", start),
+		callback.Create().After("gorm:create").Register("metrics:observe_create", observe("create")),
+		callback.Query().Before("gorm:query").Register("metrics:start_query", start),
+		callback.Query().After("gorm:query").Register("metrics:observe_query", observe("query")),
+		callback.Update().Before("gorm:update").Register("metrics:start_update", start),
+		callback.Update().After("gorm:update").Register("metrics:observe_update", observe("update")),
+		callback.Delete().Before("gorm:delete").Register("metrics:start_delete", start),
+		callback.Delete().After("gorm:delete").Register("metrics:observe_delete", observe("delete")),
+		callback.Row().Before("gorm:row").Register("metrics:start_row", start),
+		callback.Row().After("gorm:row").Register("metrics:observe_row", observe("row")),
+		callback.Raw().Before("gorm:raw").Register("metrics:start_raw", start),
+		callback.Raw().After("gorm:raw").Register("metrics:observe_raw", observe("raw")),
+	)
+}
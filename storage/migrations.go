@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SchemaMigration is a record of an applied migration
+type SchemaMigration struct {
+	Version   int `gorm:"primarykey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus describes a known migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migration is a single schema change. Both directions run inside a transaction.
+//
+// Migrations must never be changed once released and must not use the current models,
+// because those keep evolving. Declare the table structure as of the migration instead.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+// migrations is the ordered list of all schema changes, new ones are appended to the end
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial_schema",
+		up:      migrateInitialSchema,
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("group_members", "mention_groups", "users")
+		},
+	},
+	{
+		version: 2,
+		name:    "command_permissions",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&commandPermissionV2{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("command_permissions")
+		},
+	},
+	{
+		version: 3,
+		name:    "group_aliases",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&groupAliasV3{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("group_aliases")
+		},
+	},
+	{
+		version: 4,
+		name:    "group_inclusions",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&groupInclusionV4{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("group_inclusions")
+		},
+	},
+	{
+		version: 5,
+		name:    "chat_settings",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&chatSettingsV5{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("chat_settings")
+		},
+	},
+	{
+		version: 6,
+		name:    "reminders",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&chatSettingsV6{}, &reminderV6{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("reminders"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&chatSettingsV6{}, "timezone")
+		},
+	},
+	{
+		version: 7,
+		name:    "mention_sender_setting",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&chatSettingsV7{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&chatSettingsV7{}, "mention_sender")
+		},
+	},
+	{
+		version: 8,
+		name:    "chat_feature_settings",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&chatSettingsV8{})
+		},
+		down: func(tx *gorm.DB) error {
+			for _, column := range []string{"auto_all", "free_form", "language"} {
+				if err := tx.Migrator().DropColumn(&chatSettingsV8{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 9,
+		name:    "mention_cooldowns",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&chatSettingsV9{}, &mentionCooldownV9{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("mention_cooldowns"); err != nil {
+				return err
+			}
+			for _, column := range []string{"group_cooldown", "user_cooldown", "cooldown_reply"} {
+				if err := tx.Migrator().DropColumn(&chatSettingsV9{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 10,
+		name:    "chat_users",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&groupMemberV10{}, &chatUserV10{})
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("chat_users"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&groupMemberV10{}, "muted")
+		},
+	},
+	{
+		version: 11,
+		name:    "archived_groups",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&mentionGroupV11{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&mentionGroupV11{}, "archived_at")
+		},
+	},
+}
+
+// MigrateUp applies all pending migrations in order
+func (s *SQLStorage) MigrateUp(ctx context.Context) error {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		slog.Info("storage: Applying migration", "version", m.version, "name", m.name)
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			slog.Error("storage: Failed to apply migration", "error", err, "version", m.version, "name", m.name)
+			return errors.Join(ErrMigrate, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err))
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown rolls back the latest applied migration
+func (s *SQLStorage) MigrateDown(ctx context.Context) error {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+
+		slog.Info("storage: Rolling back migration", "version", m.version, "name", m.name)
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.version).Error
+		})
+		if err != nil {
+			slog.Error("storage: Failed to roll back migration", "error", err, "version", m.version, "name", m.name)
+			return errors.Join(ErrRollback, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err))
+		}
+		return nil
+	}
+
+	return ErrNoMigrations
+}
+
+// MigrationStatus lists all known migrations with the time they were applied at
+func (s *SQLStorage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if record, ok := applied[m.version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// appliedMigrations returns applied migrations by version, creating the migrations table if needed
+func (s *SQLStorage) appliedMigrations(ctx context.Context) (map[int]SchemaMigration, error) {
+	db := s.db.WithContext(ctx)
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		slog.Error("storage: Failed to create migrations table", "error", err)
+		return nil, errors.Join(ErrAutoMigrate, err)
+	}
+
+	var records []SchemaMigration
+	if err := db.Order("version").Find(&records).Error; err != nil {
+		slog.Error("storage: Failed to get applied migrations", "error", err)
+		return nil, errors.Join(ErrGet, err)
+	}
+
+	applied := make(map[int]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// migrateInitialSchema creates the original tables. Databases created before versioned migrations
+// already have them, possibly with legacy columns, so it also cleans those up.
+func migrateInitialSchema(tx *gorm.DB) error {
+	for _, col := range []string{"created_at", "updated_at", "deleted_at"} {
+		for _, table := range []any{&mentionGroupV1{}, &groupMemberV1{}} {
+			if tx.Migrator().HasColumn(table, col) {
+				if err := tx.Migrator().DropColumn(table, col); err != nil {
+					slog.Error("storage: Failed to drop column", "error", err, "table", fmt.Sprintf("%T", table), "column", col)
+					return errors.Join(ErrDropColumn, err)
+				}
+			}
+		}
+	}
+
+	if err := tx.AutoMigrate(&userV1{}, &mentionGroupV1{}, &groupMemberV1{}); err != nil {
+		return errors.Join(ErrAutoMigrate, err)
+	}
+
+	// Early versions kept user data right in group_members
+	if !tx.Migrator().HasColumn(&groupMemberV1{}, "username") {
+		return nil
+	}
+
+	var oldMembers []struct {
+		UserID    int64
+		Username  string
+		FirstName string
+		LastName  string
+	}
+	if err := tx.Table("group_members").Select("user_id", "username", "first_name", "last_name").Scan(&oldMembers).Error; err != nil {
+		return errors.Join(ErrMigrateUserData, err)
+	}
+
+	for _, member := range oldMembers {
+		user := userV1{
+			ID:        member.UserID,
+			Username:  member.Username,
+			FirstName: member.FirstName,
+			LastName:  member.LastName,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			UpdateAll: true,
+		}).Create(&user).Error; err != nil {
+			return errors.Join(ErrMigrateUserData, err)
+		}
+	}
+
+	for _, col := range []string{"username", "first_name", "last_name"} {
+		if err := tx.Migrator().DropColumn(&groupMemberV1{}, col); err != nil {
+			slog.Error("storage: Failed to drop column", "error", err, "table", "group_members", "column", col)
+			return errors.Join(ErrDropColumn, err)
+		}
+	}
+
+	return nil
+}
+
+// Table structures as of the migration which created or changed them last
+
+type userV1 struct {
+	ID        int64 `gorm:"primarykey"`
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+func (userV1) TableName() string { return "users" }
+
+type mentionGroupV1 struct {
+	ID     uint   `gorm:"primarykey"`
+	Name   string `gorm:"uniqueIndex:idx_chat_group"`
+	ChatID int64  `gorm:"uniqueIndex:idx_chat_group"`
+}
+
+func (mentionGroupV1) TableName() string { return "mention_groups" }
+
+type groupMemberV1 struct {
+	ID      uint  `gorm:"primarykey"`
+	GroupID uint  `gorm:"uniqueIndex:idx_group_user"`
+	UserID  int64 `gorm:"uniqueIndex:idx_group_user"`
+}
+
+func (groupMemberV1) TableName() string { return "group_members" }
+
+type commandPermissionV2 struct {
+	ID      uint   `gorm:"primarykey"`
+	ChatID  int64  `gorm:"uniqueIndex:idx_chat_command"`
+	Command string `gorm:"uniqueIndex:idx_chat_command"`
+	Role    string
+}
+
+func (commandPermissionV2) TableName() string { return "command_permissions" }
+
+type groupAliasV3 struct {
+	ID      uint   `gorm:"primarykey"`
+	GroupID uint   `gorm:"index"`
+	Name    string `gorm:"uniqueIndex:idx_chat_alias"`
+	ChatID  int64  `gorm:"uniqueIndex:idx_chat_alias"`
+}
+
+func (groupAliasV3) TableName() string { return "group_aliases" }
+
+type groupInclusionV4 struct {
+	ID       uint `gorm:"primarykey"`
+	ParentID uint `gorm:"uniqueIndex:idx_parent_child"`
+	ChildID  uint `gorm:"uniqueIndex:idx_parent_child;index"`
+}
+
+func (groupInclusionV4) TableName() string { return "group_inclusions" }
+
+type chatSettingsV5 struct {
+	ChatID     int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent string
+}
+
+func (chatSettingsV5) TableName() string { return "chat_settings" }
+
+type chatSettingsV6 struct {
+	ChatID     int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent string
+	Timezone   string
+}
+
+func (chatSettingsV6) TableName() string { return "chat_settings" }
+
+type reminderV6 struct {
+	ID        uint  `gorm:"primarykey"`
+	ChatID    int64 `gorm:"index"`
+	GroupID   uint  `gorm:"index"`
+	ThreadID  int
+	CreatorID int64
+	Text      string
+	Schedule  string
+	NextRunAt time.Time `gorm:"index"`
+}
+
+func (reminderV6) TableName() string { return "reminders" }
+
+type chatSettingsV7 struct {
+	ChatID        int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent    string
+	Timezone      string
+	MentionSender string
+}
+
+func (chatSettingsV7) TableName() string { return "chat_settings" }
+
+type chatSettingsV8 struct {
+	ChatID        int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent    string
+	Timezone      string
+	MentionSender string
+	AutoAll       string
+	FreeForm      string
+	Language      string
+}
+
+func (chatSettingsV8) TableName() string { return "chat_settings" }
+
+type chatSettingsV9 struct {
+	ChatID        int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent    string
+	Timezone      string
+	MentionSender string
+	AutoAll       string
+	FreeForm      string
+	Language      string
+	GroupCooldown string
+	UserCooldown  string
+	CooldownReply string
+}
+
+func (chatSettingsV9) TableName() string { return "chat_settings" }
+
+type mentionCooldownV9 struct {
+	ID            uint  `gorm:"primarykey"`
+	ChatID        int64 `gorm:"uniqueIndex:idx_chat_group_user"`
+	GroupID       uint  `gorm:"uniqueIndex:idx_chat_group_user"`
+	UserID        int64 `gorm:"uniqueIndex:idx_chat_group_user"`
+	LastMentionAt time.Time
+}
+
+func (mentionCooldownV9) TableName() string { return "mention_cooldowns" }
+
+type groupMemberV10 struct {
+	ID      uint  `gorm:"primarykey"`
+	GroupID uint  `gorm:"uniqueIndex:idx_group_user"`
+	UserID  int64 `gorm:"uniqueIndex:idx_group_user"`
+	Muted   bool  `gorm:"not null;default:false"`
+}
+
+func (groupMemberV10) TableName() string { return "group_members" }
+
+type chatUserV10 struct {
+	ID         uint  `gorm:"primarykey"`
+	ChatID     int64 `gorm:"uniqueIndex:idx_chat_user"`
+	UserID     int64 `gorm:"uniqueIndex:idx_chat_user;index"`
+	LastSeenAt time.Time
+}
+
+func (chatUserV10) TableName() string { return "chat_users" }
+
+type mentionGroupV11 struct {
+	ID         uint   `gorm:"primarykey"`
+	Name       string `gorm:"uniqueIndex:idx_chat_group"`
+	ChatID     int64  `gorm:"uniqueIndex:idx_chat_group"`
+	ArchivedAt *time.Time
+}
+
+func (mentionGroupV11) TableName() string { return "mention_groups" }
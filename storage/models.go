@@ -1,5 +1,9 @@
 package storage
 
+import "time"
+
+// Every change of the models below needs a new migration in migrations.go
+
 type User struct {
 	ID        int64 `gorm:"primarykey"`
 	Username  string
@@ -7,17 +11,92 @@ type User struct {
 	LastName  string
 }
 
+// MentionGroup is a named group of users in a chat. Groups are archived when the bot is removed
+// from the chat and restored when it's added back.
 type MentionGroup struct {
-	ID      uint          `gorm:"primarykey"`
-	Name    string        `gorm:"uniqueIndex:idx_chat_group"`
-	ChatID  int64         `gorm:"uniqueIndex:idx_chat_group"`
-	Members []GroupMember `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+	ID         uint   `gorm:"primarykey"`
+	Name       string `gorm:"uniqueIndex:idx_chat_group"`
+	ChatID     int64  `gorm:"uniqueIndex:idx_chat_group"`
+	ArchivedAt *time.Time
+	Members    []GroupMember `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+	Aliases    []GroupAlias  `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+// GroupAlias is an alternative name of a group. Alias and group names share the same namespace in a chat.
+type GroupAlias struct {
+	ID      uint   `gorm:"primarykey"`
+	GroupID uint   `gorm:"index"`
+	Name    string `gorm:"uniqueIndex:idx_chat_alias"`
+	ChatID  int64  `gorm:"uniqueIndex:idx_chat_alias"`
 }
 
+// GroupInclusion makes members of the child group also members of the parent group when mentioned
+type GroupInclusion struct {
+	ID       uint `gorm:"primarykey"`
+	ParentID uint `gorm:"uniqueIndex:idx_parent_child"`
+	ChildID  uint `gorm:"uniqueIndex:idx_parent_child;index"`
+}
+
+// GroupMember is a membership of a user in a group. Muted members stay in the group
+// but are left out when it's mentioned.
 type GroupMember struct {
 	ID           uint         `gorm:"primarykey"`
 	GroupID      uint         `gorm:"uniqueIndex:idx_group_user"`
 	UserID       int64        `gorm:"uniqueIndex:idx_group_user"`
+	Muted        bool         `gorm:"not null;default:false"`
 	User         User         `gorm:"foreignKey:UserID;references:ID"`
 	MentionGroup MentionGroup `gorm:"foreignKey:GroupID;references:ID"`
 }
+
+// ChatUser records that a user was seen in a group chat, so the chat can be managed from the private chat
+type ChatUser struct {
+	ID         uint  `gorm:"primarykey"`
+	ChatID     int64 `gorm:"uniqueIndex:idx_chat_user"`
+	UserID     int64 `gorm:"uniqueIndex:idx_chat_user;index"`
+	LastSeenAt time.Time
+}
+
+// ChatSettings holds per-chat options. Chats without a row use the zero values as defaults.
+type ChatSettings struct {
+	ChatID        int64 `gorm:"primarykey;autoIncrement:false"`
+	AddConsent    string
+	Timezone      string
+	MentionSender string
+	AutoAll       string
+	FreeForm      string
+	Language      string
+	GroupCooldown string
+	UserCooldown  string
+	CooldownReply string
+}
+
+// MentionCooldown keeps the time of the last mention of a group or by a user in a chat.
+// Rows of groups have zero UserID, rows of users have zero GroupID.
+type MentionCooldown struct {
+	ID            uint  `gorm:"primarykey"`
+	ChatID        int64 `gorm:"uniqueIndex:idx_chat_group_user"`
+	GroupID       uint  `gorm:"uniqueIndex:idx_chat_group_user"`
+	UserID        int64 `gorm:"uniqueIndex:idx_chat_group_user"`
+	LastMentionAt time.Time
+}
+
+// Reminder is a scheduled mention of a group. Recurring reminders keep their schedule,
+// one-off reminders have it empty and are deleted once sent. ThreadID is the forum topic
+// the reminder is posted to, zero outside of forums.
+type Reminder struct {
+	ID        uint  `gorm:"primarykey"`
+	ChatID    int64 `gorm:"index"`
+	GroupID   uint  `gorm:"index"`
+	ThreadID  int
+	CreatorID int64
+	Text      string
+	Schedule  string
+	NextRunAt time.Time `gorm:"index"`
+}
+
+type CommandPermission struct {
+	ID      uint   `gorm:"primarykey"`
+	ChatID  int64  `gorm:"uniqueIndex:idx_chat_command"`
+	Command string `gorm:"uniqueIndex:idx_chat_command"`
+	Role    string
+}
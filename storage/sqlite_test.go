@@ -0,0 +1,20 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"telegram-group-mention-bot/storage"
+	"telegram-group-mention-bot/storage/storagetest"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.New(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "data.sqlite"))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return s
+	})
+}
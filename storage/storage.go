@@ -1,12 +1,19 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"log"
 	"log/slog"
+	"os"
+	"strings"
+	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 )
 
 var (
@@ -14,124 +21,215 @@ var (
 	ErrEmptyGroupName = errors.New("group name cannot be empty")
 	ErrZeroUserID     = errors.New("user ID cannot be zero")
 	ErrNilUser        = errors.New("user cannot be nil")
+	ErrEmptyCommand   = errors.New("command cannot be empty")
 
 	// Operation errors
-	ErrNotFound = errors.New("not found")
-	ErrCreate   = errors.New("failed to create")
-	ErrDelete   = errors.New("failed to delete")
-	ErrGet      = errors.New("failed to get")
-	ErrUpdate   = errors.New("failed to update")
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrCreate        = errors.New("failed to create")
+	ErrDelete        = errors.New("failed to delete")
+	ErrGet           = errors.New("failed to get")
+	ErrUpdate        = errors.New("failed to update")
 
 	// Migration errors
 	ErrDropColumn      = errors.New("failed to drop column")
 	ErrAutoMigrate     = errors.New("failed to auto migrate schema")
 	ErrMigrateUserData = errors.New("failed to migrate user data")
+	ErrMigrate         = errors.New("failed to apply migration")
+	ErrRollback        = errors.New("failed to roll back migration")
+	ErrNoMigrations    = errors.New("no applied migrations")
 	ErrConnectDB       = errors.New("failed to connect to database")
+	ErrUnsupportedDSN  = errors.New("unsupported database URL scheme")
+	ErrCloseDB         = errors.New("failed to close database")
+	ErrPingDB          = errors.New("failed to ping database")
 )
 
-type Storage struct {
+// Storage is everything the bot needs to persist
+type Storage interface {
+	// Close releases the underlying resources
+	Close() error
+	// Ping checks that the storage is reachable
+	Ping(ctx context.Context) error
+	// Transaction runs fn with a storage whose changes are kept only if fn returns nil.
+	// The storage passed to fn must not be used after fn returns.
+	Transaction(ctx context.Context, fn func(tx Storage) error) error
+
+	CreateGroup(ctx context.Context, name string, chatID int64) error
+	GetGroup(ctx context.Context, name string, chatID int64) (*MentionGroup, error)
+	GetGroupByID(ctx context.Context, groupID uint) (*MentionGroup, error)
+	DeleteGroup(ctx context.Context, groupID uint) error
+	GetGroupsByChat(ctx context.Context, chatID int64) ([]MentionGroup, error)
+	GetGroupsToJoinByChatAndUser(ctx context.Context, chatID int64, userID int64) ([]MentionGroup, error)
+	GetUserGroupsByChat(ctx context.Context, chatID int64, userID int64) ([]MentionGroup, error)
+	GetUserChatIDs(ctx context.Context, userID int64) ([]int64, error)
+	FindGroupsByChatAndNamesWithMembers(ctx context.Context, chatID int64, names []string) ([]MentionGroup, error)
+	MigrateChatGroups(ctx context.Context, fromChatID, toChatID int64) error
+	// Archived groups are kept as they are, but they are not offered across chats and their reminders don't run
+	ArchiveChatGroups(ctx context.Context, chatID int64, at time.Time) error
+	RestoreChatGroups(ctx context.Context, chatID int64) error
+
+	CreateAlias(ctx context.Context, groupID uint, chatID int64, name string) error
+	DeleteAlias(ctx context.Context, chatID int64, name string) error
+
+	IncludeGroup(ctx context.Context, parentID, childID uint) error
+	ExcludeGroup(ctx context.Context, parentID, childID uint) error
+	GetIncludedGroups(ctx context.Context, groupID uint) ([]MentionGroup, error)
+
+	GetUser(ctx context.Context, userID int64) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetChatUserByUsername(ctx context.Context, chatID int64, username string) (*User, error)
+	CreateOrUpdateUser(ctx context.Context, userID int64, username, firstName, lastName string) (*User, error)
+
+	AddMember(ctx context.Context, groupID uint, user *User) error
+	RemoveMember(ctx context.Context, groupID uint, userID int64) error
+	GetGroupMembers(ctx context.Context, groupID uint) ([]GroupMember, error)
+	IsMember(ctx context.Context, groupID uint, userID int64) (bool, error)
+	SetMemberMuted(ctx context.Context, groupID uint, userID int64, muted bool) error
+	// RemoveChatMember removes the user from all groups of the chat and forgets the user was seen there
+	RemoveChatMember(ctx context.Context, chatID int64, userID int64) error
+
+	// Chat users are users seen in group chats, the bot can't list members of a chat by itself
+	SaveChatUser(ctx context.Context, chatID int64, userID int64, at time.Time) error
+	GetUserSeenChatIDs(ctx context.Context, userID int64) ([]int64, error)
+
+	GetCommandPermissions(ctx context.Context, chatID int64) ([]CommandPermission, error)
+	SetCommandPermission(ctx context.Context, chatID int64, command, role string) error
+
+	GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error)
+	SaveChatSettings(ctx context.Context, settings *ChatSettings) error
+
+	CreateReminder(ctx context.Context, reminder *Reminder) error
+	GetRemindersByChat(ctx context.Context, chatID int64) ([]Reminder, error)
+	GetDueReminders(ctx context.Context, until time.Time) ([]Reminder, error)
+	UpdateReminderNextRun(ctx context.Context, reminderID uint, nextRunAt time.Time) error
+	DeleteReminder(ctx context.Context, chatID int64, reminderID uint) error
+
+	// Last mentions are tracked for groups with zero userID and for users with zero groupID
+	GetLastMention(ctx context.Context, chatID int64, groupID uint, userID int64) (time.Time, error)
+	SaveLastMention(ctx context.Context, chatID int64, groupID uint, userID int64, at time.Time) error
+}
+
+// SQLStorage is the Storage implementation backed by an SQL database
+type SQLStorage struct {
 	db *gorm.DB
 }
 
-func New(dbPath string) (*Storage, error) {
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+var _ Storage = (*SQLStorage)(nil)
+
+// New connects to the database and applies pending schema migrations
+func New(ctx context.Context, dsn string) (*SQLStorage, error) {
+	s, err := Open(dsn)
 	if err != nil {
-		slog.Error("storage: Failed to connect to database", "error", err, "path", dbPath)
-		return nil, errors.Join(ErrConnectDB, err)
+		return nil, err
 	}
 
-	s := &Storage{db: db}
-	if err := s.migrate(); err != nil {
+	if err := s.MigrateUp(ctx); err != nil {
+		_ = s.Close()
 		return nil, err
 	}
 
 	return s, nil
 }
 
-func (s *Storage) migrate() error {
-	for _, col := range []string{"created_at", "updated_at", "deleted_at"} {
-		if s.db.Migrator().HasColumn(&MentionGroup{}, col) {
-			err := s.db.Migrator().DropColumn(&MentionGroup{}, col)
-			if err != nil {
-				slog.Error("storage: Failed to drop column from MentionGroup", "error", err, "column", col)
-				return errors.Join(ErrDropColumn, err)
-			}
-		}
-
-		if s.db.Migrator().HasColumn(&GroupMember{}, col) {
-			err := s.db.Migrator().DropColumn(&GroupMember{}, col)
-			if err != nil {
-				slog.Error("storage: Failed to drop column from GroupMember", "error", err, "column", col)
-				return errors.Join(ErrDropColumn, err)
-			}
-		}
+// Open connects to the database without touching its schema. The DSN is either a URL with a
+// "postgres://", "postgresql://" or "sqlite://" scheme or a plain path to an SQLite file.
+func Open(dsn string) (*SQLStorage, error) {
+	dialector, err := openDialector(dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	// Auto migrate the schema
-	err := s.db.AutoMigrate(&User{}, &MentionGroup{}, &GroupMember{})
+	db, err := gorm.Open(dialector, &gorm.Config{
+		// Makes unique constraint violations detectable as gorm.ErrDuplicatedKey
+		TranslateError: true,
+		// Failures are logged with slog already, stdout is left for the output of admin commands
+		Logger: logger.New(log.New(os.Stderr, "", log.LstdFlags), logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+		}),
+	})
 	if err != nil {
-		slog.Error("storage: Failed to migrate database", "error", err)
-		return errors.Join(ErrAutoMigrate, err)
+		slog.Error("storage: Failed to connect to database", "error", err, "driver", dialector.Name())
+		return nil, errors.Join(ErrConnectDB, err)
 	}
+	slog.Debug("storage: Connected to database", "driver", dialector.Name())
 
-	if err := s.migrateUserData(); err != nil {
-		return errors.Join(ErrMigrateUserData, err)
+	if err := registerMetrics(db); err != nil {
+		slog.Error("storage: Failed to register metrics callbacks", "error", err)
+		return nil, errors.Join(ErrConnectDB, err)
 	}
 
-	return nil
+	return &SQLStorage{db: db}, nil
 }
 
-// TODO: This function can be removed in a future release after all users have migrated to the new schema.
-func (s *Storage) migrateUserData() error {
-	// Check if old columns still exist
-	if !s.db.Migrator().HasColumn(&GroupMember{}, "username") {
-		return nil // Migration already completed
+// openDialector picks the database driver by the DSN scheme
+func openDialector(dsn string) (gorm.Dialector, error) {
+	scheme, rest, found := strings.Cut(dsn, "://")
+	if !found {
+		return sqlite.Open(sqliteDSN(dsn)), nil
 	}
 
-	// Get all existing group members with their user info
-	var oldMembers []struct {
-		ID        uint
-		GroupID   uint
-		UserID    int64
-		Username  string
-		FirstName string
-		LastName  string
-	}
-	if err := s.db.Raw("SELECT id, group_id, user_id, username, first_name, last_name FROM group_members").Scan(&oldMembers).Error; err != nil {
-		return errors.Join(ErrMigrateUserData, err)
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return postgres.Open(dsn), nil
+	case "sqlite", "sqlite3":
+		return sqlite.Open(sqliteDSN(rest)), nil
+	default:
+		slog.Error("storage: Unsupported database URL scheme", "scheme", scheme)
+		return nil, ErrUnsupportedDSN
 	}
+}
 
-	for _, member := range oldMembers {
-		user := User{
-			ID:        member.UserID,
-			Username:  member.Username,
-			FirstName: member.FirstName,
-			LastName:  member.LastName,
-		}
-		if err := s.db.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "id"}},
-			UpdateAll: true,
-		}).Create(&user).Error; err != nil {
-			return errors.Join(ErrMigrateUserData, err)
-		}
+// sqliteDSN makes transactions take the write lock when they begin. Otherwise a transaction which has read
+// something fails at once with "database is locked" when it writes while another one is open, instead of
+// waiting for it within the busy timeout. Options given in the DSN are kept.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_txlock=") {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
 	}
+	return dsn + separator + "_txlock=immediate"
+}
 
-	// Remove unused columns from GroupMember after data migration
-	for _, col := range []string{"username", "first_name", "last_name"} {
-		if s.db.Migrator().HasColumn(&GroupMember{}, col) {
-			err := s.db.Migrator().DropColumn(&GroupMember{}, col)
-			if err != nil {
-				slog.Error("storage: Failed to drop column from GroupMember", "error", err, "column", col)
-				return errors.Join(ErrDropColumn, err)
-			}
-		}
+// Close closes the underlying database connection
+func (s *SQLStorage) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return errors.Join(ErrCloseDB, err)
 	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("storage: Failed to close database", "error", err)
+		return errors.Join(ErrCloseDB, err)
+	}
+	return nil
+}
 
+// Ping checks the connection to the database
+func (s *SQLStorage) Ping(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return errors.Join(ErrPingDB, err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return errors.Join(ErrPingDB, err)
+	}
 	return nil
 }
 
+// Transaction runs fn with a storage bound to a database transaction. Nested transactions,
+// like the one of CreateGroup, become savepoints.
+func (s *SQLStorage) Transaction(ctx context.Context, fn func(tx Storage) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLStorage{db: tx})
+	})
+}
+
 // CreateGroup creates a new mention group in a chat
-func (s *Storage) CreateGroup(name string, chatID int64) error {
+func (s *SQLStorage) CreateGroup(ctx context.Context, name string, chatID int64) error {
 	if name == "" {
 		return ErrEmptyGroupName
 	}
@@ -141,18 +239,32 @@ func (s *Storage) CreateGroup(name string, chatID int64) error {
 		ChatID: chatID,
 	}
 
-	result := s.db.Create(&group)
-	if result.Error != nil {
-		slog.Error("storage: Failed to create group", "error", result.Error, "name", name, "chat_id", chatID)
-		return errors.Join(ErrCreate, result.Error)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var aliases int64
+		if err := tx.Model(&GroupAlias{}).Where("chat_id = ? AND name = ?", chatID, name).Count(&aliases).Error; err != nil {
+			return err
+		}
+		if aliases > 0 {
+			return ErrAlreadyExists
+		}
+		return tx.Create(&group).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlreadyExists) || errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errors.Join(ErrCreate, ErrAlreadyExists, err)
+		}
+		slog.Error("storage: Failed to create group", "error", err, "name", name, "chat_id", chatID)
+		return errors.Join(ErrCreate, err)
 	}
 	return nil
 }
 
-// GetGroup retrieves a group by name and chat ID
-func (s *Storage) GetGroup(name string, chatID int64) (*MentionGroup, error) {
+// GetGroup retrieves a group by its name or alias and chat ID
+func (s *SQLStorage) GetGroup(ctx context.Context, name string, chatID int64) (*MentionGroup, error) {
 	var group MentionGroup
-	result := s.db.Where("name = ? AND chat_id = ?", name, chatID).First(&group)
+	db := s.db.WithContext(ctx)
+	aliasGroupIDs := db.Model(&GroupAlias{}).Select("group_id").Where("chat_id = ? AND name = ?", chatID, name)
+	result := db.Where("chat_id = ? AND (name = ? OR id IN (?))", chatID, name, aliasGroupIDs).First(&group)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, errors.Join(ErrNotFound, result.Error)
@@ -163,10 +275,24 @@ func (s *Storage) GetGroup(name string, chatID int64) (*MentionGroup, error) {
 	return &group, nil
 }
 
+// GetGroupByID retrieves a group by its ID
+func (s *SQLStorage) GetGroupByID(ctx context.Context, groupID uint) (*MentionGroup, error) {
+	var group MentionGroup
+	result := s.db.WithContext(ctx).First(&group, groupID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.Join(ErrNotFound, result.Error)
+		}
+		slog.Error("storage: Failed to get group by ID", "error", result.Error, "group_id", groupID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return &group, nil
+}
+
 // GetUser retrieves a user by ID
-func (s *Storage) GetUser(userID int64) (*User, error) {
+func (s *SQLStorage) GetUser(ctx context.Context, userID int64) (*User, error) {
 	var user User
-	result := s.db.First(&user, userID)
+	result := s.db.WithContext(ctx).First(&user, userID)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, errors.Join(ErrNotFound, result.Error)
@@ -177,8 +303,47 @@ func (s *Storage) GetUser(userID int64) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByUsername retrieves a user by username ignoring its case
+func (s *SQLStorage) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	var user User
+	result := s.db.WithContext(ctx).Where("LOWER(username) = LOWER(?)", username).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.Join(ErrNotFound, result.Error)
+		}
+		slog.Error("storage: Failed to get user by username", "error", result.Error, "username", username)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return &user, nil
+}
+
+// GetChatUserByUsername retrieves a user by username ignoring its case among users seen in the chat or
+// members of its groups. Usernames can be stale, so if several users have it, the one seen in the chat
+// most recently is returned.
+func (s *SQLStorage) GetChatUserByUsername(ctx context.Context, chatID int64, username string) (*User, error) {
+	chatGroupIDs := s.db.Model(&MentionGroup{}).Select("id").Where("chat_id = ?", chatID)
+	chatMemberIDs := s.db.Model(&GroupMember{}).Select("user_id").Where("group_id IN (?)", chatGroupIDs)
+
+	var user User
+	result := s.db.WithContext(ctx).
+		Joins("LEFT JOIN chat_users ON chat_users.user_id = users.id AND chat_users.chat_id = ?", chatID).
+		Where("LOWER(users.username) = LOWER(?)", username).
+		Where("chat_users.id IS NOT NULL OR users.id IN (?)", chatMemberIDs).
+		// Databases sort NULLs differently, so users never seen in the chat are put last explicitly
+		Order("CASE WHEN chat_users.last_seen_at IS NULL THEN 1 ELSE 0 END, chat_users.last_seen_at DESC, users.id").
+		First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.Join(ErrNotFound, result.Error)
+		}
+		slog.Error("storage: Failed to get chat user by username", "error", result.Error, "chat_id", chatID, "username", username)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return &user, nil
+}
+
 // CreateOrUpdateUser creates a new user or updates an existing one
-func (s *Storage) CreateOrUpdateUser(userID int64, username, firstName, lastName string) (*User, error) {
+func (s *SQLStorage) CreateOrUpdateUser(ctx context.Context, userID int64, username, firstName, lastName string) (*User, error) {
 	if userID == 0 {
 		return nil, ErrZeroUserID
 	}
@@ -189,7 +354,7 @@ func (s *Storage) CreateOrUpdateUser(userID int64, username, firstName, lastName
 		FirstName: firstName,
 		LastName:  lastName,
 	}
-	if err := s.db.Clauses(clause.OnConflict{
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
 		UpdateAll: true,
 	}).Create(&user).Error; err != nil {
@@ -199,7 +364,7 @@ func (s *Storage) CreateOrUpdateUser(userID int64, username, firstName, lastName
 	return &user, nil
 }
 
-func (s *Storage) AddMember(groupID uint, user *User) error {
+func (s *SQLStorage) AddMember(ctx context.Context, groupID uint, user *User) error {
 	if user == nil {
 		return ErrNilUser
 	}
@@ -209,8 +374,11 @@ func (s *Storage) AddMember(groupID uint, user *User) error {
 		UserID:  user.ID,
 	}
 
-	result := s.db.Create(&member)
+	result := s.db.WithContext(ctx).Create(&member)
 	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return errors.Join(ErrCreate, ErrAlreadyExists, result.Error)
+		}
 		slog.Error("storage: Failed to add member", "error", result.Error, "group_id", groupID, "user_id", user.ID, "username", user.Username)
 		return errors.Join(ErrCreate, result.Error)
 	}
@@ -218,8 +386,8 @@ func (s *Storage) AddMember(groupID uint, user *User) error {
 }
 
 // RemoveMember removes a user from a mention group
-func (s *Storage) RemoveMember(groupID uint, userID int64) error {
-	result := s.db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&GroupMember{})
+func (s *SQLStorage) RemoveMember(ctx context.Context, groupID uint, userID int64) error {
+	result := s.db.WithContext(ctx).Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&GroupMember{})
 	if result.Error != nil {
 		slog.Error("storage: Failed to remove member", "error", result.Error,
 			"group_id", groupID, "user_id", userID)
@@ -229,9 +397,9 @@ func (s *Storage) RemoveMember(groupID uint, userID int64) error {
 }
 
 // GetGroupMembers retrieves all members of a group
-func (s *Storage) GetGroupMembers(groupID uint) ([]GroupMember, error) {
+func (s *SQLStorage) GetGroupMembers(ctx context.Context, groupID uint) ([]GroupMember, error) {
 	var members []GroupMember
-	result := s.db.Preload("User").Where("group_id = ?", groupID).Find(&members)
+	result := s.db.WithContext(ctx).Preload("User").Where("group_id = ?", groupID).Find(&members)
 	if result.Error != nil {
 		slog.Error("storage: Failed to get group members", "error", result.Error, "group_id", groupID)
 		return nil, errors.Join(ErrGet, result.Error)
@@ -239,19 +407,37 @@ func (s *Storage) GetGroupMembers(groupID uint) ([]GroupMember, error) {
 	return members, nil
 }
 
-// DeleteGroup deletes a group by ID
-func (s *Storage) DeleteGroup(groupID uint) error {
-	result := s.db.Delete(&MentionGroup{}, groupID)
-	if result.Error != nil {
-		slog.Error("storage: Failed to delete group", "error", result.Error, "group_id", groupID)
-		return errors.Join(ErrDelete, result.Error)
+// DeleteGroup deletes a group by ID together with its memberships, aliases, inclusions, reminders and cooldowns
+func (s *SQLStorage) DeleteGroup(ctx context.Context, groupID uint) error {
+	// SQLite doesn't enforce foreign keys by default, so dependent rows are removed explicitly
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", groupID).Delete(&GroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", groupID).Delete(&GroupAlias{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("parent_id = ? OR child_id = ?", groupID, groupID).Delete(&GroupInclusion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", groupID).Delete(&Reminder{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", groupID).Delete(&MentionCooldown{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&MentionGroup{}, groupID).Error
+	})
+	if err != nil {
+		slog.Error("storage: Failed to delete group", "error", err, "group_id", groupID)
+		return errors.Join(ErrDelete, err)
 	}
 	return nil
 }
 
-func (s *Storage) GetGroupsByChat(chatID int64) ([]MentionGroup, error) {
+func (s *SQLStorage) GetGroupsByChat(ctx context.Context, chatID int64) ([]MentionGroup, error) {
 	var groups []MentionGroup
-	result := s.db.Where("chat_id = ?", chatID).Find(&groups)
+	result := s.db.WithContext(ctx).Where("chat_id = ?", chatID).Preload("Aliases").Find(&groups)
 	if result.Error != nil {
 		slog.Error("storage: Failed to get groups", "error", result.Error, "chat_id", chatID)
 		return nil, errors.Join(ErrGet, result.Error)
@@ -260,9 +446,9 @@ func (s *Storage) GetGroupsByChat(chatID int64) ([]MentionGroup, error) {
 }
 
 // IsMember checks if a user is a member of a group
-func (s *Storage) IsMember(groupID uint, userID int64) (bool, error) {
+func (s *SQLStorage) IsMember(ctx context.Context, groupID uint, userID int64) (bool, error) {
 	var count int64
-	result := s.db.Model(&GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count)
+	result := s.db.WithContext(ctx).Model(&GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count)
 	if result.Error != nil {
 		slog.Error("storage: Failed to check membership", "error", result.Error,
 			"group_id", groupID, "user_id", userID)
@@ -271,9 +457,40 @@ func (s *Storage) IsMember(groupID uint, userID int64) (bool, error) {
 	return count > 0, nil
 }
 
-func (s *Storage) GetGroupsToJoinByChatAndUser(chatID int64, userID int64) ([]MentionGroup, error) {
+// SetMemberMuted mutes or unmutes mentions of a member in a group
+func (s *SQLStorage) SetMemberMuted(ctx context.Context, groupID uint, userID int64, muted bool) error {
+	result := s.db.WithContext(ctx).Model(&GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Update("muted", muted)
+	if result.Error != nil {
+		slog.Error("storage: Failed to update member", "error", result.Error, "group_id", groupID, "user_id", userID, "muted", muted)
+		return errors.Join(ErrUpdate, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RemoveChatMember removes the user from all groups of the chat and forgets the user was seen there
+func (s *SQLStorage) RemoveChatMember(ctx context.Context, chatID int64, userID int64) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		chatGroupIDs := tx.Model(&MentionGroup{}).Select("id").Where("chat_id = ?", chatID)
+		if err := tx.Where("user_id = ? AND group_id IN (?)", userID, chatGroupIDs).Delete(&GroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("chat_id = ? AND user_id = ?", chatID, userID).Delete(&ChatUser{}).Error
+	})
+	if err != nil {
+		slog.Error("storage: Failed to remove chat member", "error", err, "chat_id", chatID, "user_id", userID)
+		return errors.Join(ErrDelete, err)
+	}
+	return nil
+}
+
+func (s *SQLStorage) GetGroupsToJoinByChatAndUser(ctx context.Context, chatID int64, userID int64) ([]MentionGroup, error) {
 	var groups []MentionGroup
-	result := s.db.Where("chat_id = ? AND id NOT IN (SELECT group_id FROM group_members WHERE user_id = ?)", chatID, userID).Find(&groups)
+	db := s.db.WithContext(ctx)
+	userGroupIDs := db.Model(&GroupMember{}).Select("group_id").Where("user_id = ?", userID)
+	result := db.Where("chat_id = ? AND id NOT IN (?)", chatID, userGroupIDs).Find(&groups)
 	if result.Error != nil {
 		slog.Error("storage: Failed to get groups to join", "error", result.Error,
 			"chat_id", chatID, "user_id", userID)
@@ -282,9 +499,11 @@ func (s *Storage) GetGroupsToJoinByChatAndUser(chatID int64, userID int64) ([]Me
 	return groups, nil
 }
 
-func (s *Storage) GetUserGroupsByChat(chatID int64, userID int64) ([]MentionGroup, error) {
+func (s *SQLStorage) GetUserGroupsByChat(ctx context.Context, chatID int64, userID int64) ([]MentionGroup, error) {
 	var groups []MentionGroup
-	result := s.db.Where("chat_id = ? AND id IN (SELECT group_id FROM group_members WHERE user_id = ?)", chatID, userID).Find(&groups)
+	db := s.db.WithContext(ctx)
+	userGroupIDs := db.Model(&GroupMember{}).Select("group_id").Where("user_id = ?", userID)
+	result := db.Where("chat_id = ? AND id IN (?)", chatID, userGroupIDs).Find(&groups)
 	if result.Error != nil {
 		slog.Error("storage: Failed to get user's groups", "error", result.Error,
 			"chat_id", chatID, "user_id", userID)
@@ -293,13 +512,28 @@ func (s *Storage) GetUserGroupsByChat(chatID int64, userID int64) ([]MentionGrou
 	return groups, nil
 }
 
-func (s *Storage) FindGroupsByChatAndNamesWithMembers(chatID int64, names []string) ([]MentionGroup, error) {
+// GetUserChatIDs retrieves IDs of chats where the user is a member of at least one group which is not archived
+func (s *SQLStorage) GetUserChatIDs(ctx context.Context, userID int64) ([]int64, error) {
+	var chatIDs []int64
+	db := s.db.WithContext(ctx)
+	userGroupIDs := db.Model(&GroupMember{}).Select("group_id").Where("user_id = ?", userID)
+	result := db.Model(&MentionGroup{}).Distinct("chat_id").Where("id IN (?) AND archived_at IS NULL", userGroupIDs).Order("chat_id").Pluck("chat_id", &chatIDs)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get user's chats", "error", result.Error, "user_id", userID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return chatIDs, nil
+}
+
+func (s *SQLStorage) FindGroupsByChatAndNamesWithMembers(ctx context.Context, chatID int64, names []string) ([]MentionGroup, error) {
 	if len(names) == 0 {
 		return nil, nil
 	}
 
 	var groups []MentionGroup
-	result := s.db.Where("chat_id = ? AND name IN ?", chatID, names).Preload("Members.User").Find(&groups)
+	db := s.db.WithContext(ctx)
+	aliasGroupIDs := db.Model(&GroupAlias{}).Select("group_id").Where("chat_id = ? AND name IN ?", chatID, names)
+	result := db.Where("chat_id = ? AND (name IN ? OR id IN (?))", chatID, names, aliasGroupIDs).Preload("Members.User").Find(&groups)
 	if result.Error != nil {
 		slog.Error("storage: Failed to find groups", "error", result.Error, "chat_id", chatID, "names", names)
 		return nil, errors.Join(ErrGet, result.Error)
@@ -307,13 +541,353 @@ func (s *Storage) FindGroupsByChatAndNamesWithMembers(chatID int64, names []stri
 	return groups, nil
 }
 
-func (s *Storage) MigrateChatGroups(fromChatID, toChatID int64) error {
-	result := s.db.Model(&MentionGroup{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID)
-	if result.Error != nil {
-		slog.Error("storage: Failed to migrate chat groups", "error", result.Error,
+// MigrateChatGroups moves groups, their aliases, reminders and cooldowns, chat policy, settings and seen users
+// to the new chat ID when a group becomes a supergroup. The groups are restored if the old chat archived them.
+// It fails with ErrAlreadyExists without moving anything if a name of a group or alias is taken in the new chat.
+func (s *SQLStorage) MigrateChatGroups(ctx context.Context, fromChatID, toChatID int64) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Group names and aliases share one namespace in a chat, the unique indexes only cover each table
+		var taken, takenAliases []string
+		if err := tx.Model(&MentionGroup{}).Where("chat_id = ?", toChatID).Pluck("name", &taken).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&GroupAlias{}).Where("chat_id = ?", toChatID).Pluck("name", &takenAliases).Error; err != nil {
+			return err
+		}
+		if taken = append(taken, takenAliases...); len(taken) > 0 {
+			var groups, aliases int64
+			if err := tx.Model(&MentionGroup{}).Where("chat_id = ? AND name IN ?", fromChatID, taken).Count(&groups).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&GroupAlias{}).Where("chat_id = ? AND name IN ?", fromChatID, taken).Count(&aliases).Error; err != nil {
+				return err
+			}
+			if groups > 0 || aliases > 0 {
+				return ErrAlreadyExists
+			}
+		}
+
+		if err := tx.Model(&MentionGroup{}).Where("chat_id = ?", fromChatID).Updates(map[string]any{"chat_id": toChatID, "archived_at": nil}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&GroupAlias{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ChatSettings{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Reminder{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&MentionCooldown{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error; err != nil {
+			return err
+		}
+		// Users already seen in the new chat keep their newer row
+		seenInNewChat := tx.Model(&ChatUser{}).Select("user_id").Where("chat_id = ?", toChatID)
+		if err := tx.Where("chat_id = ? AND user_id IN (?)", fromChatID, seenInNewChat).Delete(&ChatUser{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ChatUser{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&CommandPermission{}).Where("chat_id = ?", fromChatID).Update("chat_id", toChatID).Error
+	})
+	if err != nil {
+		slog.Error("storage: Failed to migrate chat groups", "error", err,
 			"from_chat_id", fromChatID, "to_chat_id", toChatID)
-		return errors.Join(ErrUpdate, result.Error)
+		if errors.Is(err, ErrAlreadyExists) || errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errors.Join(ErrUpdate, ErrAlreadyExists, err)
+		}
+		return errors.Join(ErrUpdate, err)
 	}
 	slog.Info("storage: Migrated chat groups", "from_chat_id", fromChatID, "to_chat_id", toChatID)
 	return nil
 }
+
+// ArchiveChatGroups archives all groups of the chat which are not archived yet
+func (s *SQLStorage) ArchiveChatGroups(ctx context.Context, chatID int64, at time.Time) error {
+	result := s.db.WithContext(ctx).Model(&MentionGroup{}).Where("chat_id = ? AND archived_at IS NULL", chatID).Update("archived_at", at.UTC())
+	if result.Error != nil {
+		slog.Error("storage: Failed to archive chat groups", "error", result.Error, "chat_id", chatID)
+		return errors.Join(ErrUpdate, result.Error)
+	}
+	slog.Info("storage: Archived chat groups", "chat_id", chatID, "group_count", result.RowsAffected)
+	return nil
+}
+
+// RestoreChatGroups restores archived groups of the chat
+func (s *SQLStorage) RestoreChatGroups(ctx context.Context, chatID int64) error {
+	result := s.db.WithContext(ctx).Model(&MentionGroup{}).Where("chat_id = ? AND archived_at IS NOT NULL", chatID).Update("archived_at", nil)
+	if result.Error != nil {
+		slog.Error("storage: Failed to restore chat groups", "error", result.Error, "chat_id", chatID)
+		return errors.Join(ErrUpdate, result.Error)
+	}
+	slog.Info("storage: Restored chat groups", "chat_id", chatID, "group_count", result.RowsAffected)
+	return nil
+}
+
+// CreateAlias adds an alternative name to a group. It fails with ErrAlreadyExists if the name
+// is already taken by a group or another alias in the chat.
+func (s *SQLStorage) CreateAlias(ctx context.Context, groupID uint, chatID int64, name string) error {
+	if name == "" {
+		return ErrEmptyGroupName
+	}
+
+	alias := GroupAlias{
+		GroupID: groupID,
+		Name:    name,
+		ChatID:  chatID,
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var groups int64
+		if err := tx.Model(&MentionGroup{}).Where("chat_id = ? AND name = ?", chatID, name).Count(&groups).Error; err != nil {
+			return err
+		}
+		if groups > 0 {
+			return ErrAlreadyExists
+		}
+		return tx.Create(&alias).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlreadyExists) || errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errors.Join(ErrCreate, ErrAlreadyExists, err)
+		}
+		slog.Error("storage: Failed to create alias", "error", err, "group_id", groupID, "name", name, "chat_id", chatID)
+		return errors.Join(ErrCreate, err)
+	}
+	return nil
+}
+
+// DeleteAlias removes a group alias by its name
+func (s *SQLStorage) DeleteAlias(ctx context.Context, chatID int64, name string) error {
+	result := s.db.WithContext(ctx).Where("chat_id = ? AND name = ?", chatID, name).Delete(&GroupAlias{})
+	if result.Error != nil {
+		slog.Error("storage: Failed to delete alias", "error", result.Error, "name", name, "chat_id", chatID)
+		return errors.Join(ErrDelete, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IncludeGroup makes the child group a part of the parent group. Cycles are not checked here.
+func (s *SQLStorage) IncludeGroup(ctx context.Context, parentID, childID uint) error {
+	inclusion := GroupInclusion{
+		ParentID: parentID,
+		ChildID:  childID,
+	}
+
+	result := s.db.WithContext(ctx).Create(&inclusion)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return errors.Join(ErrCreate, ErrAlreadyExists, result.Error)
+		}
+		slog.Error("storage: Failed to include group", "error", result.Error, "parent_id", parentID, "child_id", childID)
+		return errors.Join(ErrCreate, result.Error)
+	}
+	return nil
+}
+
+// ExcludeGroup removes the child group from the parent group
+func (s *SQLStorage) ExcludeGroup(ctx context.Context, parentID, childID uint) error {
+	result := s.db.WithContext(ctx).Where("parent_id = ? AND child_id = ?", parentID, childID).Delete(&GroupInclusion{})
+	if result.Error != nil {
+		slog.Error("storage: Failed to exclude group", "error", result.Error, "parent_id", parentID, "child_id", childID)
+		return errors.Join(ErrDelete, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetIncludedGroups retrieves groups directly included by a group
+func (s *SQLStorage) GetIncludedGroups(ctx context.Context, groupID uint) ([]MentionGroup, error) {
+	var groups []MentionGroup
+	db := s.db.WithContext(ctx)
+	childIDs := db.Model(&GroupInclusion{}).Select("child_id").Where("parent_id = ?", groupID)
+	result := db.Where("id IN (?)", childIDs).Order("id").Find(&groups)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get included groups", "error", result.Error, "group_id", groupID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return groups, nil
+}
+
+// GetCommandPermissions retrieves all command permission overrides of a chat
+func (s *SQLStorage) GetCommandPermissions(ctx context.Context, chatID int64) ([]CommandPermission, error) {
+	var permissions []CommandPermission
+	result := s.db.WithContext(ctx).Where("chat_id = ?", chatID).Find(&permissions)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get command permissions", "error", result.Error, "chat_id", chatID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return permissions, nil
+}
+
+// SetCommandPermission sets the role required to use a command in a chat
+func (s *SQLStorage) SetCommandPermission(ctx context.Context, chatID int64, command, role string) error {
+	if command == "" {
+		return ErrEmptyCommand
+	}
+
+	permission := CommandPermission{
+		ChatID:  chatID,
+		Command: command,
+		Role:    role,
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "command"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role"}),
+	}).Create(&permission).Error; err != nil {
+		slog.Error("storage: Failed to set command permission", "error", err, "chat_id", chatID, "command", command, "role", role)
+		return errors.Join(ErrUpdate, err)
+	}
+	return nil
+}
+
+// GetChatSettings retrieves settings of a chat, chats which were never configured get the defaults
+func (s *SQLStorage) GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
+	var settings ChatSettings
+	result := s.db.WithContext(ctx).Where("chat_id = ?", chatID).Limit(1).Find(&settings)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get chat settings", "error", result.Error, "chat_id", chatID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	settings.ChatID = chatID
+	return &settings, nil
+}
+
+// SaveChatSettings creates or replaces settings of a chat
+func (s *SQLStorage) SaveChatSettings(ctx context.Context, settings *ChatSettings) error {
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		UpdateAll: true,
+	}).Create(settings).Error; err != nil {
+		slog.Error("storage: Failed to save chat settings", "error", err, "chat_id", settings.ChatID)
+		return errors.Join(ErrUpdate, err)
+	}
+	return nil
+}
+
+// CreateReminder saves a new reminder and sets its ID
+func (s *SQLStorage) CreateReminder(ctx context.Context, reminder *Reminder) error {
+	// SQLite compares times as text, so all of them are kept in UTC
+	reminder.NextRunAt = reminder.NextRunAt.UTC()
+	if err := s.db.WithContext(ctx).Create(reminder).Error; err != nil {
+		slog.Error("storage: Failed to create reminder", "error", err, "chat_id", reminder.ChatID, "group_id", reminder.GroupID)
+		return errors.Join(ErrCreate, err)
+	}
+	return nil
+}
+
+// GetRemindersByChat retrieves all reminders of a chat ordered by their next run
+func (s *SQLStorage) GetRemindersByChat(ctx context.Context, chatID int64) ([]Reminder, error) {
+	var reminders []Reminder
+	result := s.db.WithContext(ctx).Where("chat_id = ?", chatID).Order("next_run_at, id").Find(&reminders)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get reminders", "error", result.Error, "chat_id", chatID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return reminders, nil
+}
+
+// GetDueReminders retrieves reminders of all chats which should run until the given time, except reminders
+// of archived groups
+func (s *SQLStorage) GetDueReminders(ctx context.Context, until time.Time) ([]Reminder, error) {
+	var reminders []Reminder
+	db := s.db.WithContext(ctx)
+	archivedGroupIDs := db.Model(&MentionGroup{}).Select("id").Where("archived_at IS NOT NULL")
+	result := db.Where("next_run_at <= ? AND group_id NOT IN (?)", until.UTC(), archivedGroupIDs).Order("next_run_at, id").Find(&reminders)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get due reminders", "error", result.Error)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return reminders, nil
+}
+
+// UpdateReminderNextRun reschedules a recurring reminder
+func (s *SQLStorage) UpdateReminderNextRun(ctx context.Context, reminderID uint, nextRunAt time.Time) error {
+	result := s.db.WithContext(ctx).Model(&Reminder{}).Where("id = ?", reminderID).Update("next_run_at", nextRunAt.UTC())
+	if result.Error != nil {
+		slog.Error("storage: Failed to update reminder", "error", result.Error, "reminder_id", reminderID)
+		return errors.Join(ErrUpdate, result.Error)
+	}
+	return nil
+}
+
+// DeleteReminder deletes a reminder of the chat
+func (s *SQLStorage) DeleteReminder(ctx context.Context, chatID int64, reminderID uint) error {
+	result := s.db.WithContext(ctx).Where("id = ? AND chat_id = ?", reminderID, chatID).Delete(&Reminder{})
+	if result.Error != nil {
+		slog.Error("storage: Failed to delete reminder", "error", result.Error, "reminder_id", reminderID, "chat_id", chatID)
+		return errors.Join(ErrDelete, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetLastMention returns the time of the last mention, zero if there was none
+func (s *SQLStorage) GetLastMention(ctx context.Context, chatID int64, groupID uint, userID int64) (time.Time, error) {
+	var cooldown MentionCooldown
+	result := s.db.WithContext(ctx).Where("chat_id = ? AND group_id = ? AND user_id = ?", chatID, groupID, userID).Limit(1).Find(&cooldown)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get last mention", "error", result.Error, "chat_id", chatID, "group_id", groupID, "user_id", userID)
+		return time.Time{}, errors.Join(ErrGet, result.Error)
+	}
+	return cooldown.LastMentionAt, nil
+}
+
+// SaveLastMention creates or updates the time of the last mention
+func (s *SQLStorage) SaveLastMention(ctx context.Context, chatID int64, groupID uint, userID int64, at time.Time) error {
+	cooldown := MentionCooldown{
+		ChatID:        chatID,
+		GroupID:       groupID,
+		UserID:        userID,
+		LastMentionAt: at.UTC(),
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "group_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_mention_at"}),
+	}).Create(&cooldown).Error; err != nil {
+		slog.Error("storage: Failed to save last mention", "error", err, "chat_id", chatID, "group_id", groupID, "user_id", userID)
+		return errors.Join(ErrUpdate, err)
+	}
+	return nil
+}
+
+// SaveChatUser creates or updates the time the user was last seen in the chat
+func (s *SQLStorage) SaveChatUser(ctx context.Context, chatID int64, userID int64, at time.Time) error {
+	if userID == 0 {
+		return ErrZeroUserID
+	}
+
+	chatUser := ChatUser{
+		ChatID:     chatID,
+		UserID:     userID,
+		LastSeenAt: at.UTC(),
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen_at"}),
+	}).Create(&chatUser).Error; err != nil {
+		slog.Error("storage: Failed to save chat user", "error", err, "chat_id", chatID, "user_id", userID)
+		return errors.Join(ErrUpdate, err)
+	}
+	return nil
+}
+
+// GetUserSeenChatIDs retrieves IDs of chats where the user was seen, most recently seen first
+func (s *SQLStorage) GetUserSeenChatIDs(ctx context.Context, userID int64) ([]int64, error) {
+	var chatIDs []int64
+	result := s.db.WithContext(ctx).Model(&ChatUser{}).Where("user_id = ?", userID).Order("last_seen_at DESC, chat_id").Pluck("chat_id", &chatIDs)
+	if result.Error != nil {
+		slog.Error("storage: Failed to get user's seen chats", "error", result.Error, "user_id", userID)
+		return nil, errors.Join(ErrGet, result.Error)
+	}
+	return chatIDs, nil
+}
@@ -0,0 +1,1339 @@
+// Package storagetest provides a conformance test suite shared by all storage.Storage implementations.
+//
+// Implementations are checked by calling Run from their tests:
+//
+//	func TestMemoryStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return storage.NewMemory()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"telegram-group-mention-bot/storage"
+)
+
+// Factory creates a new empty storage for a single test
+type Factory func(t *testing.T) storage.Storage
+
+const (
+	chatID      int64 = -1001
+	otherChatID int64 = -1002
+	userID      int64 = 101
+	otherUserID int64 = 102
+)
+
+// Run runs the conformance suite against storages created by the factory
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, s storage.Storage)
+	}{
+		{"CreateAndGetGroup", testCreateAndGetGroup},
+		{"CreateGroupValidation", testCreateGroupValidation},
+		{"CreateGroupDuplicate", testCreateGroupDuplicate},
+		{"GetGroupNotFound", testGetGroupNotFound},
+		{"DeleteGroup", testDeleteGroup},
+		{"GroupsByChat", testGroupsByChat},
+		{"Users", testUsers},
+		{"Members", testMembers},
+		{"MemberDuplicate", testMemberDuplicate},
+		{"GroupsByUser", testGroupsByUser},
+		{"UserChatIDs", testUserChatIDs},
+		{"FindGroupsWithMembers", testFindGroupsWithMembers},
+		{"MigrateChatGroups", testMigrateChatGroups},
+		{"CommandPermissions", testCommandPermissions},
+		{"Aliases", testAliases},
+		{"AliasConflicts", testAliasConflicts},
+		{"DeleteGroupWithAliases", testDeleteGroupWithAliases},
+		{"Inclusions", testInclusions},
+		{"DeleteGroupWithInclusions", testDeleteGroupWithInclusions},
+		{"UserByUsername", testUserByUsername},
+		{"ChatUserByUsername", testChatUserByUsername},
+		{"ChatSettings", testChatSettings},
+		{"GetGroupByID", testGetGroupByID},
+		{"Reminders", testReminders},
+		{"DeleteGroupWithReminders", testDeleteGroupWithReminders},
+		{"LastMentions", testLastMentions},
+		{"MutedMembers", testMutedMembers},
+		{"ChatUsers", testChatUsers},
+		{"RemoveChatMember", testRemoveChatMember},
+		{"ArchiveChatGroups", testArchiveChatGroups},
+		{"Transaction", testTransaction},
+		{"NestedTransaction", testNestedTransaction},
+		{"ConcurrentTransaction", testConcurrentTransaction},
+		{"ExportImport", testExportImport},
+		{"Ping", testPing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage(t)
+			t.Cleanup(func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+			})
+			tt.test(t, context.Background(), s)
+		})
+	}
+}
+
+func testCreateAndGetGroup(t *testing.T, ctx context.Context, s storage.Storage) {
+	mustCreateGroup(t, ctx, s, "backend", chatID)
+
+	group, err := s.GetGroup(ctx, "backend", chatID)
+	if err != nil {
+		t.Fatalf("GetGroup() error = %v", err)
+	}
+	if group.ID == 0 || group.Name != "backend" || group.ChatID != chatID {
+		t.Errorf("GetGroup() = %+v, want group 'backend' in chat %d with non-zero ID", group, chatID)
+	}
+
+	// The same name is allowed in another chat
+	mustCreateGroup(t, ctx, s, "backend", otherChatID)
+}
+
+func testCreateGroupValidation(t *testing.T, ctx context.Context, s storage.Storage) {
+	if err := s.CreateGroup(ctx, "", chatID); !errors.Is(err, storage.ErrEmptyGroupName) {
+		t.Errorf("CreateGroup() with empty name error = %v, want %v", err, storage.ErrEmptyGroupName)
+	}
+}
+
+func testCreateGroupDuplicate(t *testing.T, ctx context.Context, s storage.Storage) {
+	mustCreateGroup(t, ctx, s, "backend", chatID)
+
+	err := s.CreateGroup(ctx, "backend", chatID)
+	if !errors.Is(err, storage.ErrCreate) || !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("CreateGroup() duplicate error = %v, want %v and %v", err, storage.ErrCreate, storage.ErrAlreadyExists)
+	}
+}
+
+func testGetGroupNotFound(t *testing.T, ctx context.Context, s storage.Storage) {
+	if _, err := s.GetGroup(ctx, "missing", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.GetUser(ctx, userID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetUser() error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testDeleteGroup(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustAddMember(t, ctx, s, group.ID, userID)
+
+	if err := s.DeleteGroup(ctx, group.ID); err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+	if _, err := s.GetGroup(ctx, "backend", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() after delete error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if members := mustGetMembers(t, ctx, s, group.ID); len(members) != 0 {
+		t.Errorf("GetGroupMembers() after delete = %d members, want 0", len(members))
+	}
+
+	// The name can be reused after deletion
+	mustCreateGroup(t, ctx, s, "backend", chatID)
+}
+
+func testGroupsByChat(t *testing.T, ctx context.Context, s storage.Storage) {
+	mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustCreateGroup(t, ctx, s, "frontend", chatID)
+	mustCreateGroup(t, ctx, s, "qa", otherChatID)
+
+	groups, err := s.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetGroupsByChat() error = %v", err)
+	}
+	assertGroupNames(t, "GetGroupsByChat()", groups, "backend", "frontend")
+
+	groups, err = s.GetGroupsByChat(ctx, 42)
+	if err != nil {
+		t.Fatalf("GetGroupsByChat() error = %v", err)
+	}
+	assertGroupNames(t, "GetGroupsByChat() for empty chat", groups)
+}
+
+func testUsers(t *testing.T, ctx context.Context, s storage.Storage) {
+	if _, err := s.CreateOrUpdateUser(ctx, 0, "nobody", "", ""); !errors.Is(err, storage.ErrZeroUserID) {
+		t.Errorf("CreateOrUpdateUser() with zero ID error = %v, want %v", err, storage.ErrZeroUserID)
+	}
+
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "john", "John", "Doe"); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "johnny", "Johnny", ""); err != nil {
+		t.Fatalf("CreateOrUpdateUser() update error = %v", err)
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	want := storage.User{ID: userID, Username: "johnny", FirstName: "Johnny"}
+	if *user != want {
+		t.Errorf("GetUser() = %+v, want %+v", *user, want)
+	}
+}
+
+func testMembers(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+
+	if err := s.AddMember(ctx, group.ID, nil); !errors.Is(err, storage.ErrNilUser) {
+		t.Errorf("AddMember() with nil user error = %v, want %v", err, storage.ErrNilUser)
+	}
+
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "john", "John", "Doe"); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+	mustAddMember(t, ctx, s, group.ID, userID)
+	// Membership doesn't require user data to be synced first
+	mustAddMember(t, ctx, s, group.ID, otherUserID)
+
+	assertMember(t, ctx, s, group.ID, userID, true)
+	assertMember(t, ctx, s, group.ID, otherUserID, true)
+
+	members := mustGetMembers(t, ctx, s, group.ID)
+	if len(members) != 2 {
+		t.Fatalf("GetGroupMembers() = %d members, want 2", len(members))
+	}
+	for _, member := range members {
+		if member.GroupID != group.ID {
+			t.Errorf("member.GroupID = %d, want %d", member.GroupID, group.ID)
+		}
+		if member.UserID == userID && member.User.Username != "john" {
+			t.Errorf("member.User = %+v, want preloaded user 'john'", member.User)
+		}
+	}
+
+	if err := s.RemoveMember(ctx, group.ID, userID); err != nil {
+		t.Fatalf("RemoveMember() error = %v", err)
+	}
+	assertMember(t, ctx, s, group.ID, userID, false)
+
+	// Removing a non-member is not an error
+	if err := s.RemoveMember(ctx, group.ID, userID); err != nil {
+		t.Errorf("RemoveMember() of non-member error = %v", err)
+	}
+}
+
+func testMemberDuplicate(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustAddMember(t, ctx, s, group.ID, userID)
+
+	err := s.AddMember(ctx, group.ID, &storage.User{ID: userID})
+	if !errors.Is(err, storage.ErrCreate) || !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("AddMember() duplicate error = %v, want %v and %v", err, storage.ErrCreate, storage.ErrAlreadyExists)
+	}
+}
+
+func testGroupsByUser(t *testing.T, ctx context.Context, s storage.Storage) {
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustCreateGroup(t, ctx, s, "frontend", chatID)
+	qa := mustCreateGroup(t, ctx, s, "qa", otherChatID)
+	mustAddMember(t, ctx, s, backend.ID, userID)
+	mustAddMember(t, ctx, s, qa.ID, userID)
+
+	groups, err := s.GetUserGroupsByChat(ctx, chatID, userID)
+	if err != nil {
+		t.Fatalf("GetUserGroupsByChat() error = %v", err)
+	}
+	assertGroupNames(t, "GetUserGroupsByChat()", groups, "backend")
+
+	groups, err = s.GetGroupsToJoinByChatAndUser(ctx, chatID, userID)
+	if err != nil {
+		t.Fatalf("GetGroupsToJoinByChatAndUser() error = %v", err)
+	}
+	assertGroupNames(t, "GetGroupsToJoinByChatAndUser()", groups, "frontend")
+}
+
+func testUserChatIDs(t *testing.T, ctx context.Context, s storage.Storage) {
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	frontend := mustCreateGroup(t, ctx, s, "frontend", chatID)
+	other := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	mustCreateGroup(t, ctx, s, "qa", -1003)
+	mustAddMember(t, ctx, s, backend.ID, userID)
+	mustAddMember(t, ctx, s, frontend.ID, userID)
+	mustAddMember(t, ctx, s, other.ID, userID)
+
+	chatIDs, err := s.GetUserChatIDs(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserChatIDs() error = %v", err)
+	}
+	if !slices.Equal(chatIDs, []int64{otherChatID, chatID}) {
+		t.Errorf("GetUserChatIDs() = %v, want %v", chatIDs, []int64{otherChatID, chatID})
+	}
+
+	chatIDs, err = s.GetUserChatIDs(ctx, otherUserID)
+	if err != nil {
+		t.Fatalf("GetUserChatIDs() error = %v", err)
+	}
+	if len(chatIDs) != 0 {
+		t.Errorf("GetUserChatIDs() of user without groups = %v, want none", chatIDs)
+	}
+}
+
+func testFindGroupsWithMembers(t *testing.T, ctx context.Context, s storage.Storage) {
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustCreateGroup(t, ctx, s, "frontend", chatID)
+	other := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "john", "John", "Doe"); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+	mustAddMember(t, ctx, s, backend.ID, userID)
+	mustAddMember(t, ctx, s, other.ID, otherUserID)
+
+	groups, err := s.FindGroupsByChatAndNamesWithMembers(ctx, chatID, nil)
+	if err != nil || len(groups) != 0 {
+		t.Errorf("FindGroupsByChatAndNamesWithMembers() without names = %v, %v, want no groups", groups, err)
+	}
+
+	groups, err = s.FindGroupsByChatAndNamesWithMembers(ctx, chatID, []string{"backend", "frontend", "missing"})
+	if err != nil {
+		t.Fatalf("FindGroupsByChatAndNamesWithMembers() error = %v", err)
+	}
+	assertGroupNames(t, "FindGroupsByChatAndNamesWithMembers()", groups, "backend", "frontend")
+	for _, group := range groups {
+		switch group.Name {
+		case "backend":
+			if len(group.Members) != 1 || group.Members[0].User.Username != "john" {
+				t.Errorf("backend members = %+v, want only preloaded user 'john'", group.Members)
+			}
+		case "frontend":
+			if len(group.Members) != 0 {
+				t.Errorf("frontend members = %+v, want none", group.Members)
+			}
+		}
+	}
+}
+
+func testMigrateChatGroups(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	if err := s.CreateAlias(ctx, group.ID, chatID, "be"); err != nil {
+		t.Fatalf("CreateAlias() error = %v", err)
+	}
+	if err := s.SetCommandPermission(ctx, chatID, "del", "admin"); err != nil {
+		t.Fatalf("SetCommandPermission() error = %v", err)
+	}
+
+	if err := s.MigrateChatGroups(ctx, chatID, otherChatID); err != nil {
+		t.Fatalf("MigrateChatGroups() error = %v", err)
+	}
+
+	if _, err := s.GetGroup(ctx, "backend", otherChatID); err != nil {
+		t.Errorf("GetGroup() in new chat error = %v", err)
+	}
+	if _, err := s.GetGroup(ctx, "backend", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() in old chat error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.GetGroup(ctx, "be", otherChatID); err != nil {
+		t.Errorf("GetGroup() by alias in new chat error = %v", err)
+	}
+
+	permissions, err := s.GetCommandPermissions(ctx, otherChatID)
+	if err != nil {
+		t.Fatalf("GetCommandPermissions() error = %v", err)
+	}
+	if len(permissions) != 1 || permissions[0].Command != "del" {
+		t.Errorf("GetCommandPermissions() in new chat = %+v, want the migrated 'del' permission", permissions)
+	}
+
+	// Names taken in the new chat fail the migration without moving anything, aliases count as names
+	conflicts := []struct {
+		name  string
+		group string
+		alias string
+	}{
+		{"group name taken by a group", "backend", ""},
+		{"group name taken by an alias", "be", ""},
+		{"alias taken by a group", "frontend", "backend"},
+	}
+	for _, tt := range conflicts {
+		conflicting := mustCreateGroup(t, ctx, s, tt.group, chatID)
+		if tt.alias != "" {
+			if err := s.CreateAlias(ctx, conflicting.ID, chatID, tt.alias); err != nil {
+				t.Fatalf("%s: CreateAlias() error = %v", tt.name, err)
+			}
+		}
+
+		err := s.MigrateChatGroups(ctx, chatID, otherChatID)
+		if !errors.Is(err, storage.ErrUpdate) || !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("%s: MigrateChatGroups() error = %v, want %v and %v", tt.name, err, storage.ErrUpdate, storage.ErrAlreadyExists)
+		}
+		groups, err := s.GetGroupsByChat(ctx, chatID)
+		if err != nil {
+			t.Fatalf("GetGroupsByChat() error = %v", err)
+		}
+		assertGroupNames(t, tt.name+": GetGroupsByChat() in old chat", groups, tt.group)
+
+		if err := s.DeleteGroup(ctx, conflicting.ID); err != nil {
+			t.Fatalf("DeleteGroup() error = %v", err)
+		}
+	}
+}
+
+func testCommandPermissions(t *testing.T, ctx context.Context, s storage.Storage) {
+	if err := s.SetCommandPermission(ctx, chatID, "", "admin"); !errors.Is(err, storage.ErrEmptyCommand) {
+		t.Errorf("SetCommandPermission() with empty command error = %v, want %v", err, storage.ErrEmptyCommand)
+	}
+
+	for _, role := range []string{"member", "admin"} {
+		if err := s.SetCommandPermission(ctx, chatID, "del", role); err != nil {
+			t.Fatalf("SetCommandPermission() error = %v", err)
+		}
+	}
+	if err := s.SetCommandPermission(ctx, otherChatID, "del", "creator"); err != nil {
+		t.Fatalf("SetCommandPermission() error = %v", err)
+	}
+
+	permissions, err := s.GetCommandPermissions(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetCommandPermissions() error = %v", err)
+	}
+	if len(permissions) != 1 || permissions[0].Command != "del" || permissions[0].Role != "admin" {
+		t.Errorf("GetCommandPermissions() = %+v, want only 'del' with role 'admin'", permissions)
+	}
+}
+
+func testAliases(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustCreateGroup(t, ctx, s, "frontend", chatID)
+	mustAddMember(t, ctx, s, group.ID, 1)
+
+	if err := s.CreateAlias(ctx, group.ID, chatID, "be"); err != nil {
+		t.Fatalf("CreateAlias() error = %v", err)
+	}
+
+	got, err := s.GetGroup(ctx, "be", chatID)
+	if err != nil {
+		t.Fatalf("GetGroup() by alias error = %v", err)
+	}
+	if got.ID != group.ID {
+		t.Errorf("GetGroup() by alias = %+v, want group %d", got, group.ID)
+	}
+	if _, err := s.GetGroup(ctx, "be", otherChatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() by alias in other chat error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	found, err := s.FindGroupsByChatAndNamesWithMembers(ctx, chatID, []string{"be", "backend", "frontend"})
+	if err != nil {
+		t.Fatalf("FindGroupsByChatAndNamesWithMembers() error = %v", err)
+	}
+	assertGroupNames(t, "FindGroupsByChatAndNamesWithMembers()", found, "backend", "frontend")
+	if len(found) > 0 && len(found[0].Members) != 1 {
+		t.Errorf("FindGroupsByChatAndNamesWithMembers() members of backend = %d, want 1", len(found[0].Members))
+	}
+
+	groups, err := s.GetGroupsByChat(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetGroupsByChat() error = %v", err)
+	}
+	if len(groups) != 2 || len(groups[0].Aliases) != 1 || groups[0].Aliases[0].Name != "be" {
+		t.Errorf("GetGroupsByChat() = %+v, want backend with alias 'be'", groups)
+	}
+
+	if err := s.DeleteAlias(ctx, chatID, "be"); err != nil {
+		t.Fatalf("DeleteAlias() error = %v", err)
+	}
+	if _, err := s.GetGroup(ctx, "be", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() by deleted alias error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := s.DeleteAlias(ctx, chatID, "be"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteAlias() of missing alias error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testAliasConflicts(t *testing.T, ctx context.Context, s storage.Storage) {
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	frontend := mustCreateGroup(t, ctx, s, "frontend", chatID)
+
+	if err := s.CreateAlias(ctx, backend.ID, chatID, ""); !errors.Is(err, storage.ErrEmptyGroupName) {
+		t.Errorf("CreateAlias() with empty name error = %v, want %v", err, storage.ErrEmptyGroupName)
+	}
+	if err := s.CreateAlias(ctx, backend.ID, chatID, "frontend"); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("CreateAlias() with group name error = %v, want %v", err, storage.ErrAlreadyExists)
+	}
+	if err := s.CreateAlias(ctx, backend.ID, chatID, "be"); err != nil {
+		t.Fatalf("CreateAlias() error = %v", err)
+	}
+	if err := s.CreateAlias(ctx, frontend.ID, chatID, "be"); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("CreateAlias() with taken alias error = %v, want %v", err, storage.ErrAlreadyExists)
+	}
+	if err := s.CreateGroup(ctx, "be", chatID); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("CreateGroup() with alias name error = %v, want %v", err, storage.ErrAlreadyExists)
+	}
+
+	// Names are scoped by chat
+	other := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	if err := s.CreateAlias(ctx, other.ID, otherChatID, "be"); err != nil {
+		t.Errorf("CreateAlias() in other chat error = %v", err)
+	}
+}
+
+func testDeleteGroupWithAliases(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	if err := s.CreateAlias(ctx, group.ID, chatID, "be"); err != nil {
+		t.Fatalf("CreateAlias() error = %v", err)
+	}
+
+	if err := s.DeleteGroup(ctx, group.ID); err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+
+	if _, err := s.GetGroup(ctx, "be", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() by alias of deleted group error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := s.CreateGroup(ctx, "be", chatID); err != nil {
+		t.Errorf("CreateGroup() with freed alias name error = %v", err)
+	}
+}
+
+func testInclusions(t *testing.T, ctx context.Context, s storage.Storage) {
+	engineering := mustCreateGroup(t, ctx, s, "engineering", chatID)
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	frontend := mustCreateGroup(t, ctx, s, "frontend", chatID)
+
+	for _, child := range []*storage.MentionGroup{backend, frontend} {
+		if err := s.IncludeGroup(ctx, engineering.ID, child.ID); err != nil {
+			t.Fatalf("IncludeGroup(%q) error = %v", child.Name, err)
+		}
+	}
+	if err := s.IncludeGroup(ctx, engineering.ID, backend.ID); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("IncludeGroup() duplicate error = %v, want %v", err, storage.ErrAlreadyExists)
+	}
+
+	included, err := s.GetIncludedGroups(ctx, engineering.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups()", included, "backend", "frontend")
+
+	included, err = s.GetIncludedGroups(ctx, backend.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() of child error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() of child", included)
+
+	if err := s.ExcludeGroup(ctx, engineering.ID, frontend.ID); err != nil {
+		t.Fatalf("ExcludeGroup() error = %v", err)
+	}
+	if err := s.ExcludeGroup(ctx, engineering.ID, frontend.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ExcludeGroup() of missing inclusion error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	included, err = s.GetIncludedGroups(ctx, engineering.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() after exclude error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() after exclude", included, "backend")
+}
+
+func testDeleteGroupWithInclusions(t *testing.T, ctx context.Context, s storage.Storage) {
+	engineering := mustCreateGroup(t, ctx, s, "engineering", chatID)
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	qa := mustCreateGroup(t, ctx, s, "qa", chatID)
+	if err := s.IncludeGroup(ctx, engineering.ID, backend.ID); err != nil {
+		t.Fatalf("IncludeGroup() error = %v", err)
+	}
+	if err := s.IncludeGroup(ctx, backend.ID, qa.ID); err != nil {
+		t.Fatalf("IncludeGroup() error = %v", err)
+	}
+
+	if err := s.DeleteGroup(ctx, backend.ID); err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+
+	included, err := s.GetIncludedGroups(ctx, engineering.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() of parent of deleted group", included)
+
+	included, err = s.GetIncludedGroups(ctx, backend.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() of deleted group error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() of deleted group", included)
+}
+
+func testUserByUsername(t *testing.T, ctx context.Context, s storage.Storage) {
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "Alice", "Alice", ""); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+	if _, err := s.CreateOrUpdateUser(ctx, otherUserID, "", "Bob", ""); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+
+	user, err := s.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUserByUsername() error = %v", err)
+	}
+	if user.ID != userID {
+		t.Errorf("GetUserByUsername() = %+v, want user %d", user, userID)
+	}
+
+	if _, err := s.GetUserByUsername(ctx, "bob"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetUserByUsername() of unknown username error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testChatUserByUsername(t *testing.T, ctx context.Context, s storage.Storage) {
+	const memberID int64 = 103
+	for _, id := range []int64{userID, otherUserID, memberID} {
+		// A username can be stale, so several users may have it
+		if _, err := s.CreateOrUpdateUser(ctx, id, "alice", "Alice", ""); err != nil {
+			t.Fatalf("CreateOrUpdateUser() error = %v", err)
+		}
+	}
+	now := time.Now()
+	if err := s.SaveChatUser(ctx, chatID, userID, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("SaveChatUser() error = %v", err)
+	}
+	if err := s.SaveChatUser(ctx, chatID, otherUserID, now); err != nil {
+		t.Fatalf("SaveChatUser() error = %v", err)
+	}
+	group := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	mustAddMember(t, ctx, s, group.ID, memberID)
+
+	user, err := s.GetChatUserByUsername(ctx, chatID, "Alice")
+	if err != nil {
+		t.Fatalf("GetChatUserByUsername() error = %v", err)
+	}
+	if user.ID != otherUserID {
+		t.Errorf("GetChatUserByUsername() = user %d, want the most recently seen user %d", user.ID, otherUserID)
+	}
+
+	// Members of groups of the chat are found even if they were never seen in it
+	user, err = s.GetChatUserByUsername(ctx, otherChatID, "alice")
+	if err != nil {
+		t.Fatalf("GetChatUserByUsername() of group member error = %v", err)
+	}
+	if user.ID != memberID {
+		t.Errorf("GetChatUserByUsername() of group member = user %d, want %d", user.ID, memberID)
+	}
+
+	if _, err := s.GetChatUserByUsername(ctx, -1003, "alice"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetChatUserByUsername() in other chat error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.GetChatUserByUsername(ctx, chatID, "bob"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetChatUserByUsername() of unknown username error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testChatSettings(t *testing.T, ctx context.Context, s storage.Storage) {
+	settings, err := s.GetChatSettings(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetChatSettings() error = %v", err)
+	}
+	if *settings != (storage.ChatSettings{ChatID: chatID}) {
+		t.Errorf("GetChatSettings() of new chat = %+v, want defaults", settings)
+	}
+
+	settings.AddConsent = "admin"
+	if err := s.SaveChatSettings(ctx, settings); err != nil {
+		t.Fatalf("SaveChatSettings() error = %v", err)
+	}
+	settings.AddConsent = "target"
+	settings.MentionSender = "yes"
+	settings.AutoAll = "no"
+	settings.FreeForm = "no"
+	settings.Language = "en"
+	if err := s.SaveChatSettings(ctx, settings); err != nil {
+		t.Fatalf("SaveChatSettings() update error = %v", err)
+	}
+
+	got, err := s.GetChatSettings(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetChatSettings() error = %v", err)
+	}
+	want := storage.ChatSettings{ChatID: chatID, AddConsent: "target", MentionSender: "yes", AutoAll: "no", FreeForm: "no", Language: "en"}
+	if *got != want {
+		t.Errorf("GetChatSettings() = %+v, want %+v", got, want)
+	}
+
+	if err := s.MigrateChatGroups(ctx, chatID, otherChatID); err != nil {
+		t.Fatalf("MigrateChatGroups() error = %v", err)
+	}
+	got, err = s.GetChatSettings(ctx, otherChatID)
+	if err != nil {
+		t.Fatalf("GetChatSettings() in new chat error = %v", err)
+	}
+	if got.AddConsent != "target" {
+		t.Errorf("GetChatSettings().AddConsent in new chat = %q, want %q", got.AddConsent, "target")
+	}
+}
+
+func testGetGroupByID(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+
+	got, err := s.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if got.Name != "backend" || got.ChatID != chatID {
+		t.Errorf("GetGroupByID() = %+v, want backend in chat %d", got, chatID)
+	}
+
+	if _, err := s.GetGroupByID(ctx, group.ID+100); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroupByID() of missing group error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testReminders(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	now := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	later := mustCreateReminder(t, ctx, s, group.ID, chatID, now.Add(2*time.Hour))
+	// Times in other zones must be compared correctly
+	soon := mustCreateReminder(t, ctx, s, group.ID, chatID, now.Add(time.Hour).In(time.FixedZone("UTC+5", 5*3600)))
+	mustCreateReminder(t, ctx, s, group.ID, otherChatID, now.Add(3*time.Hour))
+
+	reminders, err := s.GetRemindersByChat(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetRemindersByChat() error = %v", err)
+	}
+	if len(reminders) != 2 || reminders[0].ID != soon.ID || reminders[1].ID != later.ID {
+		t.Errorf("GetRemindersByChat() = %+v, want reminders %d and %d", reminders, soon.ID, later.ID)
+	}
+	if len(reminders) > 0 && !reminders[0].NextRunAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("GetRemindersByChat()[0].NextRunAt = %v, want %v", reminders[0].NextRunAt, now.Add(time.Hour))
+	}
+
+	due, err := s.GetDueReminders(ctx, now.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("GetDueReminders() error = %v", err)
+	}
+	if len(due) != 1 || due[0].ID != soon.ID {
+		t.Errorf("GetDueReminders() = %+v, want only reminder %d", due, soon.ID)
+	}
+
+	if err := s.UpdateReminderNextRun(ctx, soon.ID, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("UpdateReminderNextRun() error = %v", err)
+	}
+	due, err = s.GetDueReminders(ctx, now.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("GetDueReminders() error = %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("GetDueReminders() after reschedule = %+v, want none", due)
+	}
+
+	if err := s.DeleteReminder(ctx, otherChatID, later.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteReminder() from other chat error = %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := s.DeleteReminder(ctx, chatID, later.ID); err != nil {
+		t.Fatalf("DeleteReminder() error = %v", err)
+	}
+	reminders, err = s.GetRemindersByChat(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetRemindersByChat() error = %v", err)
+	}
+	if len(reminders) != 1 || reminders[0].ID != soon.ID {
+		t.Errorf("GetRemindersByChat() after delete = %+v, want only reminder %d", reminders, soon.ID)
+	}
+}
+
+func testDeleteGroupWithReminders(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustCreateReminder(t, ctx, s, group.ID, chatID, time.Now())
+
+	if err := s.DeleteGroup(ctx, group.ID); err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+
+	reminders, err := s.GetRemindersByChat(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetRemindersByChat() error = %v", err)
+	}
+	if len(reminders) != 0 {
+		t.Errorf("GetRemindersByChat() after group delete = %+v, want none", reminders)
+	}
+}
+
+func testLastMentions(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+
+	assertLastMention(t, ctx, s, chatID, group.ID, 0, time.Time{})
+
+	first := time.Now().Truncate(time.Second)
+	second := first.Add(time.Minute)
+	for _, at := range []time.Time{first, second.In(time.FixedZone("UTC+5", 5*3600))} {
+		if err := s.SaveLastMention(ctx, chatID, group.ID, 0, at); err != nil {
+			t.Fatalf("SaveLastMention() error = %v", err)
+		}
+	}
+	if err := s.SaveLastMention(ctx, chatID, 0, userID, first); err != nil {
+		t.Fatalf("SaveLastMention() of user error = %v", err)
+	}
+
+	assertLastMention(t, ctx, s, chatID, group.ID, 0, second)
+	assertLastMention(t, ctx, s, chatID, 0, userID, first)
+	assertLastMention(t, ctx, s, chatID, 0, otherUserID, time.Time{})
+
+	if err := s.MigrateChatGroups(ctx, chatID, otherChatID); err != nil {
+		t.Fatalf("MigrateChatGroups() error = %v", err)
+	}
+	assertLastMention(t, ctx, s, otherChatID, group.ID, 0, second)
+	assertLastMention(t, ctx, s, otherChatID, 0, userID, first)
+
+	if err := s.DeleteGroup(ctx, group.ID); err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+	assertLastMention(t, ctx, s, otherChatID, group.ID, 0, time.Time{})
+	assertLastMention(t, ctx, s, otherChatID, 0, userID, first)
+}
+
+func testMutedMembers(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	mustAddMember(t, ctx, s, group.ID, userID)
+	mustAddMember(t, ctx, s, group.ID, otherUserID)
+
+	if err := s.SetMemberMuted(ctx, group.ID, userID, true); err != nil {
+		t.Fatalf("SetMemberMuted() error = %v", err)
+	}
+	if err := s.SetMemberMuted(ctx, group.ID, -1, true); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("SetMemberMuted() of non-member error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	for _, member := range mustGetMembers(t, ctx, s, group.ID) {
+		if want := member.UserID == userID; member.Muted != want {
+			t.Errorf("member %d muted = %v, want %v", member.UserID, member.Muted, want)
+		}
+	}
+
+	groups, err := s.FindGroupsByChatAndNamesWithMembers(ctx, chatID, []string{"backend"})
+	if err != nil {
+		t.Fatalf("FindGroupsByChatAndNamesWithMembers() error = %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Members) != 2 {
+		t.Fatalf("FindGroupsByChatAndNamesWithMembers() = %+v, want the group with both members", groups)
+	}
+	for _, member := range groups[0].Members {
+		if want := member.UserID == userID; member.Muted != want {
+			t.Errorf("found member %d muted = %v, want %v", member.UserID, member.Muted, want)
+		}
+	}
+
+	if err := s.SetMemberMuted(ctx, group.ID, userID, false); err != nil {
+		t.Fatalf("SetMemberMuted() error = %v", err)
+	}
+	for _, member := range mustGetMembers(t, ctx, s, group.ID) {
+		if member.Muted {
+			t.Errorf("member %d muted after unmuting, want not muted", member.UserID)
+		}
+	}
+}
+
+func testChatUsers(t *testing.T, ctx context.Context, s storage.Storage) {
+	if err := s.SaveChatUser(ctx, chatID, 0, time.Now()); !errors.Is(err, storage.ErrZeroUserID) {
+		t.Errorf("SaveChatUser() with zero user error = %v, want %v", err, storage.ErrZeroUserID)
+	}
+
+	first := time.Now().Truncate(time.Second)
+	for _, seen := range []struct {
+		chatID int64
+		at     time.Time
+	}{
+		{chatID, first},
+		{otherChatID, first.Add(time.Minute)},
+		// Seeing the user again moves the chat up
+		{chatID, first.Add(2 * time.Minute)},
+	} {
+		if err := s.SaveChatUser(ctx, seen.chatID, userID, seen.at); err != nil {
+			t.Fatalf("SaveChatUser() error = %v", err)
+		}
+	}
+	assertSeenChatIDs(t, ctx, s, userID, chatID, otherChatID)
+	assertSeenChatIDs(t, ctx, s, otherUserID)
+
+	// The first message in the new chat may be seen before the migration
+	if err := s.SaveChatUser(ctx, -1003, userID, first.Add(3*time.Minute)); err != nil {
+		t.Fatalf("SaveChatUser() error = %v", err)
+	}
+	if err := s.MigrateChatGroups(ctx, chatID, -1003); err != nil {
+		t.Fatalf("MigrateChatGroups() error = %v", err)
+	}
+	assertSeenChatIDs(t, ctx, s, userID, -1003, otherChatID)
+}
+
+func testRemoveChatMember(t *testing.T, ctx context.Context, s storage.Storage) {
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	all := mustCreateGroup(t, ctx, s, "all", chatID)
+	other := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	for _, groupID := range []uint{backend.ID, all.ID, other.ID} {
+		mustAddMember(t, ctx, s, groupID, userID)
+	}
+	mustAddMember(t, ctx, s, all.ID, otherUserID)
+	for _, id := range []int64{chatID, otherChatID} {
+		if err := s.SaveChatUser(ctx, id, userID, time.Now()); err != nil {
+			t.Fatalf("SaveChatUser() error = %v", err)
+		}
+	}
+
+	if err := s.RemoveChatMember(ctx, chatID, userID); err != nil {
+		t.Fatalf("RemoveChatMember() error = %v", err)
+	}
+
+	assertMember(t, ctx, s, backend.ID, userID, false)
+	assertMember(t, ctx, s, all.ID, userID, false)
+	assertMember(t, ctx, s, all.ID, otherUserID, true)
+	assertMember(t, ctx, s, other.ID, userID, true)
+	assertSeenChatIDs(t, ctx, s, userID, otherChatID)
+
+	if err := s.RemoveChatMember(ctx, chatID, userID); err != nil {
+		t.Errorf("RemoveChatMember() of removed user error = %v", err)
+	}
+}
+
+func testArchiveChatGroups(t *testing.T, ctx context.Context, s storage.Storage) {
+	group := mustCreateGroup(t, ctx, s, "backend", chatID)
+	other := mustCreateGroup(t, ctx, s, "backend", otherChatID)
+	mustAddMember(t, ctx, s, group.ID, userID)
+	mustAddMember(t, ctx, s, other.ID, userID)
+	now := time.Now().Truncate(time.Second)
+	reminder := mustCreateReminder(t, ctx, s, group.ID, chatID, now.Add(-time.Minute))
+	otherReminder := mustCreateReminder(t, ctx, s, other.ID, otherChatID, now.Add(-time.Minute))
+
+	if err := s.ArchiveChatGroups(ctx, chatID, now); err != nil {
+		t.Fatalf("ArchiveChatGroups() error = %v", err)
+	}
+
+	archived, err := s.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if archived.ArchivedAt == nil || !archived.ArchivedAt.Equal(now) {
+		t.Errorf("ArchivedAt = %v, want %v", archived.ArchivedAt, now)
+	}
+	// Archived groups keep their members
+	assertMember(t, ctx, s, group.ID, userID, true)
+
+	chatIDs, err := s.GetUserChatIDs(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserChatIDs() error = %v", err)
+	}
+	if !slices.Equal(chatIDs, []int64{otherChatID}) {
+		t.Errorf("GetUserChatIDs() = %v, want only %d", chatIDs, otherChatID)
+	}
+	assertDueReminders(t, ctx, s, now, otherReminder.ID)
+
+	if err := s.RestoreChatGroups(ctx, chatID); err != nil {
+		t.Fatalf("RestoreChatGroups() error = %v", err)
+	}
+	restored, err := s.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if restored.ArchivedAt != nil {
+		t.Errorf("ArchivedAt after restoring = %v, want nil", restored.ArchivedAt)
+	}
+	assertDueReminders(t, ctx, s, now, reminder.ID, otherReminder.ID)
+
+	// The chat may be migrated to a supergroup after the bot has been removed from the old group
+	if err := s.ArchiveChatGroups(ctx, chatID, now); err != nil {
+		t.Fatalf("ArchiveChatGroups() error = %v", err)
+	}
+	if err := s.MigrateChatGroups(ctx, chatID, -1003); err != nil {
+		t.Fatalf("MigrateChatGroups() error = %v", err)
+	}
+	migrated, err := s.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID() error = %v", err)
+	}
+	if migrated.ArchivedAt != nil {
+		t.Errorf("ArchivedAt after migration = %v, want nil", migrated.ArchivedAt)
+	}
+}
+
+func testTransaction(t *testing.T, ctx context.Context, s storage.Storage) {
+	errRollback := errors.New("rollback")
+	err := s.Transaction(ctx, func(tx storage.Storage) error {
+		group := mustCreateGroup(t, ctx, tx, "backend", chatID)
+		mustAddMember(t, ctx, tx, group.ID, userID)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transaction() error = %v, want %v", err, errRollback)
+	}
+	if _, err := s.GetGroup(ctx, "backend", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() after rollback error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	err = s.Transaction(ctx, func(tx storage.Storage) error {
+		group := mustCreateGroup(t, ctx, tx, "backend", chatID)
+		mustAddMember(t, ctx, tx, group.ID, userID)
+		// A failed nested operation doesn't break the transaction
+		if err := tx.CreateGroup(ctx, "backend", chatID); !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("CreateGroup() of duplicate error = %v, want %v", err, storage.ErrAlreadyExists)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	group, err := s.GetGroup(ctx, "backend", chatID)
+	if err != nil {
+		t.Fatalf("GetGroup() after commit error = %v", err)
+	}
+	assertMember(t, ctx, s, group.ID, userID, true)
+}
+
+func testNestedTransaction(t *testing.T, ctx context.Context, s storage.Storage) {
+	errRollback := errors.New("rollback")
+	err := s.Transaction(ctx, func(tx storage.Storage) error {
+		mustCreateGroup(t, ctx, tx, "backend", chatID)
+		// A failed nested transaction rolls back only its own changes, like a savepoint
+		err := tx.Transaction(ctx, func(nested storage.Storage) error {
+			mustCreateGroup(t, ctx, nested, "frontend", chatID)
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Errorf("nested Transaction() error = %v, want %v", err, errRollback)
+		}
+		return tx.Transaction(ctx, func(nested storage.Storage) error {
+			mustCreateGroup(t, ctx, nested, "mobile", chatID)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	for _, name := range []string{"backend", "mobile"} {
+		if _, err := s.GetGroup(ctx, name, chatID); err != nil {
+			t.Errorf("GetGroup(%s) after commit error = %v", name, err)
+		}
+	}
+	if _, err := s.GetGroup(ctx, "frontend", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() of rolled back nested group error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	// Changes of a committed nested transaction are still rolled back with the outer one
+	err = s.Transaction(ctx, func(tx storage.Storage) error {
+		if err := tx.Transaction(ctx, func(nested storage.Storage) error {
+			mustCreateGroup(t, ctx, nested, "design", chatID)
+			return nil
+		}); err != nil {
+			t.Errorf("nested Transaction() error = %v", err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transaction() error = %v, want %v", err, errRollback)
+	}
+	if _, err := s.GetGroup(ctx, "design", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() after outer rollback error = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testConcurrentTransaction(t *testing.T, ctx context.Context, s storage.Storage) {
+	errRollback := errors.New("rollback")
+	written := make(chan error, 1)
+	err := s.Transaction(ctx, func(tx storage.Storage) error {
+		mustCreateGroup(t, ctx, tx, "backend", chatID)
+		// Another caller writes while the transaction is open, it may have to wait until the transaction ends
+		go func() {
+			written <- s.CreateGroup(ctx, "frontend", otherChatID)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transaction() error = %v, want %v", err, errRollback)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("concurrent CreateGroup() error = %v", err)
+	}
+
+	if _, err := s.GetGroup(ctx, "backend", chatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() after rollback error = %v, want %v", err, storage.ErrNotFound)
+	}
+	// The rollback must not lose changes made outside of the transaction
+	if _, err := s.GetGroup(ctx, "frontend", otherChatID); err != nil {
+		t.Errorf("GetGroup() of concurrently created group error = %v", err)
+	}
+}
+
+func testExportImport(t *testing.T, ctx context.Context, s storage.Storage) {
+	const newUserID int64 = 103
+
+	backend := mustCreateGroup(t, ctx, s, "backend", chatID)
+	empty := mustCreateGroup(t, ctx, s, "empty", chatID)
+	for _, alias := range []string{"server", "be"} {
+		if err := s.CreateAlias(ctx, backend.ID, chatID, alias); err != nil {
+			t.Fatalf("CreateAlias(%s) error = %v", alias, err)
+		}
+	}
+	if err := s.IncludeGroup(ctx, backend.ID, empty.ID); err != nil {
+		t.Fatalf("IncludeGroup() error = %v", err)
+	}
+	if _, err := s.CreateOrUpdateUser(ctx, userID, "alice", "Alice", "Smith"); err != nil {
+		t.Fatalf("CreateOrUpdateUser() error = %v", err)
+	}
+	mustAddMember(t, ctx, s, backend.ID, userID)
+
+	// The other chat already has an alias taken from the document and an inclusion making a cycle with it
+	other := mustCreateGroup(t, ctx, s, "other", otherChatID)
+	if err := s.CreateAlias(ctx, other.ID, otherChatID, "be"); err != nil {
+		t.Fatalf("CreateAlias() error = %v", err)
+	}
+	otherEmpty := mustCreateGroup(t, ctx, s, "empty", otherChatID)
+	if err := s.IncludeGroup(ctx, otherEmpty.ID, other.ID); err != nil {
+		t.Fatalf("IncludeGroup() error = %v", err)
+	}
+
+	exportedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	export, err := storage.ExportChat(ctx, s, chatID, exportedAt)
+	if err != nil {
+		t.Fatalf("ExportChat() error = %v", err)
+	}
+	want := &storage.ChatExport{
+		Version:    storage.ExportVersion,
+		ChatID:     chatID,
+		ExportedAt: exportedAt,
+		Groups: []storage.GroupExport{
+			{
+				Name:     "backend",
+				Aliases:  []string{"be", "server"},
+				Includes: []string{"empty"},
+				Members:  []storage.MemberExport{{UserID: userID, Name: "Alice Smith", Username: "alice"}},
+			},
+			{Name: "empty", Members: []storage.MemberExport{}},
+		},
+	}
+	if !reflect.DeepEqual(export, want) {
+		t.Fatalf("ExportChat() = %+v, want %+v", export, want)
+	}
+
+	data, err := export.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	parsed, err := storage.ParseChatExport(data)
+	if err != nil {
+		t.Fatalf("ParseChatExport() error = %v", err)
+	}
+	if !reflect.DeepEqual(parsed, want) {
+		t.Errorf("ParseChatExport() = %+v, want %+v", parsed, want)
+	}
+
+	// The document is merged into the other chat, its own groups are kept
+	parsed.Groups[0].Members = append(parsed.Groups[0].Members, storage.MemberExport{UserID: newUserID, Name: "Bob", Username: "bob"})
+	parsed.Groups = append(parsed.Groups, storage.GroupExport{Name: "other", Includes: []string{"backend"}, Members: []storage.MemberExport{{UserID: userID}}})
+	plan, err := storage.PlanImport(ctx, s, otherChatID, parsed)
+	if err != nil {
+		t.Fatalf("PlanImport() error = %v", err)
+	}
+	if plan.Empty() || len(plan.Groups) != 3 || !plan.Groups[0].Create || len(plan.Groups[0].NewMembers) != 2 || plan.Groups[2].Create {
+		t.Fatalf("PlanImport() = %+v", plan)
+	}
+	if !slices.Equal(plan.Groups[0].NewAliases, []string{"server"}) || !slices.Equal(plan.Groups[0].SkippedAliases, []string{"be"}) {
+		t.Errorf("PlanImport() aliases = %v, skipped %v, want [server], skipped [be]", plan.Groups[0].NewAliases, plan.Groups[0].SkippedAliases)
+	}
+	if !slices.Equal(plan.Groups[0].NewIncludes, []string{"empty"}) {
+		t.Errorf("PlanImport() inclusions = %v, want [empty]", plan.Groups[0].NewIncludes)
+	}
+	// other -> backend -> empty -> other
+	if len(plan.Groups[2].NewIncludes) != 0 || !slices.Equal(plan.Groups[2].SkippedIncludes, []string{"backend"}) {
+		t.Errorf("PlanImport() inclusions of other = %v, skipped %v, want none, skipped [backend]", plan.Groups[2].NewIncludes, plan.Groups[2].SkippedIncludes)
+	}
+	if _, err := s.GetGroup(ctx, "backend", otherChatID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetGroup() after PlanImport() error = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if _, err := storage.ImportChat(ctx, s, otherChatID, parsed); err != nil {
+		t.Fatalf("ImportChat() error = %v", err)
+	}
+	imported, err := s.GetGroup(ctx, "backend", otherChatID)
+	if err != nil {
+		t.Fatalf("GetGroup() after ImportChat() error = %v", err)
+	}
+	assertMember(t, ctx, s, imported.ID, userID, true)
+	assertMember(t, ctx, s, imported.ID, newUserID, true)
+	if byAlias, err := s.GetGroup(ctx, "server", otherChatID); err != nil || byAlias.ID != imported.ID {
+		t.Errorf("GetGroup() by imported alias = %+v, %v, want group %d", byAlias, err, imported.ID)
+	}
+	if byAlias, err := s.GetGroup(ctx, "be", otherChatID); err != nil || byAlias.ID != other.ID {
+		t.Errorf("GetGroup() by skipped alias = %+v, %v, want group %d", byAlias, err, other.ID)
+	}
+	included, err := s.GetIncludedGroups(ctx, imported.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() of imported group", included, "empty")
+	included, err = s.GetIncludedGroups(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("GetIncludedGroups() error = %v", err)
+	}
+	assertGroupNames(t, "GetIncludedGroups() of group with skipped inclusion", included)
+	if _, err := s.GetGroup(ctx, "empty", otherChatID); err != nil {
+		t.Errorf("GetGroup() of imported empty group error = %v", err)
+	}
+	user, err := s.GetUser(ctx, newUserID)
+	if err != nil {
+		t.Fatalf("GetUser() of imported user error = %v", err)
+	}
+	if user.FirstName != "Bob" || user.Username != "bob" {
+		t.Errorf("GetUser() of imported user = %+v", user)
+	}
+	known, err := s.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if known.LastName != "Smith" {
+		t.Errorf("ImportChat() changed the known user: %+v", known)
+	}
+
+	plan, err = storage.PlanImport(ctx, s, otherChatID, parsed)
+	if err != nil {
+		t.Fatalf("PlanImport() after import error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("PlanImport() after import = %+v, want empty", plan)
+	}
+
+	for _, document := range []string{
+		`{`,
+		`{"version": 3, "groups": []}`,
+		`{"version": 2, "groups": [{"name": "a", "aliases": ["b"]}, {"name": "b"}]}`,
+		`{"version": 2, "groups": [{"name": "a", "includes": ["b"]}]}`,
+		`{"version": 2, "groups": [{"name": "a", "includes": ["a"]}]}`,
+		`{"version": 1, "groups": [{"name": ""}]}`,
+		`{"version": 1, "groups": [{"name": "a"}, {"name": "a"}]}`,
+		`{"version": 1, "groups": [{"name": "a", "members": [{"user_id": 0}]}]}`,
+		`{"version": 1, "groups": [{"name": "a", "members": [{"user_id": 1}, {"user_id": 1}]}]}`,
+		`{"version": 1, "unknown": true}`,
+	} {
+		if _, err := storage.ParseChatExport([]byte(document)); !errors.Is(err, storage.ErrInvalidExport) {
+			t.Errorf("ParseChatExport(%s) error = %v, want %v", document, err, storage.ErrInvalidExport)
+		}
+	}
+}
+
+func assertDueReminders(t *testing.T, ctx context.Context, s storage.Storage, until time.Time, want ...uint) {
+	t.Helper()
+
+	reminders, err := s.GetDueReminders(ctx, until)
+	if err != nil {
+		t.Fatalf("GetDueReminders() error = %v", err)
+	}
+	ids := make([]uint, 0, len(reminders))
+	for _, reminder := range reminders {
+		ids = append(ids, reminder.ID)
+	}
+	if !slices.Equal(ids, want) {
+		t.Errorf("GetDueReminders() = %v, want %v", ids, want)
+	}
+}
+
+func assertSeenChatIDs(t *testing.T, ctx context.Context, s storage.Storage, userID int64, want ...int64) {
+	t.Helper()
+
+	chatIDs, err := s.GetUserSeenChatIDs(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserSeenChatIDs() error = %v", err)
+	}
+	if !slices.Equal(chatIDs, want) {
+		t.Errorf("GetUserSeenChatIDs(%d) = %v, want %v", userID, chatIDs, want)
+	}
+}
+
+func assertLastMention(t *testing.T, ctx context.Context, s storage.Storage, chatID int64, groupID uint, userID int64, want time.Time) {
+	t.Helper()
+
+	got, err := s.GetLastMention(ctx, chatID, groupID, userID)
+	if err != nil {
+		t.Fatalf("GetLastMention(%d, %d, %d) error = %v", chatID, groupID, userID, err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("GetLastMention(%d, %d, %d) = %v, want %v", chatID, groupID, userID, got, want)
+	}
+}
+
+func mustCreateReminder(t *testing.T, ctx context.Context, s storage.Storage, groupID uint, chatID int64, nextRunAt time.Time) *storage.Reminder {
+	t.Helper()
+
+	reminder := &storage.Reminder{
+		ChatID:    chatID,
+		GroupID:   groupID,
+		CreatorID: userID,
+		Text:      "standup",
+		NextRunAt: nextRunAt,
+	}
+	if err := s.CreateReminder(ctx, reminder); err != nil {
+		t.Fatalf("CreateReminder() error = %v", err)
+	}
+	if reminder.ID == 0 {
+		t.Fatalf("CreateReminder() didn't set the ID")
+	}
+	return reminder
+}
+
+func mustCreateGroup(t *testing.T, ctx context.Context, s storage.Storage, name string, chatID int64) *storage.MentionGroup {
+	t.Helper()
+
+	if err := s.CreateGroup(ctx, name, chatID); err != nil {
+		t.Fatalf("CreateGroup(%q) error = %v", name, err)
+	}
+	group, err := s.GetGroup(ctx, name, chatID)
+	if err != nil {
+		t.Fatalf("GetGroup(%q) error = %v", name, err)
+	}
+	return group
+}
+
+func mustAddMember(t *testing.T, ctx context.Context, s storage.Storage, groupID uint, userID int64) {
+	t.Helper()
+
+	if err := s.AddMember(ctx, groupID, &storage.User{ID: userID}); err != nil {
+		t.Fatalf("AddMember(%d, %d) error = %v", groupID, userID, err)
+	}
+}
+
+func mustGetMembers(t *testing.T, ctx context.Context, s storage.Storage, groupID uint) []storage.GroupMember {
+	t.Helper()
+
+	members, err := s.GetGroupMembers(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroupMembers(%d) error = %v", groupID, err)
+	}
+	return members
+}
+
+func assertMember(t *testing.T, ctx context.Context, s storage.Storage, groupID uint, userID int64, want bool) {
+	t.Helper()
+
+	isMember, err := s.IsMember(ctx, groupID, userID)
+	if err != nil {
+		t.Fatalf("IsMember(%d, %d) error = %v", groupID, userID, err)
+	}
+	if isMember != want {
+		t.Errorf("IsMember(%d, %d) = %t, want %t", groupID, userID, isMember, want)
+	}
+}
+
+func assertGroupNames(t *testing.T, call string, groups []storage.MentionGroup, want ...string) {
+	t.Helper()
+
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	slices.Sort(names)
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("%s = %v, want %v", call, names, want)
+	}
+}
+
+func testPing(t *testing.T, ctx context.Context, s storage.Storage) {
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+}